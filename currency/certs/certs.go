@@ -0,0 +1,229 @@
+// Package certs builds TLS configs for the currency service and its clients
+// from PEM files, and reloads the files when they change so that certificates
+// can be rotated without a restart.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// Reloader holds a certificate and a CA pool loaded from files and reloads
+// them whenever one of the files changes.
+type Reloader struct {
+	log      *logrus.Logger
+	certFile string
+	keyFile  string
+	caFile   string
+	mutex    *sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	watcher  *fsnotify.Watcher
+}
+
+// NewReloader loads the key pair from certFile and keyFile and the CA
+// certificates from caFile. Either the key pair or the CA file can be left
+// empty, a client without mTLS only needs a CA and a server without mTLS only
+// needs a key pair.
+func NewReloader(l *logrus.Logger, certFile, keyFile, caFile string) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both the certificate and the key file are required")
+	}
+
+	r := &Reloader{
+		log:      l,
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		mutex:    &sync.RWMutex{},
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if err := r.watch(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. The previous certificate and CA pool are kept
+// when any of the files can't be loaded.
+func (r *Reloader) Reload() error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load key pair %s: %s", r.certFile, err)
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("unable to read CA file %s: %s", r.caFile, err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", r.caFile)
+		}
+	}
+
+	r.mutex.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.mutex.Unlock()
+	return nil
+}
+
+// watch reloads the files on every change in their directories. Directories
+// are watched rather than the files, since files are usually rotated by
+// renaming a new file over the old one, or by swapping a symlink.
+func (r *Reloader) watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch certificates: %s", err)
+	}
+
+	dirs := map[string]bool{}
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f != "" {
+			dirs[filepath.Dir(f)] = true
+		}
+	}
+	for d := range dirs {
+		if err := w.Add(d); err != nil {
+			w.Close()
+			return fmt.Errorf("unable to watch %s: %s", d, err)
+		}
+	}
+	r.watcher = w
+
+	go func() {
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if err := r.Reload(); err != nil {
+					// a rotation writing several files triggers reloads with
+					// a half written set, the last event loads the full set
+					r.log.Debugf("unable to reload certificates after %s: %s", e, err)
+					continue
+				}
+				r.log.Info("reloaded certificates after ", e)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				r.log.Errorf("error watching certificates: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops watching the files.
+func (r *Reloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+	return r.watcher.Close()
+}
+
+// Certificate returns the current key pair.
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.cert
+}
+
+// CAPool returns the current CA pool, nil when no CA file is configured.
+func (r *Reloader) CAPool() *x509.CertPool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.pool
+}
+
+// ServerConfig returns a TLS config for a server presenting the current key
+// pair. With requireClientCert clients must present a certificate signed by
+// the current CA.
+func ServerConfig(r *Reloader, requireClientCert bool) *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.Certificate(), nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// unused as GetConfigForClient takes over, it is set so that
+		// http.Server.ServeTLS accepts the config without certificate files
+		GetCertificate: getCertificate,
+		// a config per handshake picks up reloaded client CAs
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+			}
+			if requireClientCert {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = r.CAPool()
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a TLS config for a client verifying the server against
+// the current CA pool, or the system pool when no CA file is configured. The
+// current key pair, if any, is presented to servers asking for a client
+// certificate.
+func ClientConfig(r *Reloader, serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if c := r.Certificate(); c != nil {
+				return c, nil
+			}
+			// no certificate, the server decides whether that's acceptable
+			return &tls.Certificate{}, nil
+		},
+		// the built in verification uses a fixed RootCAs, it is done in
+		// VerifyConnection instead so that the CA pool can be reloaded
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyServer(cs, r.CAPool())
+		},
+	}
+}
+
+// verifyServer does the verification crypto/tls does for clients, using the given roots.
+func verifyServer(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server didn't present a certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,133 @@
+package certs
+
+import (
+	"crypto/tls"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/certs/certstest"
+	"github.com/sirupsen/logrus"
+)
+
+// serve accepts TLS connections and completes the handshake of each.
+func serve(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if err := c.(*tls.Conn).Handshake(); err == nil {
+					io.WriteString(c, "ok")
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dial returns the common name of the server certificate, reading from the
+// connection makes sure the server accepted the client certificate.
+func dial(addr string, cfg *tls.Config) (string, error) {
+	c, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return "", err
+	}
+	return c.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	log := logrus.New()
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test-ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	ca.WriteCA(t, caFile)
+
+	serverCert, serverKey := ca.Issue(t, dir, "server", "localhost", "127.0.0.1")
+	clientCert, clientKey := ca.Issue(t, dir, "client")
+
+	sr, err := NewReloader(log, serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sr.Close()
+	addr := serve(t, ServerConfig(sr, true))
+
+	cr, err := NewReloader(log, clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+
+	if _, err := dial(addr, ClientConfig(cr, "localhost")); err != nil {
+		t.Fatalf("expected mTLS handshake to succeed got %s", err)
+	}
+
+	// without a client certificate the server rejects the connection
+	noCert, err := NewReloader(log, "", "", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noCert.Close()
+	if _, err := dial(addr, ClientConfig(noCert, "localhost")); err == nil {
+		t.Fatal("expected handshake without client certificate to fail")
+	}
+
+	// a server certificate from another CA is rejected by the client
+	other := certstest.NewCA(t, "other-ca")
+	otherDir := t.TempDir()
+	otherCert, otherKey := other.Issue(t, otherDir, "server", "localhost", "127.0.0.1")
+	or, err := NewReloader(log, otherCert, otherKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	otherAddr := serve(t, ServerConfig(or, false))
+	if _, err := dial(otherAddr, ClientConfig(cr, "localhost")); err == nil {
+		t.Fatal("expected server certificate from an unknown CA to be rejected")
+	}
+}
+
+func TestReloadOnChange(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test-ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	ca.WriteCA(t, caFile)
+	certFile, keyFile := ca.Issue(t, dir, "server", "localhost")
+
+	r, err := NewReloader(logrus.New(), certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	before := r.Certificate()
+
+	// rotate the certificate in place
+	ca.Issue(t, dir, "server", "localhost")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Certificate() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded after the files changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	config "github.com/samims/ecommerceGO/currency/configs"
+	"github.com/samims/ecommerceGO/currency/constants"
 	"github.com/samims/ecommerceGO/currency/data"
 	"github.com/samims/ecommerceGO/currency/logger"
+	"github.com/samims/ecommerceGO/currency/metrics"
 	"github.com/samims/ecommerceGO/currency/server"
 	"github.com/sirupsen/logrus"
 )
@@ -20,7 +23,7 @@ func main() {
 	cfg := config.NewViperConfig()
 	logLevel, err := logrus.ParseLevel(cfg.GetString("log_level"))
 	if err != nil {
-		fmt.Errorf("error parsing log level: %s", err)
+		fmt.Printf("error parsing log level: %s\n", err)
 		os.Exit(1)
 	}
 	log := logger.NewLogger(logLevel)
@@ -43,14 +46,150 @@ func main() {
 }
 
 func initializeServer(cfg config.Env, log *logrus.Logger) (*server.Server, error) {
+	// Initialize metrics, the rates count provider errors from the first fetch
+	m := metrics.NewRegistry()
+
 	// Initialize rates
-	rates, err := data.NewRates(log, cfg)
+	rates, err := data.NewRates(log, cfg, m)
 	if err != nil {
 		return nil, fmt.Errorf("unable to generate rates: %s", err)
 	}
 
+	// Initialize the simulator moving the rates between fetches
+	simulator, err := initializeSimulator(cfg, log)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize rate simulator: %s", err)
+	}
+	rates.UseSimulator(simulator)
+
+	// Initialize rate overrides, they take precedence over the market rates
+	overrides, err := data.NewOverrides(log, cfg.GetString(constants.EnvOverridesFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load rate overrides: %s", err)
+	}
+	rates.UseOverrides(overrides)
+
+	// Initialize custom currencies, like loyalty points, on top of the market rates
+	custom, err := data.LoadCustomCurrencies(log, cfg.GetString(constants.EnvCustomCurrencies))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load custom currencies: %s", err)
+	}
+	rates.UseCustomCurrencies(custom)
+
+	// Initialize pricing policy, it is reloaded whenever the policy file changes
+	pricing, err := data.NewPricing(log, cfg.GetString(constants.EnvPricingPolicy))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load pricing policy: %s", err)
+	}
+
+	// Initialize quotes
+	quotes, err := initializeQuotes(cfg, log, rates, pricing)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize quotes: %s", err)
+	}
+
+	// Initialize candles, they aggregate the rate updates for dashboards
+	capacity := cfg.GetInt(constants.EnvCandleCapacity)
+	if capacity == 0 {
+		capacity = constants.DefaultCandleCapacity
+	}
+	candles, err := data.NewCandles(log, rates, splitList(cfg.GetStringSlice(constants.EnvCandlePairs)), capacity, cfg.GetString(constants.EnvCandleFile))
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize candles: %s", err)
+	}
+
 	// Initialize server
-	return server.NewServer(cfg, log, rates)
+	return server.NewServer(cfg, log, rates, quotes, pricing, overrides, candles, m)
+}
+
+// initializeQuotes creates the quote service, quotes are kept in memory unless
+// a quote store file is configured.
+func initializeQuotes(cfg config.Env, log *logrus.Logger, rates *data.ExchangeRates, pricing *data.Pricing) (*data.Quotes, error) {
+	ttl := cfg.GetDuration(constants.EnvQuoteTTL)
+	if ttl == 0 {
+		ttl = constants.DefaultQuoteTTL
+	}
+	maxTTL := cfg.GetDuration(constants.EnvQuoteMaxTTL)
+	if maxTTL == 0 {
+		maxTTL = constants.DefaultQuoteMaxTTL
+	}
+
+	maxQuotes := cfg.GetInt(constants.EnvQuoteMaxCount)
+	if maxQuotes == 0 {
+		maxQuotes = constants.DefaultQuoteMaxCount
+	}
+
+	var store data.QuoteStore = data.NewMemoryQuoteStore(maxQuotes)
+	if path := cfg.GetString(constants.EnvQuoteStoreFile); path != "" {
+		fs, err := data.NewFileQuoteStore(path, maxQuotes)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Storing quotes in ", path)
+		store = fs
+	}
+
+	return data.NewQuotes(log, rates, pricing, store, ttl, maxTTL), nil
+}
+
+// initializeSimulator creates the rate simulator with the configured model.
+// Without a seed a random one is used, it is logged so that the run can be
+// reproduced.
+func initializeSimulator(cfg config.Env, log *logrus.Logger) (*data.Simulator, error) {
+	interval := cfg.GetDuration(constants.EnvSimInterval)
+	if interval == 0 {
+		interval = constants.DefaultSimInterval
+	}
+	seed := int64(cfg.GetInt(constants.EnvSimSeed))
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	name := cfg.GetString(constants.EnvSimModel)
+	if name == "" {
+		name = data.ModelRandomWalk
+	}
+
+	var model data.Model
+	switch name {
+	case data.ModelRandomWalk:
+		maxStep := cfg.GetFloat64(constants.EnvSimMaxStep)
+		if maxStep == 0 {
+			maxStep = constants.DefaultSimMaxStep
+		}
+		model = data.NewRandomWalk(seed, maxStep, cfg.GetFloat64(constants.EnvSimBound))
+	case data.ModelGBM:
+		volatility := cfg.GetFloat64(constants.EnvSimVolatility)
+		if volatility == 0 {
+			volatility = constants.DefaultSimVolatility
+		}
+		model = data.NewGBM(seed, cfg.GetFloat64(constants.EnvSimDrift), volatility)
+	case data.ModelReplay:
+		rows, err := data.ReadReplayFile(cfg.GetString(constants.EnvSimReplayFile))
+		if err != nil {
+			return nil, err
+		}
+		model = data.NewReplay(rows)
+	default:
+		return nil, fmt.Errorf("unknown simulation model %q", name)
+	}
+
+	log.Infof("simulating rates with model %s, seed %d, every %s", name, seed, interval)
+	return data.NewSimulator(model, interval), nil
+}
+
+// splitList splits comma separated values, viper only splits slices set in
+// the config file.
+func splitList(values []string) []string {
+	list := []string{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
 }
 
 func startServer(s *server.Server, log *logrus.Logger) {
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ctl runs the commands against the service
+type ctl struct {
+	opts     *options
+	currency protos.CurrencyClient
+	health   healthpb.HealthClient
+	out      *printer
+}
+
+// withDeadline applies -timeout to a call.
+func (c *ctl) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.opts.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.timeout)
+}
+
+// get prints the rate of a pair.
+func (c *ctl) get(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: get needs BASE and DEST", errUsage)
+	}
+	rr, err := c.rateRequest(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+	resp, err := c.currency.GetRate(ctx, rr)
+	if err != nil {
+		return fmt.Errorf("unable to get rate: %s", err)
+	}
+	return c.out.rates(resp)
+}
+
+// list prints the rates of every currency of the API against the base.
+// Currencies the service has no rate for are skipped, any other error fails
+// the listing.
+func (c *ctl) list(ctx context.Context) error {
+	base, err := currency(c.opts.base)
+	if err != nil {
+		return err
+	}
+	side, err := c.sideValue()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
+	values := make([]int, 0, len(protos.Currencies_name))
+	for v := range protos.Currencies_name {
+		values = append(values, int(v))
+	}
+	sort.Ints(values)
+
+	resps := []*protos.RateResponse{}
+	for _, v := range values {
+		dest := protos.Currencies(v)
+		if dest == base {
+			continue
+		}
+		resp, err := c.currency.GetRate(ctx, &protos.RateRequest{Base: base, Destination: dest, Side: side})
+		if c := status.Code(err); c == codes.NotFound || c == codes.Unimplemented {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to list rates: %s", err)
+		}
+		resps = append(resps, resp)
+	}
+	return c.out.rates(resps...)
+}
+
+// subscribe prints every update of the pairs until interrupted.
+func (c *ctl) subscribe(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: subscribe needs at least one PAIR", errUsage)
+	}
+	reqs := make([]*protos.RateRequest, 0, len(args))
+	for _, p := range args {
+		base, dest, ok := strings.Cut(p, "/")
+		if !ok {
+			return fmt.Errorf("%w: invalid pair %q, expected BASE/DEST", errUsage, p)
+		}
+		rr, err := c.rateRequest(base, dest)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, rr)
+	}
+
+	if c.opts.timeoutSet {
+		var cancel func()
+		ctx, cancel = c.withDeadline(ctx)
+		defer cancel()
+	}
+
+	stream, err := c.currency.SubscribeRates(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to subscribe: %s", err)
+	}
+	for _, rr := range reqs {
+		if err := stream.Send(rr); err != nil {
+			return fmt.Errorf("unable to subscribe to %s/%s: %s", rr.Base, rr.Destination, err)
+		}
+	}
+
+	c.out.streamHeader()
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stream closed: %s", err)
+		}
+		if e := msg.GetError(); e != nil {
+			c.out.errorf("subscription failed: %s", e.GetMessage())
+			continue
+		}
+		if err := c.out.streamRate(msg.GetRateResponse()); err != nil {
+			return err
+		}
+	}
+}
+
+// convert prints the amount converted from base to dest.
+func (c *ctl) convert(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("%w: convert needs AMOUNT, BASE and DEST", errUsage)
+	}
+	amount, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid amount %q", errUsage, args[0])
+	}
+	rr, err := c.rateRequest(args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+	resp, err := c.currency.GetRate(ctx, rr)
+	if err != nil {
+		return fmt.Errorf("unable to get rate: %s", err)
+	}
+	return c.out.conversion(amount, resp)
+}
+
+// checkHealth prints the health of the server, the Currency service and the
+// liveness of the rates. It fails when the server isn't serving.
+func (c *ctl) checkHealth(ctx context.Context) error {
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
+	services := []string{"", protos.Currency_ServiceDesc.ServiceName, constants.LiveRatesService}
+	statuses := make([]serviceStatus, 0, len(services))
+	for _, s := range services {
+		st := serviceStatus{Service: s, Status: healthpb.HealthCheckResponse_UNKNOWN.String()}
+		resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{Service: s})
+		if err != nil {
+			st.Error = err.Error()
+		} else {
+			st.Status = resp.GetStatus().String()
+		}
+		statuses = append(statuses, st)
+	}
+
+	if err := c.out.health(statuses); err != nil {
+		return err
+	}
+	if statuses[0].Status != healthpb.HealthCheckResponse_SERVING.String() {
+		return fmt.Errorf("server is %s", statuses[0].Status)
+	}
+	return nil
+}
+
+func (c *ctl) rateRequest(base, dest string) (*protos.RateRequest, error) {
+	b, err := currency(base)
+	if err != nil {
+		return nil, err
+	}
+	d, err := currency(dest)
+	if err != nil {
+		return nil, err
+	}
+	side, err := c.sideValue()
+	if err != nil {
+		return nil, err
+	}
+	return &protos.RateRequest{Base: b, Destination: d, Side: side}, nil
+}
+
+func (c *ctl) sideValue() (protos.Side, error) {
+	v, ok := protos.Side_value[strings.ToUpper(c.opts.side)]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown side %q", errUsage, c.opts.side)
+	}
+	return protos.Side(v), nil
+}
+
+func currency(code string) (protos.Currencies, error) {
+	v, ok := protos.Currencies_value[strings.ToUpper(code)]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown currency %q", errUsage, code)
+	}
+	return protos.Currencies(v), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCurrency serves rates against EUR, currencies without a rate are
+// NotFound and fail fails every call
+type fakeCurrency struct {
+	protos.CurrencyClient
+	rates  map[protos.Currencies]float64
+	fail   error
+	stream *fakeStream
+}
+
+func (f *fakeCurrency) GetRate(_ context.Context, rr *protos.RateRequest, _ ...grpc.CallOption) (*protos.RateResponse, error) {
+	if f.fail != nil {
+		return nil, f.fail
+	}
+	r, ok := f.rates[rr.GetDestination()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "rate not found for currency %s", rr.GetDestination())
+	}
+	return &protos.RateResponse{Base: rr.GetBase(), Destination: rr.GetDestination(), Side: rr.GetSide(), Rate: r, MidRate: r}, nil
+}
+
+func (f *fakeCurrency) SubscribeRates(_ context.Context, _ ...grpc.CallOption) (protos.Currency_SubscribeRatesClient, error) {
+	return f.stream, nil
+}
+
+// fakeStream sends the messages in order and then ends
+type fakeStream struct {
+	grpc.ClientStream
+	sent     []*protos.RateRequest
+	messages []*protos.StreamingRateResponse
+}
+
+func (f *fakeStream) Send(rr *protos.RateRequest) error {
+	f.sent = append(f.sent, rr)
+	return nil
+}
+
+func (f *fakeStream) Recv() (*protos.StreamingRateResponse, error) {
+	if len(f.messages) == 0 {
+		return nil, io.EOF
+	}
+	m := f.messages[0]
+	f.messages = f.messages[1:]
+	return m, nil
+}
+
+// fakeHealth reports the statuses by service
+type fakeHealth struct {
+	healthpb.HealthClient
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+}
+
+func (f *fakeHealth) Check(_ context.Context, req *healthpb.HealthCheckRequest, _ ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	st, ok := f.statuses[req.GetService()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+func newTestCtl(format string, currency *fakeCurrency, health *fakeHealth) (*ctl, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &ctl{
+		opts:     &options{side: "MID", base: "EUR", output: format},
+		currency: currency,
+		health:   health,
+		out:      newPrinter(out, format),
+	}, out
+}
+
+func TestGet(t *testing.T) {
+	c, out := newTestCtl("table", &fakeCurrency{rates: map[protos.Currencies]float64{protos.Currencies_USD: 1.1}}, nil)
+
+	if err := c.get(context.Background(), []string{"eur", "usd"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "EUR/USD") || !strings.Contains(out.String(), "1.100000") {
+		t.Errorf("expected the EUR/USD rate got\n%s", out)
+	}
+
+	for _, args := range [][]string{{"EUR"}, {"EUR", "XXX"}, {"EUR", "USD", "GBP"}} {
+		if err := c.get(context.Background(), args); !errors.Is(err, errUsage) {
+			t.Errorf("expected a usage error for %v got %v", args, err)
+		}
+	}
+	c.opts.side = "UP"
+	if err := c.get(context.Background(), []string{"EUR", "USD"}); !errors.Is(err, errUsage) {
+		t.Errorf("expected a usage error for an unknown side got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	currency := &fakeCurrency{rates: map[protos.Currencies]float64{protos.Currencies_USD: 1.1, protos.Currencies_GBP: 0.9}}
+	c, out := newTestCtl("json", currency, nil)
+
+	// currencies without a rate are skipped
+	if err := c.list(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"destination":"USD"`) || !strings.Contains(lines[1], `"destination":"GBP"`) {
+		t.Errorf("expected the USD and GBP rates in enum order got\n%s", out)
+	}
+
+	for _, code := range []codes.Code{codes.Unavailable, codes.Unauthenticated, codes.DeadlineExceeded} {
+		currency.fail = status.Error(code, "failed")
+		if err := c.list(context.Background()); err == nil {
+			t.Errorf("expected %s to fail the listing", code)
+		}
+	}
+
+	currency.fail = status.Error(codes.Unimplemented, "not implemented")
+	out.Reset()
+	if err := c.list(context.Background()); err != nil {
+		t.Errorf("expected Unimplemented to be skipped got %v", err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	c, out := newTestCtl("table", &fakeCurrency{rates: map[protos.Currencies]float64{protos.Currencies_USD: 1.1}}, nil)
+
+	if err := c.convert(context.Background(), []string{"100", "EUR", "USD"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "100.00 EUR = 110.00 USD") {
+		t.Errorf("unexpected conversion %q", out)
+	}
+	if err := c.convert(context.Background(), []string{"ten", "EUR", "USD"}); !errors.Is(err, errUsage) {
+		t.Errorf("expected a usage error for an invalid amount got %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	stream := &fakeStream{messages: []*protos.StreamingRateResponse{
+		{Message: &protos.StreamingRateResponse_RateResponse{RateResponse: &protos.RateResponse{Destination: protos.Currencies_USD, Rate: 1.1}}},
+		{Message: &protos.StreamingRateResponse_Error{Error: status.New(codes.AlreadyExists, "already subscribed").Proto()}},
+		{Message: &protos.StreamingRateResponse_RateResponse{RateResponse: &protos.RateResponse{Destination: protos.Currencies_GBP, Rate: 0.9}}},
+	}}
+	c, out := newTestCtl("json", &fakeCurrency{stream: stream}, nil)
+
+	if err := c.subscribe(context.Background(), []string{"EUR/USD", "eur/gbp"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.sent) != 2 || stream.sent[1].GetDestination() != protos.Currencies_GBP {
+		t.Errorf("expected both pairs to be subscribed got %v", stream.sent)
+	}
+	// error messages don't end the stream
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 {
+		t.Errorf("expected two rates got\n%s", out)
+	}
+
+	for _, args := range [][]string{nil, {"EURUSD"}} {
+		if err := c.subscribe(context.Background(), args); !errors.Is(err, errUsage) {
+			t.Errorf("expected a usage error for %v got %v", args, err)
+		}
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	health := &fakeHealth{statuses: map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":                                      healthpb.HealthCheckResponse_SERVING,
+		protos.Currency_ServiceDesc.ServiceName: healthpb.HealthCheckResponse_SERVING,
+		constants.LiveRatesService:              healthpb.HealthCheckResponse_NOT_SERVING,
+	}}
+	c, out := newTestCtl("table", nil, health)
+
+	// stale rates are reported, the server still serves
+	if err := c.checkHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), constants.LiveRatesService) || !strings.Contains(out.String(), "NOT_SERVING") {
+		t.Errorf("expected the live rates status got\n%s", out)
+	}
+
+	health.statuses[""] = healthpb.HealthCheckResponse_NOT_SERVING
+	if err := c.checkHealth(context.Background()); err == nil {
+		t.Error("expected a server which isn't serving to fail the check")
+	}
+}
@@ -0,0 +1,177 @@
+// Command currencyctl talks to the currency service from the terminal.
+//
+// Usage:
+//
+//	currencyctl [flags] get BASE DEST
+//	currencyctl [flags] list
+//	currencyctl [flags] subscribe PAIR...
+//	currencyctl [flags] convert AMOUNT BASE DEST
+//	currencyctl [flags] health
+//
+// Pairs are written as BASE/DEST, e.g. EUR/USD. Run currencyctl -h for the flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/certs"
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// errUsage is returned for invalid arguments, the usage is printed for it
+var errUsage = errors.New("invalid arguments")
+
+// options are the global flags
+type options struct {
+	addr       string
+	timeout    time.Duration
+	output     string
+	side       string
+	base       string
+	token      string
+	tls        bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	// timeoutSet is whether -timeout was given, streams only have a deadline then
+	timeoutSet bool
+}
+
+func main() {
+	opts := &options{}
+	fs := flag.NewFlagSet("currencyctl", flag.ExitOnError)
+	fs.StringVar(&opts.addr, "addr", envOr("CURRENCY_SERVER_BASE", "localhost:9092"), "address of the currency service")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "deadline of every call, streams run until interrupted unless set")
+	fs.StringVar(&opts.output, "o", "table", "output format, table or json")
+	fs.StringVar(&opts.side, "side", "MID", "side of the rates, MID, BUY or SELL")
+	fs.StringVar(&opts.base, "base", "EUR", "base currency of list")
+	fs.StringVar(&opts.token, "token", os.Getenv("CURRENCY_TOKEN"), "bearer token sent with every call")
+	fs.BoolVar(&opts.tls, "tls", false, "connect with TLS, implied by -ca")
+	fs.StringVar(&opts.caFile, "ca", "", "CA file to verify the server with, the system roots are used when empty")
+	fs.StringVar(&opts.certFile, "cert", "", "client certificate file for mutual TLS")
+	fs.StringVar(&opts.keyFile, "key", "", "client key file for mutual TLS")
+	fs.StringVar(&opts.serverName, "server-name", "", "name to verify the server certificate against, the host of -addr by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: currencyctl [flags] COMMAND [ARGS]
+
+Commands:
+  get BASE DEST               get the rate of a pair
+  list                        list the rates of every currency against -base
+  subscribe PAIR...           stream rate updates of pairs, e.g. EUR/USD
+  convert AMOUNT BASE DEST    convert an amount
+  health                      check the health of the service
+
+Flags:`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "timeout" {
+			opts.timeoutSet = true
+		}
+	})
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if opts.output != "table" && opts.output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", opts.output)
+		os.Exit(2)
+	}
+
+	conn, closeConn, err := dial(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer closeConn()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+opts.token)
+	}
+
+	c := &ctl{
+		opts:     opts,
+		currency: protos.NewCurrencyClient(conn),
+		health:   healthpb.NewHealthClient(conn),
+		out:      newPrinter(os.Stdout, opts.output),
+	}
+
+	args := fs.Args()[1:]
+	switch cmd := fs.Arg(0); cmd {
+	case "get":
+		err = c.get(ctx, args)
+	case "list":
+		err = c.list(ctx)
+	case "subscribe":
+		err = c.subscribe(ctx, args)
+	case "convert":
+		err = c.convert(ctx, args)
+	case "health":
+		err = c.checkHealth(ctx)
+	default:
+		err = fmt.Errorf("%w: unknown command %q", errUsage, cmd)
+	}
+
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// dial connects to the service, with TLS when any TLS flag is set.
+func dial(opts *options) (*grpc.ClientConn, func(), error) {
+	creds := insecure.NewCredentials()
+	closeCerts := func() {}
+
+	if opts.tls || opts.caFile != "" || opts.certFile != "" {
+		// the reloader logs rotations, which don't matter for a single run
+		l := logrus.New()
+		l.SetOutput(io.Discard)
+		cr, err := certs.NewReloader(l, opts.certFile, opts.keyFile, opts.caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeCerts = func() { cr.Close() }
+		creds = credentials.NewTLS(certs.ClientConfig(cr, opts.serverName))
+	}
+
+	conn, err := grpc.Dial(opts.addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		closeCerts()
+		return nil, nil, fmt.Errorf("unable to connect to %s: %s", opts.addr, err)
+	}
+	return conn, func() {
+		conn.Close()
+		closeCerts()
+	}, nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var marshaler = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// serviceStatus is the health of one service
+type serviceStatus struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// printer writes results as a table or as JSON, one document per line
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	return &printer{w: w, format: format}
+}
+
+func (p *printer) rates(resps ...*protos.RateResponse) error {
+	if p.format == "json" {
+		for _, r := range resps {
+			if err := p.proto(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PAIR\tSIDE\tRATE\tMID\tSOURCE\tSEQ\tAS OF")
+	for _, r := range resps {
+		fmt.Fprintln(tw, rateRow(r))
+	}
+	return tw.Flush()
+}
+
+// streamHeader writes the table header of a stream, rows follow as they arrive.
+func (p *printer) streamHeader() {
+	if p.format == "table" {
+		fmt.Fprintf(p.w, "%-9s %-5s %14s %14s %-10s %6s %s\n", "PAIR", "SIDE", "RATE", "MID", "SOURCE", "SEQ", "AS OF")
+	}
+}
+
+func (p *printer) streamRate(r *protos.RateResponse) error {
+	if p.format == "json" {
+		return p.proto(r)
+	}
+	_, err := fmt.Fprintf(p.w, "%-9s %-5s %14.6f %14.6f %-10s %6d %s\n",
+		r.GetBase().String()+"/"+r.GetDestination().String(), r.GetSide(),
+		r.GetRate(), r.GetMidRate(), r.GetSource(), r.GetSequence(), asOf(r),
+	)
+	return err
+}
+
+func (p *printer) conversion(amount float64, r *protos.RateResponse) error {
+	converted := amount * r.GetRate()
+	if p.format == "json" {
+		return p.json(map[string]interface{}{
+			"amount":      amount,
+			"base":        r.GetBase().String(),
+			"destination": r.GetDestination().String(),
+			"side":        r.GetSide().String(),
+			"rate":        r.GetRate(),
+			"converted":   converted,
+		})
+	}
+	_, err := fmt.Fprintf(p.w, "%.2f %s = %.2f %s (rate %.6f, %s)\n",
+		amount, r.GetBase(), converted, r.GetDestination(), r.GetRate(), r.GetSide())
+	return err
+}
+
+func (p *printer) health(statuses []serviceStatus) error {
+	if p.format == "json" {
+		return p.json(statuses)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tSTATUS\tERROR")
+	for _, s := range statuses {
+		name := s.Service
+		if name == "" {
+			name = "(server)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, s.Status, s.Error)
+	}
+	return tw.Flush()
+}
+
+// errorf reports a non fatal error on stderr.
+func (p *printer) errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (p *printer) proto(r *protos.RateResponse) error {
+	b, err := marshaler.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w, string(b))
+	return err
+}
+
+func (p *printer) json(v interface{}) error {
+	return json.NewEncoder(p.w).Encode(v)
+}
+
+func rateRow(r *protos.RateResponse) string {
+	return fmt.Sprintf("%s/%s\t%s\t%.6f\t%.6f\t%s\t%d\t%s",
+		r.GetBase(), r.GetDestination(), r.GetSide(), r.GetRate(), r.GetMidRate(),
+		r.GetSource(), r.GetSequence(), asOf(r))
+}
+
+func asOf(r *protos.RateResponse) string {
+	if r.GetTimestamp() == nil {
+		return "-"
+	}
+	return r.GetTimestamp().AsTime().Local().Format(time.RFC3339)
+}
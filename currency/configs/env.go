@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -10,6 +12,8 @@ type Env interface {
 	GetInt(key string) int
 	GetBool(key string) bool
 	GetFloat64(key string) float64
+	GetDuration(key string) time.Duration
+	GetStringSlice(key string) []string
 }
 
 type ViperConfig struct {
@@ -55,3 +59,11 @@ func (c *ViperConfig) GetBool(key string) bool {
 func (c *ViperConfig) GetFloat64(key string) float64 {
 	return c.cfg.GetFloat64(key)
 }
+
+func (c *ViperConfig) GetDuration(key string) time.Duration {
+	return c.cfg.GetDuration(key)
+}
+
+func (c *ViperConfig) GetStringSlice(key string) []string {
+	return c.cfg.GetStringSlice(key)
+}
@@ -1,6 +1,105 @@
 package constants
 
+import "time"
+
 const (
-	EnvRateUri = "RATE_URI"
-	EnvPort    = "PORT"
+	EnvRateUri        = "RATE_URI"
+	EnvPort           = "PORT"
+	EnvQuoteStoreFile = "QUOTE_STORE_FILE"
+	EnvQuoteTTL       = "QUOTE_TTL"
+	EnvQuoteMaxTTL    = "QUOTE_MAX_TTL"
+	EnvPricingPolicy  = "PRICING_POLICY_FILE"
+	EnvOverridesFile  = "OVERRIDES_FILE"
+	EnvAdminToken     = "ADMIN_TOKEN"
+	EnvRateMaxAge     = "RATE_MAX_AGE"
+	// EnvQuoteMaxCount is the most quotes stored at once, new quotes are
+	// refused while that many haven't expired
+	EnvQuoteMaxCount = "QUOTE_MAX_COUNT"
+	// EnvCustomCurrencies is a YAML or JSON file of currencies the rate
+	// providers don't publish, served by the v2 API only, see data.CustomCurrency
+	EnvCustomCurrencies = "CUSTOM_CURRENCIES_FILE"
+	// EnvRateSnapshotFile is where the last live rates are saved, they are used
+	// when the live source is unreachable at startup
+	EnvRateSnapshotFile = "RATE_SNAPSHOT_FILE"
+	// EnvRateRetryInterval is how often the live source is retried while serving a snapshot
+	EnvRateRetryInterval = "RATE_RETRY_INTERVAL"
+	// EnvRateProviders is a comma separated list of name=uri rate providers,
+	// file:// URIs are read from disk, only RATE_URI is used when empty
+	EnvRateProviders = "RATE_PROVIDERS"
+	// EnvRateMaxDeviation is the percentage a provider may deviate from the
+	// median of all providers before its rate is rejected
+	EnvRateMaxDeviation = "RATE_MAX_DEVIATION"
+	// EnvRatePollInterval is how often the providers are polled, the rates are
+	// only fetched at startup when zero
+	EnvRatePollInterval = "RATE_POLL_INTERVAL"
+
+	// simulation of rate fluctuations between fetches, see data.Simulator
+	EnvSimModel    = "RATE_SIM_MODEL"
+	EnvSimSeed     = "RATE_SIM_SEED"
+	EnvSimInterval = "RATE_SIM_INTERVAL"
+	// EnvSimMaxStep and EnvSimBound are the percentages of the random walk model
+	EnvSimMaxStep = "RATE_SIM_MAX_STEP"
+	EnvSimBound   = "RATE_SIM_BOUND"
+	// EnvSimDrift and EnvSimVolatility are the per tick parameters of the gbm model
+	EnvSimDrift      = "RATE_SIM_DRIFT"
+	EnvSimVolatility = "RATE_SIM_VOLATILITY"
+	// EnvSimReplayFile is the CSV file played back by the replay model
+	EnvSimReplayFile = "RATE_SIM_REPLAY_FILE"
+
+	// EnvCandlePairs is a comma separated list of BASE/DEST pairs candles are
+	// recorded for, every currency against EUR when empty
+	EnvCandlePairs = "CANDLE_PAIRS"
+	// EnvCandleCapacity is the number of candles kept per pair and interval
+	EnvCandleCapacity = "CANDLE_CAPACITY"
+	// EnvCandleFile is where the candles are saved, they are kept in memory only when empty
+	EnvCandleFile          = "CANDLE_FILE"
+	EnvCandleFlushInterval = "CANDLE_FLUSH_INTERVAL"
+
+	// toggles of the gRPC server interceptors
+	EnvGrpcLogging  = "GRPC_LOGGING"
+	EnvGrpcRecovery = "GRPC_RECOVERY"
+	EnvGrpcMetrics  = "GRPC_METRICS"
+	EnvGrpcAuth     = "GRPC_AUTH"
+	// EnvAuthTokens is a comma separated list of name:token pairs accepted by the auth interceptor
+	EnvAuthTokens = "AUTH_TOKENS"
+	// EnvMetricsAddr is the address the metrics are served on as JSON, metrics aren't served when empty
+	EnvMetricsAddr = "METRICS_ADDR"
+	// EnvGatewayPort is the port of the REST/JSON gateway, the gateway isn't served when empty
+	EnvGatewayPort = "GATEWAY_PORT"
+
+	// TLS is enabled when a certificate is configured, clients must present a
+	// certificate signed by the CA in EnvTLSCAFile when EnvTLSClientAuth is set
+	EnvTLSCertFile   = "TLS_CERT_FILE"
+	EnvTLSKeyFile    = "TLS_KEY_FILE"
+	EnvTLSCAFile     = "TLS_CA_FILE"
+	EnvTLSClientAuth = "TLS_CLIENT_AUTH"
 )
+
+const (
+	DefaultQuoteTTL    = 15 * time.Minute
+	DefaultQuoteMaxTTL = 24 * time.Hour
+	// DefaultQuoteMaxCount is the most quotes stored at once
+	DefaultQuoteMaxCount = 100000
+	// DefaultRateMaxAge is how old the last fetch of polled rates may get
+	// before the server reports NOT_SERVING, at least two poll intervals
+	DefaultRateMaxAge = time.Minute
+	// DefaultRateRetryInterval is how often the live source is retried while serving a snapshot
+	DefaultRateRetryInterval = 30 * time.Second
+	// DefaultRateMaxDeviation is the percentage a provider may deviate from the median
+	DefaultRateMaxDeviation = 2.0
+	// DefaultSimInterval is the time between ticks of the rate simulator
+	DefaultSimInterval = 5 * time.Second
+	// DefaultSimMaxStep is the largest change in percent of a rate per tick of the random walk
+	DefaultSimMaxStep = 10.0
+	// DefaultSimVolatility is the per tick volatility of the gbm model
+	DefaultSimVolatility = 0.01
+	// DefaultCandleCapacity keeps a day of minute candles
+	DefaultCandleCapacity = 1440
+	// DefaultCandleFlushInterval is how often the candles are written to the candle file
+	DefaultCandleFlushInterval = time.Minute
+)
+
+// LiveRatesService is the grpc.health.v1 service name the currency service
+// reports SERVING on while the rates come from the live source, and
+// NOT_SERVING on while a snapshot is served.
+const LiveRatesService = "Currency.LiveRates"
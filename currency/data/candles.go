@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrCandlesNotFound = fmt.Errorf("no candles recorded")
+	ErrInvalidInterval = fmt.Errorf("invalid candle interval")
+)
+
+// CandleIntervals are the intervals candles are aggregated for
+var CandleIntervals = []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+// Candle is the open, high, low and close mid rate of a pair during the
+// interval beginning at Start.
+type Candle struct {
+	Start   time.Time `json:"start"`
+	Open    float64   `json:"open"`
+	High    float64   `json:"high"`
+	Low     float64   `json:"low"`
+	Close   float64   `json:"close"`
+	Samples int       `json:"samples"`
+}
+
+// add records a rate in the candle.
+func (c *Candle) add(rate float64) {
+	if rate > c.High {
+		c.High = rate
+	}
+	if rate < c.Low {
+		c.Low = rate
+	}
+	c.Close = rate
+	c.Samples++
+}
+
+// candleRing keeps the last candles up to its capacity, the oldest candle is
+// overwritten when it is full.
+type candleRing struct {
+	candles []Candle
+	start   int
+	size    int
+}
+
+func newCandleRing(capacity int) *candleRing {
+	return &candleRing{candles: make([]Candle, capacity)}
+}
+
+// last returns the newest candle, nil when the ring is empty.
+func (r *candleRing) last() *Candle {
+	if r.size == 0 {
+		return nil
+	}
+	return &r.candles[(r.start+r.size-1)%len(r.candles)]
+}
+
+func (r *candleRing) push(c Candle) {
+	if r.size < len(r.candles) {
+		r.candles[(r.start+r.size)%len(r.candles)] = c
+		r.size++
+		return
+	}
+	r.candles[r.start] = c
+	r.start = (r.start + 1) % len(r.candles)
+}
+
+// list returns the candles oldest first.
+func (r *candleRing) list() []Candle {
+	list := make([]Candle, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		list = append(list, r.candles[(r.start+i)%len(r.candles)])
+	}
+	return list
+}
+
+// Candles aggregates the rate updates of pairs into candles for every one of
+// CandleIntervals. Every pair and interval keeps its last candles in a ring,
+// which is written to a file when one is configured.
+type Candles struct {
+	log      *logrus.Logger
+	rates    *ExchangeRates
+	mutex    *sync.RWMutex
+	pairs    []string
+	capacity int
+	rings    map[string]*candleRing
+	path     string
+}
+
+// NewCandles creates Candles for the pairs, given as "BASE/DEST", keeping up
+// to capacity candles per pair and interval. Without pairs every currency is
+// tracked against EUR. The candles saved at path by a previous run are
+// loaded, with an empty path candles are kept in memory only.
+func NewCandles(l *logrus.Logger, r *ExchangeRates, pairs []string, capacity int, path string) (*Candles, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("candle capacity must be positive, got %d", capacity)
+	}
+	c := &Candles{
+		log:      l,
+		rates:    r,
+		mutex:    &sync.RWMutex{},
+		capacity: capacity,
+		rings:    map[string]*candleRing{},
+		path:     path,
+	}
+	for _, p := range pairs {
+		base, dest, ok := strings.Cut(strings.TrimSpace(p), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid candle pair %q, expected BASE/DEST", p)
+		}
+		c.pairs = append(c.pairs, pairKey(base, dest))
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open candles %s: %s", path, err)
+	}
+	defer f.Close()
+
+	saved := map[string][]Candle{}
+	if err := json.NewDecoder(f).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("unable to read candles %s: %s", path, err)
+	}
+	for k, candles := range saved {
+		ring := newCandleRing(capacity)
+		for _, v := range candles {
+			ring.push(v)
+		}
+		c.rings[k] = ring
+	}
+	l.Infof("loaded candles of %d series from %s", len(saved), path)
+	return c, nil
+}
+
+// Run records the rates on every update from MonitorRates and writes the
+// candles to the file every flushInterval, until the context is done.
+func (c *Candles) Run(ctx context.Context, flushInterval time.Duration) {
+	updates := c.rates.MonitorRates(ctx)
+	// the rates before the first update open the candles
+	c.Record()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				c.flushOrLog()
+				return
+			}
+			c.Record()
+		case <-ticker.C:
+			c.flushOrLog()
+		case <-ctx.Done():
+			c.flushOrLog()
+			return
+		}
+	}
+}
+
+// Record adds the current mid rate of every pair to its candles.
+func (c *Candles) Record() {
+	pairs := c.pairs
+	if len(pairs) == 0 {
+		for _, code := range c.rates.Currencies() {
+			if code != baseCurrency {
+				pairs = append(pairs, pairKey(baseCurrency, code))
+			}
+		}
+	}
+
+	for _, p := range pairs {
+		base, dest, _ := strings.Cut(p, "/")
+		r, err := c.rates.GetRateInfo(base, dest)
+		if err != nil {
+			c.log.Debugf("no rate for candles of %s: %s", p, err)
+			continue
+		}
+		c.add(p, r.ObservedAt, r.Rate)
+	}
+}
+
+// add records the rate of the pair observed at the given time.
+func (c *Candles) add(pair string, at time.Time, rate float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, interval := range CandleIntervals {
+		key := seriesKey(pair, interval)
+		ring, ok := c.rings[key]
+		if !ok {
+			ring = newCandleRing(c.capacity)
+			c.rings[key] = ring
+		}
+
+		start := at.UTC().Truncate(interval)
+		last := ring.last()
+		switch {
+		case last != nil && last.Start.Equal(start):
+			last.add(rate)
+		case last != nil && start.Before(last.Start):
+			// a late update for a closed candle, it is dropped
+		default:
+			ring.push(Candle{Start: start, Open: rate, High: rate, Low: rate, Close: rate, Samples: 1})
+		}
+	}
+}
+
+// Get returns the candles of the pair for the interval which overlap
+// [from, to), oldest first. A zero from or to leaves that end open.
+func (c *Candles) Get(base, dest string, interval time.Duration, from, to time.Time) ([]Candle, error) {
+	if !validInterval(interval) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidInterval, interval)
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	ring, ok := c.rings[seriesKey(pairKey(base, dest), interval)]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s/%s", ErrCandlesNotFound, base, dest)
+	}
+
+	candles := []Candle{}
+	for _, v := range ring.list() {
+		if !from.IsZero() && v.Start.Before(from.Truncate(interval)) {
+			continue
+		}
+		if !to.IsZero() && !v.Start.Before(to) {
+			continue
+		}
+		candles = append(candles, v)
+	}
+	return candles, nil
+}
+
+// flush writes all candles to the candles file.
+func (c *Candles) flush() error {
+	if c.path == "" {
+		return nil
+	}
+
+	c.mutex.RLock()
+	saved := make(map[string][]Candle, len(c.rings))
+	for k, ring := range c.rings {
+		saved[k] = ring.list()
+	}
+	c.mutex.RUnlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+func (c *Candles) flushOrLog() {
+	if err := c.flush(); err != nil {
+		c.log.Errorf("unable to save candles: %s", err)
+	}
+}
+
+func seriesKey(pair string, interval time.Duration) string {
+	return pair + "@" + interval.String()
+}
+
+func validInterval(interval time.Duration) bool {
+	for _, v := range CandleIntervals {
+		if v == interval {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCandleAggregation(t *testing.T) {
+	c, err := NewCandles(logrus.New(), newTestRates(), []string{"EUR/USD"}, 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	for i, rate := range []float64{1.10, 1.15, 1.05, 1.12} {
+		c.add("EUR/USD", start.Add(time.Duration(i)*15*time.Second), rate)
+	}
+	c.add("EUR/USD", start.Add(time.Minute), 1.2)
+
+	candles, err := c.Get("EUR", "USD", time.Minute, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 2 {
+		t.Fatalf("expected 2 minute candles got %d", len(candles))
+	}
+	want := Candle{Start: start, Open: 1.10, High: 1.15, Low: 1.05, Close: 1.12, Samples: 4}
+	if candles[0] != want {
+		t.Fatalf("expected %+v got %+v", want, candles[0])
+	}
+
+	hours, _ := c.Get("EUR", "USD", time.Hour, time.Time{}, time.Time{})
+	if len(hours) != 1 || hours[0].Close != 1.2 || hours[0].Samples != 5 {
+		t.Fatalf("unexpected hour candles %+v", hours)
+	}
+
+	// the ring keeps the last 3 candles
+	for i := 2; i < 6; i++ {
+		c.add("EUR/USD", start.Add(time.Duration(i)*time.Minute), 1)
+	}
+	candles, _ = c.Get("EUR", "USD", time.Minute, time.Time{}, time.Time{})
+	if len(candles) != 3 || !candles[0].Start.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("expected the last 3 candles from 12:03 got %+v", candles)
+	}
+
+	// ranges cover [from, to)
+	candles, _ = c.Get("EUR", "USD", time.Minute, start.Add(4*time.Minute+30*time.Second), start.Add(5*time.Minute))
+	if len(candles) != 1 || !candles[0].Start.Equal(start.Add(4*time.Minute)) {
+		t.Fatalf("expected the 12:04 candle got %+v", candles)
+	}
+
+	if _, err := c.Get("EUR", "JPY", time.Minute, time.Time{}, time.Time{}); err == nil {
+		t.Fatal("expected an error for a pair without candles")
+	}
+	if _, err := c.Get("EUR", "USD", time.Second, time.Time{}, time.Time{}); err == nil {
+		t.Fatal("expected an error for an unsupported interval")
+	}
+}
+
+func TestCandlesPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "candles.json")
+	c, err := NewCandles(logrus.New(), newTestRates(), nil, 10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without pairs every currency is tracked against EUR
+	c.Record()
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewCandles(logrus.New(), newTestRates(), nil, 10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dest := range []string{"USD", "JPY"} {
+		candles, err := reloaded.Get("EUR", dest, 24*time.Hour, time.Time{}, time.Time{})
+		if err != nil || len(candles) != 1 {
+			t.Fatalf("expected a day candle for EUR/%s after reload, got %+v %v", dest, candles, err)
+		}
+	}
+}
+
+func TestMonitorRatesBroadcasts(t *testing.T) {
+	r := newTestRates()
+	r.simulator.interval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	go r.Monitor(ctx)
+
+	// a consumer which is done doesn't stop the updates of the others
+	done, cancelDone := context.WithCancel(context.Background())
+	gone := r.MonitorRates(done)
+	cancelDone()
+	for range gone {
+		// drained until the channel is closed
+	}
+
+	first, second := r.MonitorRates(ctx), r.MonitorRates(ctx)
+	for _, ch := range []chan bool{first, second} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("expected every listener to be notified")
+		}
+	}
+
+	cancel()
+	for range first {
+		// drained until the loop closes the channel
+	}
+}
@@ -0,0 +1,105 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// ConsensusReport records how the providers agreed on the rates of a fetch.
+type ConsensusReport struct {
+	At time.Time `json:"at"`
+	// Failed maps the providers which couldn't be fetched to their error
+	Failed map[string]string `json:"failed,omitempty"`
+	// Agreed maps currencies to the providers whose rate was used
+	Agreed map[string][]string `json:"agreed"`
+	// Rejected maps currencies to the providers whose rate deviated too far
+	// from the median, and their rate
+	Rejected map[string]map[string]float64 `json:"rejected,omitempty"`
+	// NoConsensus are the currencies for which every rate was rejected
+	NoConsensus []string `json:"no_consensus,omitempty"`
+}
+
+// Disagreements returns the number of rejected provider rates.
+func (r *ConsensusReport) Disagreements() int {
+	n := 0
+	for _, v := range r.Rejected {
+		n += len(v)
+	}
+	return n
+}
+
+// Consensus combines the rates of several providers, keyed by provider name.
+// For every currency the rates deviating more than maxDeviation percent from
+// the median of all providers are rejected, and the median of the remaining
+// rates is used. A maxDeviation of 0 rejects nothing. Currencies for which
+// every rate is rejected are left out of the result.
+func Consensus(results map[string]map[string]float64, maxDeviation float64) (map[string]float64, *ConsensusReport) {
+	report := &ConsensusReport{
+		At:       time.Now(),
+		Agreed:   map[string][]string{},
+		Rejected: map[string]map[string]float64{},
+	}
+
+	// providers are visited in a fixed order so that reports are stable
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	byCurrency := map[string]map[string]float64{}
+	for _, name := range names {
+		for code, rate := range results[name] {
+			if byCurrency[code] == nil {
+				byCurrency[code] = map[string]float64{}
+			}
+			byCurrency[code][name] = rate
+		}
+	}
+
+	rates := map[string]float64{}
+	for code, quotes := range byCurrency {
+		values := make([]float64, 0, len(quotes))
+		for _, v := range quotes {
+			values = append(values, v)
+		}
+		mid := median(values)
+
+		accepted := []float64{}
+		for _, name := range names {
+			v, ok := quotes[name]
+			if !ok {
+				continue
+			}
+			if maxDeviation > 0 && math.Abs(v-mid)/mid*100 > maxDeviation {
+				if report.Rejected[code] == nil {
+					report.Rejected[code] = map[string]float64{}
+				}
+				report.Rejected[code][name] = v
+				continue
+			}
+			accepted = append(accepted, v)
+			report.Agreed[code] = append(report.Agreed[code], name)
+		}
+
+		if len(accepted) == 0 {
+			report.NoConsensus = append(report.NoConsensus, code)
+			continue
+		}
+		rates[code] = median(accepted)
+	}
+	sort.Strings(report.NoConsensus)
+
+	return rates, report
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
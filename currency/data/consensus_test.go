@@ -0,0 +1,139 @@
+package data
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/samims/ecommerceGO/currency/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// ecbServer serves the rates in the ECB XML format
+func ecbServer(t *testing.T, usd, jpy string) *httptest.Server {
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<Cube>
+		<Cube time="2023-04-03">
+			<Cube currency="USD" rate="%s"/>
+			<Cube currency="JPY" rate="%s"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`, usd, jpy)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestConsensusRejectsOutlier(t *testing.T) {
+	primary := ecbServer(t, "1.0875", "144.08")
+	mirror := ecbServer(t, "1.0881", "150.00")
+
+	local := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(local, []byte(`{"usd": 1.0870, "jpy": 144.10}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testEnv{
+		constants.EnvRateProviders: []string{
+			"primary=" + primary.URL + ",mirror=" + mirror.URL,
+			"local=file://" + local,
+		},
+	}
+	m := metrics.NewRegistry()
+	rates, err := NewRates(logrus.New(), cfg, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first fetch is counted
+	if n := m.Counter("rates.disagreements"); n != 1 {
+		t.Errorf("expected the disagreement of the first fetch to be counted, got %d", n)
+	}
+
+	// every provider agrees on USD, the median is used
+	usd, err := rates.GetRateInfo("EUR", "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usd.Rate != 1.0875 || usd.Source != SourceConsensus {
+		t.Errorf("expected USD 1.0875 from consensus, got %v from %s", usd.Rate, usd.Source)
+	}
+
+	// the mirror's JPY deviates more than the default 2% from the median
+	jpy, err := rates.GetRate("EUR", "JPY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jpy != (144.08+144.10)/2 {
+		t.Errorf("expected the median of the agreeing JPY rates, got %v", jpy)
+	}
+
+	report := rates.LastConsensus()
+	if got := report.Rejected["JPY"]; !reflect.DeepEqual(got, map[string]float64{"mirror": 150}) {
+		t.Errorf("expected the mirror's JPY to be rejected, got %v", got)
+	}
+	if got := report.Agreed["JPY"]; !reflect.DeepEqual(got, []string{"local", "primary"}) {
+		t.Errorf("expected local and primary to agree on JPY, got %v", got)
+	}
+	if got := report.Agreed["USD"]; len(got) != 3 {
+		t.Errorf("expected every provider to agree on USD, got %v", got)
+	}
+	if report.Disagreements() != 1 {
+		t.Errorf("expected one disagreement, got %d", report.Disagreements())
+	}
+}
+
+func TestConsensusSkipsFailedProviders(t *testing.T) {
+	primary := ecbServer(t, "1.0875", "144.08")
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	cfg := testEnv{
+		constants.EnvRateProviders: []string{"primary=" + primary.URL, "down=" + down.URL},
+	}
+	rates, err := NewRates(logrus.New(), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r, _ := rates.GetRate("EUR", "USD"); r != 1.0875 {
+		t.Errorf("expected the rate of the remaining provider, got %v", r)
+	}
+	if _, ok := rates.LastConsensus().Failed["down"]; !ok {
+		t.Error("expected the failed provider to be reported")
+	}
+
+	// without any provider there are no rates
+	cfg = testEnv{constants.EnvRateProviders: []string{"down=" + down.URL}}
+	if _, err := NewRates(logrus.New(), cfg, nil); err == nil {
+		t.Error("expected an error when every provider fails")
+	}
+}
+
+func TestConsensusWithoutAgreement(t *testing.T) {
+	rates, report := Consensus(map[string]map[string]float64{
+		"a": {"USD": 1.0, "JPY": 144},
+		"b": {"USD": 1.2, "JPY": 144},
+	}, 2)
+
+	if _, ok := rates["USD"]; ok {
+		t.Error("expected no USD rate when the providers disagree")
+	}
+	if rates["JPY"] != 144 {
+		t.Errorf("expected JPY 144, got %v", rates["JPY"])
+	}
+	if !reflect.DeepEqual(report.NoConsensus, []string{"USD"}) {
+		t.Errorf("expected no consensus on USD, got %v", report.NoConsensus)
+	}
+}
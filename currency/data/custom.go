@@ -0,0 +1,359 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrInvalidCustomCurrency = fmt.Errorf("invalid custom currency")
+)
+
+// CustomCurrency is a currency the rate providers don't publish, like loyalty
+// points or a test token. Its rate is either fixed against a reference
+// currency or computed by a formula over the rates of other currencies.
+//
+// Custom currencies are only served by the v2 API, which takes currency codes
+// as strings. The v1 API and the REST gateway name currencies by the
+// Currencies enum and refuse the codes outside it.
+type CustomCurrency struct {
+	Code string `mapstructure:"-"`
+	Name string `mapstructure:"name"`
+	// Reference and Rate fix the currency at Rate units per unit of Reference
+	Reference string  `mapstructure:"reference"`
+	Rate      float64 `mapstructure:"rate"`
+	// Formula is evaluated over the rates of other currencies against EUR and
+	// gives the units of the custom currency per EUR, e.g. "(USD + GBP) / 2"
+	Formula string `mapstructure:"formula"`
+
+	expr expr
+}
+
+// CustomCurrencies holds the custom currencies read from a config file, e.g.
+//
+//	currencies:
+//	  PTS:
+//	    name: loyalty points
+//	    reference: USD
+//	    rate: 100
+//	  BSK:
+//	    formula: (USD + GBP) / 2
+type CustomCurrencies struct {
+	currencies map[string]*CustomCurrency
+}
+
+// LoadCustomCurrencies reads the custom currencies from the YAML or JSON file
+// at path. With an empty path there are no custom currencies.
+func LoadCustomCurrencies(l *logrus.Logger, path string) (*CustomCurrencies, error) {
+	if path == "" {
+		return NewCustomCurrencies(nil)
+	}
+
+	cfg := viper.New()
+	cfg.SetConfigFile(path)
+	if err := cfg.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("unable to read custom currencies: %s", err)
+	}
+	file := struct {
+		Currencies map[string]*CustomCurrency `mapstructure:"currencies"`
+	}{}
+	if err := cfg.Unmarshal(&file); err != nil {
+		return nil, fmt.Errorf("unable to parse custom currencies: %s", err)
+	}
+
+	currencies := make([]*CustomCurrency, 0, len(file.Currencies))
+	for code, c := range file.Currencies {
+		if c == nil {
+			return nil, fmt.Errorf("%w %s: no rate or formula", ErrInvalidCustomCurrency, code)
+		}
+		// viper lower cases keys
+		c.Code = strings.ToUpper(code)
+		currencies = append(currencies, c)
+	}
+	cc, err := NewCustomCurrencies(currencies)
+	if err != nil {
+		return nil, err
+	}
+	l.Infof("loaded custom currencies %v from %s", cc.Codes(), path)
+	return cc, nil
+}
+
+// NewCustomCurrencies validates the currencies. Every currency needs either a
+// reference and a positive rate, or a formula, and they mustn't depend on
+// each other in a cycle.
+func NewCustomCurrencies(currencies []*CustomCurrency) (*CustomCurrencies, error) {
+	cc := &CustomCurrencies{currencies: map[string]*CustomCurrency{}}
+	for _, c := range currencies {
+		c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+		if !isCurrencyCode(c.Code) {
+			return nil, fmt.Errorf("%w %q: codes are 2 to 10 letters or digits", ErrInvalidCustomCurrency, c.Code)
+		}
+		if _, ok := cc.currencies[c.Code]; ok {
+			return nil, fmt.Errorf("%w %s: defined twice", ErrInvalidCustomCurrency, c.Code)
+		}
+
+		switch {
+		case c.Formula != "" && c.Reference != "":
+			return nil, fmt.Errorf("%w %s: either a reference or a formula", ErrInvalidCustomCurrency, c.Code)
+		case c.Formula != "":
+			e, err := parseFormula(c.Formula)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s: %s", ErrInvalidCustomCurrency, c.Code, err)
+			}
+			c.expr = e
+		case c.Reference != "":
+			if c.Rate <= 0 {
+				return nil, fmt.Errorf("%w %s: rate must be positive", ErrInvalidCustomCurrency, c.Code)
+			}
+			c.Reference = strings.ToUpper(c.Reference)
+			c.expr = binary{op: '*', left: number(c.Rate), right: ident(c.Reference)}
+		default:
+			return nil, fmt.Errorf("%w %s: no rate or formula", ErrInvalidCustomCurrency, c.Code)
+		}
+		cc.currencies[c.Code] = c
+	}
+
+	// a currency depending on itself would never resolve
+	for code := range cc.currencies {
+		if err := cc.checkCycle(code, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return cc, nil
+}
+
+func (cc *CustomCurrencies) checkCycle(code string, visiting map[string]bool) error {
+	c, ok := cc.currencies[code]
+	if !ok {
+		return nil
+	}
+	if visiting[code] {
+		return fmt.Errorf("%w %s: depends on itself", ErrInvalidCustomCurrency, code)
+	}
+	visiting[code] = true
+	for _, dep := range c.expr.idents(nil) {
+		if err := cc.checkCycle(dep, visiting); err != nil {
+			return err
+		}
+	}
+	delete(visiting, code)
+	return nil
+}
+
+// Codes returns the codes of the custom currencies, sorted.
+func (cc *CustomCurrencies) Codes() []string {
+	codes := make([]string, 0, len(cc.currencies))
+	for k := range cc.currencies {
+		codes = append(codes, k)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Has reports whether the currency is a custom currency.
+func (cc *CustomCurrencies) Has(code string) bool {
+	_, ok := cc.currencies[code]
+	return ok
+}
+
+// Rate returns the rate of the custom currency against EUR given the rates of
+// the other currencies.
+func (cc *CustomCurrencies) Rate(code string, rates map[string]float64) (float64, error) {
+	c, ok := cc.currencies[code]
+	if !ok {
+		return 0, fmt.Errorf("rate not found for currency %s", code)
+	}
+	r, err := c.expr.eval(func(dep string) (float64, error) {
+		if cc.Has(dep) {
+			return cc.Rate(dep, rates)
+		}
+		if r, ok := rates[dep]; ok {
+			return r, nil
+		}
+		return 0, fmt.Errorf("rate not found for currency %s", dep)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to compute the rate of %s: %s", code, err)
+	}
+	if r <= 0 {
+		return 0, fmt.Errorf("unable to compute the rate of %s: got %v", code, r)
+	}
+	return r, nil
+}
+
+// isCurrencyCode reports whether code is usable as a custom currency code, it
+// must be upper case.
+func isCurrencyCode(code string) bool {
+	if len(code) < 2 || len(code) > 10 || code[0] < 'A' || code[0] > 'Z' {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// expr is a parsed formula.
+type expr interface {
+	eval(lookup func(code string) (float64, error)) (float64, error)
+	// idents appends the currencies the expression refers to
+	idents(codes []string) []string
+}
+
+type number float64
+
+func (n number) eval(func(string) (float64, error)) (float64, error) { return float64(n), nil }
+func (n number) idents(codes []string) []string                      { return codes }
+
+type ident string
+
+func (i ident) eval(lookup func(string) (float64, error)) (float64, error) { return lookup(string(i)) }
+func (i ident) idents(codes []string) []string                             { return append(codes, string(i)) }
+
+type binary struct {
+	op          byte
+	left, right expr
+}
+
+func (b binary) eval(lookup func(string) (float64, error)) (float64, error) {
+	l, err := b.left.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.right.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	default:
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	}
+}
+
+func (b binary) idents(codes []string) []string {
+	return b.right.idents(b.left.idents(codes))
+}
+
+// parseFormula parses arithmetic over numbers and currency codes with + - * /
+// and parentheses.
+func parseFormula(s string) (expr, error) {
+	p := &formulaParser{src: s}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at %d in formula %q", p.src[p.pos], p.pos, s)
+	}
+	return e, nil
+}
+
+type formulaParser struct {
+	src string
+	pos int
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, 0 at the end
+func (p *formulaParser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *formulaParser) sum() (expr, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) product() (expr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) operand() (expr, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) in formula %q", p.src)
+		}
+		p.pos++
+		return e, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number in formula %q: %s", p.src, err)
+		}
+		return number(n), nil
+	case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		start := p.pos
+		for p.pos < len(p.src) && isAlnum(p.src[p.pos]) {
+			p.pos++
+		}
+		return ident(strings.ToUpper(p.src[start:p.pos])), nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of formula %q", p.src)
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d in formula %q", c, p.pos, p.src)
+	}
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCustomCurrencies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "currencies.yaml")
+	err := os.WriteFile(path, []byte(`
+currencies:
+  pts:
+    name: loyalty points
+    reference: USD
+    rate: 100
+  bsk:
+    formula: (usd + jpy / 100) / 2
+  kpts:
+    formula: PTS / 1000
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := LoadCustomCurrencies(logrus.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates := newTestRates()
+	rates.UseCustomCurrencies(custom)
+
+	if got := rates.Currencies(); !reflect.DeepEqual(got, []string{"BSK", "EUR", "JPY", "KPTS", "PTS", "USD"}) {
+		t.Errorf("expected the custom currencies to be listed, got %v", got)
+	}
+
+	// 1 USD is 100 points
+	r, err := rates.GetRateInfo("USD", "PTS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(r.Rate-100) > 1e-9 || r.Source != SourceCustom {
+		t.Errorf("expected USD/PTS 100 from %s, got %v from %s", SourceCustom, r.Rate, r.Source)
+	}
+	if r, _ := rates.GetRate("EUR", "BSK"); math.Abs(r-1.25) > 1e-9 {
+		t.Errorf("expected EUR/BSK 1.25, got %v", r)
+	}
+	if r, _ := rates.GetRate("KPTS", "PTS"); math.Abs(r-1000) > 1e-9 {
+		t.Errorf("expected KPTS/PTS 1000, got %v", r)
+	}
+
+	// points follow the rate of their reference
+	rates.simulate()
+	if r, _ := rates.GetRate("EUR", "PTS"); math.Abs(r-121) > 1e-9 {
+		t.Errorf("expected EUR/PTS to follow USD to 121, got %v", r)
+	}
+}
+
+func TestCustomCurrenciesInvalid(t *testing.T) {
+	tests := map[string][]*CustomCurrency{
+		"no rate":     {{Code: "PTS", Reference: "USD"}},
+		"both":        {{Code: "PTS", Reference: "USD", Rate: 1, Formula: "USD"}},
+		"bad code":    {{Code: "P-TS", Reference: "USD", Rate: 1}},
+		"bad formula": {{Code: "PTS", Formula: "USD +"}},
+		"cycle": {
+			{Code: "AAA", Formula: "BBB * 2"},
+			{Code: "BBB", Formula: "(AAA + USD) / 2"},
+		},
+	}
+	for name, currencies := range tests {
+		if _, err := NewCustomCurrencies(currencies); !errors.Is(err, ErrInvalidCustomCurrency) {
+			t.Errorf("%s: expected ErrInvalidCustomCurrency, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrOverrideNotFound = fmt.Errorf("rate override not found")
+	ErrInvalidOverride  = fmt.Errorf("invalid rate override")
+)
+
+// Override is a manually set rate for a currency pair which replaces the
+// market rate until it expires or is cleared.
+type Override struct {
+	Base        string    `json:"base"`
+	Destination string    `json:"destination"`
+	Rate        float64   `json:"rate"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	CreatedBy   string    `json:"created_by"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Expired reports whether the override no longer applies at the given time.
+// Overrides without an expiry never expire.
+func (o *Override) Expired(now time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !now.Before(o.ExpiresAt)
+}
+
+// Overrides holds the rate overrides set by ops. Every change is written to
+// the overrides file, when one is configured, so that they survive restarts.
+type Overrides struct {
+	log       *logrus.Logger
+	mutex     *sync.RWMutex
+	overrides map[string]*Override
+	path      string
+	changed   chan struct{}
+	now       func() time.Time
+}
+
+// NewOverrides creates Overrides persisted at path and loads the overrides
+// saved by a previous run. With an empty path overrides are kept in memory only.
+func NewOverrides(l *logrus.Logger, path string) (*Overrides, error) {
+	o := &Overrides{
+		log:       l,
+		mutex:     &sync.RWMutex{},
+		overrides: map[string]*Override{},
+		path:      path,
+		changed:   make(chan struct{}, 1),
+		now:       time.Now,
+	}
+	if path == "" {
+		return o, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open overrides %s: %s", path, err)
+	}
+	defer f.Close()
+
+	overrides := []*Override{}
+	if err := json.NewDecoder(f).Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("unable to read overrides %s: %s", path, err)
+	}
+	for _, v := range overrides {
+		o.overrides[pairKey(v.Base, v.Destination)] = v
+	}
+	l.Infof("loaded %d rate overrides from %s", len(overrides), path)
+	return o, nil
+}
+
+// Get returns the override for the pair. An override for the inverse pair
+// is used as well, so pinning EUR/USD also pins USD/EUR.
+func (o *Overrides) Get(base, dest string) (float64, bool) {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	now := o.now()
+	if v, ok := o.overrides[pairKey(base, dest)]; ok && !v.Expired(now) {
+		return v.Rate, true
+	}
+	if v, ok := o.overrides[pairKey(dest, base)]; ok && !v.Expired(now) {
+		return 1 / v.Rate, true
+	}
+	return 0, false
+}
+
+// Set stores the override, replacing any existing override for the pair.
+// The previous override is returned so that the change can be audited.
+func (o *Overrides) Set(ov *Override) (*Override, error) {
+	if ov.Base == ov.Destination || ov.Rate <= 0 {
+		return nil, fmt.Errorf("%w: rate %f for %s/%s", ErrInvalidOverride, ov.Rate, ov.Base, ov.Destination)
+	}
+	if ov.Expired(o.now()) {
+		return nil, fmt.Errorf("%w: already expired at %s", ErrInvalidOverride, ov.ExpiresAt)
+	}
+	ov.CreatedAt = o.now()
+
+	var prev *Override
+	err := o.change(func(overrides map[string]*Override) error {
+		key := pairKey(ov.Base, ov.Destination)
+		prev = overrides[key]
+		overrides[key] = ov
+		// setting a pair replaces a pin on its inverse
+		delete(overrides, pairKey(ov.Destination, ov.Base))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+// Clear removes the override for the pair and returns it. Like with Get an
+// override of the inverse pair is cleared as well.
+func (o *Overrides) Clear(base, dest string) (*Override, error) {
+	var prev *Override
+	err := o.change(func(overrides map[string]*Override) error {
+		for _, key := range []string{pairKey(base, dest), pairKey(dest, base)} {
+			if v, ok := overrides[key]; ok {
+				prev = v
+				delete(overrides, key)
+				return nil
+			}
+		}
+		return ErrOverrideNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+// change applies update to a copy of the overrides in effect and writes the
+// copy to the overrides file. Only once it's written the copy replaces the
+// overrides and the change is announced, so a failed write changes nothing.
+// Expired overrides are dropped on the way.
+func (o *Overrides) change(update func(overrides map[string]*Override) error) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	now := o.now()
+	next := make(map[string]*Override, len(o.overrides)+1)
+	for k, v := range o.overrides {
+		if !v.Expired(now) {
+			next[k] = v
+		}
+	}
+	if err := update(next); err != nil {
+		return err
+	}
+	if err := o.flush(next); err != nil {
+		return fmt.Errorf("unable to write overrides: %s", err)
+	}
+
+	o.overrides = next
+	o.notify()
+	return nil
+}
+
+// List returns the overrides which are still in effect, sorted by pair.
+func (o *Overrides) List() []*Override {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	now := o.now()
+	list := []*Override{}
+	for _, v := range o.overrides {
+		if v.Expired(now) {
+			continue
+		}
+		cp := *v
+		list = append(list, &cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return pairKey(list[i].Base, list[i].Destination) < pairKey(list[j].Base, list[j].Destination)
+	})
+	return list
+}
+
+// Changed returns a channel which receives a value whenever an override is
+// set or cleared.
+func (o *Overrides) Changed() <-chan struct{} {
+	return o.changed
+}
+
+func (o *Overrides) notify() {
+	select {
+	case o.changed <- struct{}{}:
+	default:
+		// a change is already pending
+	}
+}
+
+// flush writes the overrides to the overrides file.
+func (o *Overrides) flush(overrides map[string]*Override) error {
+	if o.path == "" {
+		return nil
+	}
+
+	list := make([]*Override, 0, len(overrides))
+	for _, v := range overrides {
+		list = append(list, v)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(o.path, data)
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestOverridesTakePrecedence(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	o, err := NewOverrides(logrus.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.now = func() time.Time { return now }
+
+	r := newTestRates()
+	r.UseOverrides(o)
+
+	if _, err := o.Set(&Override{Base: "EUR", Destination: "USD", Rate: 2, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rate, _ := r.GetRate("EUR", "USD"); rate != 2 {
+		t.Fatalf("expected overridden rate 2 got %f", rate)
+	}
+	if info, _ := r.GetRateInfo("EUR", "USD"); info.Source != SourceOverride {
+		t.Fatalf("expected source %s got %s", SourceOverride, info.Source)
+	}
+	if rate, _ := r.GetRate("USD", "EUR"); rate != 0.5 {
+		t.Fatalf("expected inverse of the override 0.5 got %f", rate)
+	}
+
+	// overrides survive a restart
+	reloaded, err := NewOverrides(logrus.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.now = o.now
+	if list := reloaded.List(); len(list) != 1 || list[0].Rate != 2 {
+		t.Fatalf("unexpected overrides after reload %#v", list)
+	}
+
+	// the market rate is used again once the override expires
+	now = now.Add(time.Hour)
+	if rate, _ := r.GetRate("EUR", "USD"); rate != 1.1 {
+		t.Fatalf("expected market rate 1.1 after expiry got %f", rate)
+	}
+}
+
+func TestOverridesChange(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	o, err := NewOverrides(logrus.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.now = func() time.Time { return now }
+
+	if _, err := o.Set(&Override{Base: "EUR", Destination: "USD", Rate: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Set(&Override{Base: "EUR", Destination: "JPY", Rate: 150, ExpiresAt: now.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	<-o.Changed()
+
+	// the pin is cleared through its inverse pair, which Get honours too
+	prev, err := o.Clear("USD", "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.Base != "EUR" || prev.Rate != 2 {
+		t.Fatalf("unexpected cleared override %#v", prev)
+	}
+	if _, ok := o.Get("EUR", "USD"); ok {
+		t.Fatal("expected the override to be cleared")
+	}
+	<-o.Changed()
+
+	// expired overrides aren't written anymore
+	now = now.Add(time.Hour)
+	if _, err := o.Set(&Override{Base: "USD", Destination: "JPY", Rate: 140}); err != nil {
+		t.Fatal(err)
+	}
+	<-o.Changed()
+	reloaded, err := NewOverrides(logrus.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.overrides) != 1 {
+		t.Fatalf("expected the expired override to be pruned, got %d overrides", len(reloaded.overrides))
+	}
+
+	// a change which can't be written doesn't take effect
+	o.path = filepath.Join(t.TempDir(), "missing", "overrides.json")
+	if _, err := o.Set(&Override{Base: "EUR", Destination: "GBP", Rate: 0.9}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if _, ok := o.Get("EUR", "GBP"); ok {
+		t.Fatal("expected the override to be rolled back")
+	}
+	select {
+	case <-o.Changed():
+		t.Fatal("expected no change to be announced")
+	default:
+	}
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Side is the side of a conversion the applied rate is quoted for.
+type Side int
+
+const (
+	// SideMid is the mid-market rate without any spread
+	SideMid Side = iota
+	// SideBuy is the rate at which we buy the destination currency from a customer
+	SideBuy
+	// SideSell is the rate at which we sell the destination currency to a customer,
+	// this is the side used for prices shown in the catalog
+	SideSell
+)
+
+// Spread is the margin in percent applied to the mid rate for each side.
+type Spread struct {
+	Buy  float64 `mapstructure:"buy"`
+	Sell float64 `mapstructure:"sell"`
+}
+
+// PricingPolicy holds the spread for currency pairs. Pairs are keyed by
+// "BASE/DEST" or by a single destination currency, e.g. "JPY", which matches
+// every pair converting into it. Default is used for pairs without an entry.
+type PricingPolicy struct {
+	Default Spread            `mapstructure:"default"`
+	Pairs   map[string]Spread `mapstructure:"pairs"`
+}
+
+// SpreadFor returns the spread configured for the pair, the most specific
+// entry wins.
+func (p *PricingPolicy) SpreadFor(base, dest string) Spread {
+	if s, ok := p.Pairs[pairKey(base, dest)]; ok {
+		return s
+	}
+	if s, ok := p.Pairs[strings.ToUpper(dest)]; ok {
+		return s
+	}
+	return p.Default
+}
+
+// Apply returns the rate for the side after applying the pair's spread to mid.
+// Selling the destination currency marks the rate up, buying it marks it down.
+func (p *PricingPolicy) Apply(base, dest string, side Side, mid float64) float64 {
+	s := p.SpreadFor(base, dest)
+	switch side {
+	case SideSell:
+		return mid * (100 + s.Sell) / 100
+	case SideBuy:
+		return mid * (100 - s.Buy) / 100
+	default:
+		return mid
+	}
+}
+
+// Validate checks that every spread is a percentage in [0, 100), larger
+// spreads would turn the buy rate zero or negative.
+func (p *PricingPolicy) Validate() error {
+	if err := p.Default.validate(); err != nil {
+		return fmt.Errorf("default: %s", err)
+	}
+	for pair, s := range p.Pairs {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("%s: %s", pair, err)
+		}
+	}
+	return nil
+}
+
+func (s Spread) validate() error {
+	if s.Buy < 0 || s.Buy >= 100 || s.Sell < 0 || s.Sell >= 100 {
+		return fmt.Errorf("spreads should be between 0 and 100, got buy %g and sell %g", s.Buy, s.Sell)
+	}
+	return nil
+}
+
+func pairKey(base, dest string) string {
+	return strings.ToUpper(base) + "/" + strings.ToUpper(dest)
+}
+
+// Pricing applies the pricing policy to mid-market rates. The policy is read
+// from a config file and reloaded whenever the file changes.
+type Pricing struct {
+	log    *logrus.Logger
+	mutex  *sync.RWMutex
+	policy *PricingPolicy
+	path   string
+	// reloadMutex serializes the reloads of the watcher and Reload
+	reloadMutex *sync.Mutex
+}
+
+// NewPricing creates a Pricing with the policy loaded from path and starts
+// watching the file for changes. With an empty path no spread is applied.
+func NewPricing(l *logrus.Logger, path string) (*Pricing, error) {
+	p := &Pricing{
+		log:         l,
+		mutex:       &sync.RWMutex{},
+		policy:      &PricingPolicy{Pairs: map[string]Spread{}},
+		path:        path,
+		reloadMutex: &sync.Mutex{},
+	}
+	if path == "" {
+		return p, nil
+	}
+
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+
+	// the watcher reads the file into its own viper on its goroutine, the
+	// policy is read again into a fresh one so that Reload can run meanwhile
+	watcher := viper.New()
+	watcher.SetConfigFile(path)
+	watcher.OnConfigChange(func(e fsnotify.Event) {
+		p.log.Info("pricing policy changed ", e.Name)
+		if err := p.Reload(); err != nil {
+			// keep using the last good policy
+			p.log.Errorf("unable to reload pricing policy: %s", err)
+		}
+	})
+	watcher.WatchConfig()
+
+	return p, nil
+}
+
+// NewStaticPricing creates a Pricing which always uses the given policy.
+func NewStaticPricing(l *logrus.Logger, policy *PricingPolicy) *Pricing {
+	return &Pricing{
+		log:    l,
+		mutex:  &sync.RWMutex{},
+		policy: policy,
+	}
+}
+
+// Reload reads the policy file again and swaps in the new policy.
+func (p *Pricing) Reload() error {
+	if p.path == "" {
+		return nil
+	}
+	p.reloadMutex.Lock()
+	defer p.reloadMutex.Unlock()
+
+	cfg := viper.New()
+	cfg.SetConfigFile(p.path)
+	if err := cfg.ReadInConfig(); err != nil {
+		return fmt.Errorf("unable to read pricing policy: %s", err)
+	}
+	return p.load(cfg)
+}
+
+// load swaps in the policy read into cfg.
+func (p *Pricing) load(cfg *viper.Viper) error {
+	policy := &PricingPolicy{}
+	if err := cfg.Unmarshal(policy); err != nil {
+		return fmt.Errorf("unable to parse pricing policy: %s", err)
+	}
+
+	// viper lower cases keys, pairs are looked up in upper case
+	pairs := make(map[string]Spread, len(policy.Pairs))
+	for k, v := range policy.Pairs {
+		pairs[strings.ToUpper(k)] = v
+	}
+	policy.Pairs = pairs
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid pricing policy: %s", err)
+	}
+
+	p.mutex.Lock()
+	p.policy = policy
+	p.mutex.Unlock()
+
+	p.log.Infof("loaded pricing policy %+v", *policy)
+	return nil
+}
+
+// Apply returns the rate for the side using the current policy.
+func (p *Pricing) Apply(base, dest string, side Side, mid float64) float64 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.policy.Apply(base, dest, side, mid)
+}
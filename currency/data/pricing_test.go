@@ -0,0 +1,88 @@
+package data
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestPricingPolicyApply(t *testing.T) {
+	p := &PricingPolicy{
+		Default: Spread{Buy: 1, Sell: 1},
+		Pairs: map[string]Spread{
+			"JPY":     {Buy: 2, Sell: 2},
+			"USD/JPY": {Buy: 3, Sell: 3},
+		},
+	}
+
+	tt := []struct {
+		base, dest string
+		side       Side
+		want       float64
+	}{
+		{"EUR", "USD", SideMid, 100},
+		{"EUR", "USD", SideSell, 101},
+		{"EUR", "USD", SideBuy, 99},
+		{"EUR", "JPY", SideSell, 102},
+		{"USD", "JPY", SideSell, 103},
+	}
+	for _, tc := range tt {
+		got := p.Apply(tc.base, tc.dest, tc.side, 100)
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s/%s side %d: expected %f got %f", tc.base, tc.dest, tc.side, tc.want, got)
+		}
+	}
+}
+
+func TestPricingReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.yaml")
+	writePolicy := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePolicy("default:\n  sell: 1\npairs:\n  jpy:\n    sell: 2\n")
+	p, err := NewPricing(logrus.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Apply("EUR", "JPY", SideSell, 100); math.Abs(got-102) > 1e-9 {
+		t.Fatalf("expected 102 got %f", got)
+	}
+
+	writePolicy("default:\n  sell: 5\n")
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Apply("EUR", "JPY", SideSell, 100); math.Abs(got-105) > 1e-9 {
+		t.Fatalf("expected 105 after reload got %f", got)
+	}
+
+	// a spread of 100% would make the buy rate zero, the last good policy stays
+	writePolicy("default:\n  sell: 5\npairs:\n  jpy:\n    buy: 100\n")
+	if err := p.Reload(); err == nil {
+		t.Fatal("expected a buy spread of 100% to be rejected")
+	}
+	if got := p.Apply("EUR", "JPY", SideBuy, 100); math.Abs(got-100) > 1e-9 {
+		t.Fatalf("expected the previous policy to be kept, got %f", got)
+	}
+	if _, err := NewPricing(logrus.New(), path); err == nil {
+		t.Fatal("expected an invalid policy to be rejected on start")
+	}
+}
+
+func TestPricingPolicyValidate(t *testing.T) {
+	for _, s := range []Spread{{Buy: -1}, {Buy: 100}, {Sell: 150}, {Sell: -0.5}} {
+		p := &PricingPolicy{Pairs: map[string]Spread{"USD": s}}
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected spread %+v to be rejected", s)
+		}
+	}
+	if err := (&PricingPolicy{Default: Spread{Buy: 99.9, Sell: 0}}).Validate(); err != nil {
+		t.Errorf("expected spread to be valid, got %s", err)
+	}
+}
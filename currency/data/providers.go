@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Provider is a source of exchange rates against EUR.
+type Provider interface {
+	// Name identifies the provider in logs, metrics and consensus reports.
+	Name() string
+	// Fetch returns the rate of every currency the provider publishes.
+	Fetch(ctx context.Context) (map[string]float64, error)
+}
+
+// ECBProvider fetches rates in the XML format of the European Central Bank's
+// daily reference rates.
+type ECBProvider struct {
+	name   string
+	uri    string
+	client *http.Client
+}
+
+func NewECBProvider(name, uri string) *ECBProvider {
+	return &ECBProvider{
+		name:   name,
+		uri:    uri,
+		client: http.DefaultClient,
+	}
+}
+
+func (p *ECBProvider) Name() string {
+	return p.name
+}
+
+func (p *ECBProvider) Fetch(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Getting error %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected error code 200 got %d", resp.StatusCode)
+	}
+	return parseECB(resp.Body)
+}
+
+// FileProvider reads rates from a local file, either in the ECB XML format or,
+// for files ending in .json, as a JSON object of currency codes to rates.
+type FileProvider struct {
+	name string
+	path string
+}
+
+func NewFileProvider(name, path string) *FileProvider {
+	return &FileProvider{
+		name: name,
+		path: path,
+	}
+}
+
+func (p *FileProvider) Name() string {
+	return p.name
+}
+
+func (p *FileProvider) Fetch(_ context.Context) (map[string]float64, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open rates %s: %s", p.path, err)
+	}
+	defer f.Close()
+
+	if filepath.Ext(p.path) != ".json" {
+		return parseECB(f)
+	}
+
+	rates := map[string]float64{}
+	if err := json.NewDecoder(f).Decode(&rates); err != nil {
+		return nil, fmt.Errorf("unable to read rates %s: %s", p.path, err)
+	}
+	normalized := make(map[string]float64, len(rates)+1)
+	for k, v := range rates {
+		normalized[strings.ToUpper(k)] = v
+	}
+	normalized[baseCurrency] = 1
+	return normalized, nil
+}
+
+// NewProviders creates providers from comma separated "name=uri" specs. URIs
+// starting with file:// are read by a FileProvider, others are fetched by an
+// ECBProvider. Without specs the ECB at defaultURI is the only provider.
+func NewProviders(specs []string, defaultURI string) ([]Provider, error) {
+	list := []string{}
+	for _, v := range specs {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	if len(list) == 0 {
+		return []Provider{NewECBProvider(SourceECB, defaultURI)}, nil
+	}
+
+	providers := []Provider{}
+	names := map[string]bool{}
+	for _, s := range list {
+		name, uri, ok := strings.Cut(s, "=")
+		if !ok || name == "" || uri == "" {
+			return nil, fmt.Errorf("invalid rate provider %q, expected name=uri", s)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate rate provider %s", name)
+		}
+		names[name] = true
+
+		if strings.HasPrefix(uri, "file://") {
+			providers = append(providers, NewFileProvider(name, strings.TrimPrefix(uri, "file://")))
+			continue
+		}
+		providers = append(providers, NewECBProvider(name, uri))
+	}
+	return providers, nil
+}
+
+// parseECB reads rates in the ECB XML format.
+func parseECB(r io.Reader) (map[string]float64, error) {
+	cubes := &Cubes{}
+	if err := xml.NewDecoder(r).Decode(&cubes); err != nil {
+		return nil, err
+	}
+	rates := map[string]float64{}
+	for _, c := range cubes.CubeData {
+		r, err := strconv.ParseFloat(c.Rate, 64)
+		if err != nil {
+			return nil, err
+		}
+		rates[c.Currency] = r
+	}
+	rates[baseCurrency] = 1
+	return rates, nil
+}
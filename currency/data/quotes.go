@@ -0,0 +1,259 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrQuoteNotFound = fmt.Errorf("quote not found")
+	ErrQuoteExpired  = fmt.Errorf("quote expired")
+	ErrQuoteRedeemed = fmt.Errorf("quote already redeemed")
+	ErrInvalidTTL    = fmt.Errorf("invalid quote ttl")
+	ErrTooManyQuotes = fmt.Errorf("too many quotes")
+)
+
+// Quote is an exchange rate for a currency pair which is locked in until ExpiresAt.
+type Quote struct {
+	ID          string     `json:"id"`
+	Base        string     `json:"base"`
+	Destination string     `json:"destination"`
+	Rate        float64    `json:"rate"`
+	MidRate     float64    `json:"mid_rate"`
+	Side        Side       `json:"side"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RedeemedAt  *time.Time `json:"redeemed_at,omitempty"`
+}
+
+// Expired reports whether the quote is no longer valid at the given time.
+func (q *Quote) Expired(now time.Time) bool {
+	return !now.Before(q.ExpiresAt)
+}
+
+// Redeemed reports whether the quote has already been used.
+func (q *Quote) Redeemed() bool {
+	return q.RedeemedAt != nil
+}
+
+// QuoteStore persists quotes between lookups.
+type QuoteStore interface {
+	// Save stores the quote and drops the quotes expired at now in the same
+	// write. A new quote is refused with ErrTooManyQuotes when the store is
+	// full of quotes which haven't expired.
+	Save(q *Quote, now time.Time) error
+	Get(id string) (*Quote, error)
+}
+
+// MemoryQuoteStore keeps quotes in a map, they are lost when the process exits.
+type MemoryQuoteStore struct {
+	mutex  *sync.RWMutex
+	quotes map[string]*Quote
+	// maxQuotes is the most quotes kept, unbounded when zero
+	maxQuotes int
+}
+
+func NewMemoryQuoteStore(maxQuotes int) *MemoryQuoteStore {
+	return &MemoryQuoteStore{
+		mutex:     &sync.RWMutex{},
+		quotes:    map[string]*Quote{},
+		maxQuotes: maxQuotes,
+	}
+}
+
+func (m *MemoryQuoteStore) Save(q *Quote, now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, q := range m.quotes {
+		if q.Expired(now) {
+			delete(m.quotes, id)
+		}
+	}
+	if _, ok := m.quotes[q.ID]; !ok && m.maxQuotes > 0 && len(m.quotes) >= m.maxQuotes {
+		return fmt.Errorf("%w: %d quotes haven't expired yet", ErrTooManyQuotes, len(m.quotes))
+	}
+
+	// store a copy so that callers can't modify the stored quote
+	cp := *q
+	m.quotes[q.ID] = &cp
+	return nil
+}
+
+func (m *MemoryQuoteStore) Get(id string) (*Quote, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	q, ok := m.quotes[id]
+	if !ok {
+		return nil, ErrQuoteNotFound
+	}
+	cp := *q
+	return &cp, nil
+}
+
+// FileQuoteStore is a MemoryQuoteStore which writes all quotes to a JSON file
+// on every save, and loads them back when it is created.
+type FileQuoteStore struct {
+	*MemoryQuoteStore
+	path string
+	// flushMutex serializes taking the snapshot and writing it, so that an
+	// older snapshot never replaces a newer one on disk
+	flushMutex *sync.Mutex
+}
+
+// NewFileQuoteStore creates a FileQuoteStore backed by the file at path.
+// A missing file is treated as an empty store.
+func NewFileQuoteStore(path string, maxQuotes int) (*FileQuoteStore, error) {
+	fs := &FileQuoteStore{
+		MemoryQuoteStore: NewMemoryQuoteStore(maxQuotes),
+		path:             path,
+		flushMutex:       &sync.Mutex{},
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open quote store %s: %s", path, err)
+	}
+	defer f.Close()
+
+	quotes := []*Quote{}
+	if err := json.NewDecoder(f).Decode(&quotes); err != nil {
+		return nil, fmt.Errorf("unable to read quote store %s: %s", path, err)
+	}
+	for _, q := range quotes {
+		fs.quotes[q.ID] = q
+	}
+	return fs, nil
+}
+
+func (f *FileQuoteStore) Save(q *Quote, now time.Time) error {
+	if err := f.MemoryQuoteStore.Save(q, now); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+// flush writes all quotes to the store file.
+func (f *FileQuoteStore) flush() error {
+	f.flushMutex.Lock()
+	defer f.flushMutex.Unlock()
+
+	f.mutex.RLock()
+	quotes := make([]*Quote, 0, len(f.quotes))
+	for _, q := range f.quotes {
+		quotes = append(quotes, q)
+	}
+	data, err := json.Marshal(quotes)
+	f.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(f.path, data)
+}
+
+// Quotes creates and redeems quotes using the current exchange rates and pricing policy.
+type Quotes struct {
+	log        *logrus.Logger
+	rates      *ExchangeRates
+	pricing    *Pricing
+	store      QuoteStore
+	mutex      *sync.Mutex
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	now        func() time.Time
+}
+
+// NewQuotes creates a Quotes using the given store. defaultTTL is used when a quote
+// is created without a ttl, and quotes can never live longer than maxTTL.
+func NewQuotes(l *logrus.Logger, r *ExchangeRates, p *Pricing, s QuoteStore, defaultTTL, maxTTL time.Duration) *Quotes {
+	return &Quotes{
+		log:        l,
+		rates:      r,
+		pricing:    p,
+		store:      s,
+		mutex:      &sync.Mutex{},
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+		now:        time.Now,
+	}
+}
+
+// Create locks in the current rate for base and dest on the given side for the duration of ttl.
+func (q *Quotes) Create(base, dest string, side Side, ttl time.Duration) (*Quote, error) {
+	if ttl == 0 {
+		ttl = q.defaultTTL
+	}
+	if ttl < 0 || (q.maxTTL > 0 && ttl > q.maxTTL) {
+		return nil, fmt.Errorf("%w: %s, should be between 0 and %s", ErrInvalidTTL, ttl, q.maxTTL)
+	}
+
+	mid, err := q.rates.GetRate(base, dest)
+	if err != nil {
+		return nil, err
+	}
+	rate := q.pricing.Apply(base, dest, side, mid)
+
+	now := q.now()
+	quote := &Quote{
+		ID:          uuid.New().String(),
+		Base:        base,
+		Destination: dest,
+		Rate:        rate,
+		MidRate:     mid,
+		Side:        side,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	if err := q.store.Save(quote, now); err != nil {
+		return nil, err
+	}
+
+	q.log.Infof("created quote %s for %s/%s at %f", quote.ID, base, dest, rate)
+	return quote, nil
+}
+
+// Get returns the quote with the given id, expired and redeemed quotes are
+// returned as well so that callers can report their state.
+func (q *Quotes) Get(id string) (*Quote, error) {
+	return q.store.Get(id)
+}
+
+// Redeem marks the quote as used. A quote can only be redeemed once and only
+// before it expires.
+func (q *Quotes) Redeem(id string) (*Quote, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quote, err := q.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	now := q.now()
+	if quote.Redeemed() {
+		return quote, ErrQuoteRedeemed
+	}
+	if quote.Expired(now) {
+		return quote, ErrQuoteExpired
+	}
+
+	redeemed := *quote
+	redeemed.RedeemedAt = &now
+	if err := q.store.Save(&redeemed, now); err != nil {
+		return nil, err
+	}
+
+	q.log.Infof("redeemed quote %s", redeemed.ID)
+	return &redeemed, nil
+}
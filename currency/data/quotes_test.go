@@ -0,0 +1,192 @@
+package data
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestRates() *ExchangeRates {
+	return &ExchangeRates{
+		log:   logrus.New(),
+		mutex: &sync.Mutex{},
+		rates: map[string]float64{"EUR": 1, "USD": 1.1, "JPY": 140},
+		// moves USD and JPY by exactly 10% on every tick
+		simulator: NewSimulator(NewReplay([]map[string]float64{{"USD": 1.21, "JPY": 154}, {"USD": 1.1, "JPY": 140}}), time.Hour),
+	}
+}
+
+func TestQuoteRedeem(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuotes(logrus.New(), newTestRates(), NewStaticPricing(logrus.New(), &PricingPolicy{}), NewMemoryQuoteStore(0), time.Minute, time.Hour)
+	q.now = func() time.Time { return now }
+
+	quote, err := q.Create("EUR", "USD", SideMid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Rate != 1.1 {
+		t.Fatalf("expected rate 1.1 got %f", quote.Rate)
+	}
+	if !quote.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected default ttl to be used, expires at %s", quote.ExpiresAt)
+	}
+
+	// the locked in rate must not follow the market
+	q.rates.rates["USD"] = 2
+
+	redeemed, err := q.Redeem(quote.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redeemed.Rate != 1.1 || !redeemed.Redeemed() {
+		t.Fatalf("unexpected redeemed quote %#v", redeemed)
+	}
+
+	if _, err := q.Redeem(quote.ID); !errors.Is(err, ErrQuoteRedeemed) {
+		t.Fatalf("expected ErrQuoteRedeemed got %v", err)
+	}
+}
+
+func TestQuoteExpired(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	q := NewQuotes(logrus.New(), newTestRates(), NewStaticPricing(logrus.New(), &PricingPolicy{}), NewMemoryQuoteStore(0), time.Minute, time.Hour)
+	q.now = func() time.Time { return now }
+
+	if _, err := q.Create("EUR", "USD", SideMid, 2*time.Hour); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected ErrInvalidTTL got %v", err)
+	}
+
+	quote, err := q.Create("EUR", "JPY", SideMid, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, err := q.Redeem(quote.ID); !errors.Is(err, ErrQuoteExpired) {
+		t.Fatalf("expected ErrQuoteExpired got %v", err)
+	}
+}
+
+func TestQuoteStoreBounded(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "quotes.json")
+	s, err := NewFileQuoteStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuotes(logrus.New(), newTestRates(), NewStaticPricing(logrus.New(), &PricingPolicy{}), s, time.Minute, time.Hour)
+	q.now = func() time.Time { return now }
+
+	first, err := q.Create("EUR", "USD", SideMid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Create("EUR", "JPY", SideMid, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Create("EUR", "USD", SideMid, 0); !errors.Is(err, ErrTooManyQuotes) {
+		t.Fatalf("expected ErrTooManyQuotes got %v", err)
+	}
+	// quotes in the store can still be redeemed
+	if _, err := q.Redeem(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// saving drops the expired quotes
+	now = now.Add(time.Minute)
+	quote, err := q.Create("EUR", "USD", SideMid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewFileQuoteStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Get(first.ID); !errors.Is(err, ErrQuoteNotFound) {
+		t.Errorf("expected the expired quote to be dropped got %v", err)
+	}
+	if _, err := reloaded.Get(quote.ID); err != nil {
+		t.Errorf("expected the new quote to be saved got %v", err)
+	}
+}
+
+func TestFileQuoteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotes.json")
+	s, err := NewFileQuoteStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Hour).UTC()
+	if err := s.Save(&Quote{ID: "abc", Base: "EUR", Destination: "USD", Rate: 1.1, ExpiresAt: expires}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileQuoteStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := reloaded.Get("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Rate != 1.1 || !q.ExpiresAt.Equal(expires) {
+		t.Fatalf("unexpected quote %#v", q)
+	}
+}
+
+func TestFileQuoteStoreConcurrentRedeem(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotes.json")
+	s, err := NewFileQuoteStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuotes(logrus.New(), newTestRates(), NewStaticPricing(logrus.New(), &PricingPolicy{}), s, time.Minute, time.Hour)
+
+	ids := []string{}
+	for i := 0; i < 20; i++ {
+		quote, err := q.Create("EUR", "USD", SideMid, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, quote.ID)
+	}
+
+	// creating quotes flushes snapshots while the redemptions flush theirs
+	wg := &sync.WaitGroup{}
+	for _, id := range ids {
+		wg.Add(2)
+		go func(id string) {
+			defer wg.Done()
+			if _, err := q.Redeem(id); err != nil {
+				t.Error(err)
+			}
+		}(id)
+		go func() {
+			defer wg.Done()
+			if _, err := q.Create("EUR", "JPY", SideMid, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reloaded, err := NewFileQuoteStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		quote, err := reloaded.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !quote.Redeemed() {
+			t.Errorf("expected quote %s to be redeemed after a restart", id)
+		}
+	}
+}
@@ -2,17 +2,14 @@ package data
 
 import (
 	"context"
-	"encoding/xml"
 	"fmt"
-	"io"
-	"math/rand"
-	"net/http"
-	"strconv"
+	"sort"
 	"sync"
 	"time"
 
 	config "github.com/samims/ecommerceGO/currency/configs"
 	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/samims/ecommerceGO/currency/metrics"
 
 	"github.com/sirupsen/logrus"
 )
@@ -20,98 +17,381 @@ import (
 //var rateURI string = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
 
 type ExchangeRates struct {
-	log   *logrus.Logger
-	mutex *sync.Mutex
-	rates map[string]float64
+	log       *logrus.Logger
+	mutex     *sync.Mutex
+	rates     map[string]float64
+	updatedAt time.Time
+	overrides *Overrides
+	custom    *CustomCurrencies
+	providers []Provider
+	// maxDeviation is the percentage a provider's rate may deviate from the
+	// median of all providers before it is rejected
+	maxDeviation float64
+	consensus    *ConsensusReport
+	metrics      *metrics.Registry
+	// snapshotPath is where every successful fetch is saved, empty disables snapshots
+	snapshotPath string
+	// fetchedAt is when the rates were fetched from the live source
+	fetchedAt time.Time
+	// refreshedAt is when the rates were last loaded from the providers or a
+	// snapshot, unlike updatedAt it doesn't move with the simulation
+	refreshedAt time.Time
+	// stale is set while the rates come from a snapshot because the live
+	// source couldn't be reached
+	stale bool
+	// source is where the current rates come from, one of the Source constants
+	source string
+	// sequence is incremented on every update of the rates
+	sequence uint64
+	// simulator moves the rates between fetches
+	simulator *Simulator
+	// listeners are the channels returned by MonitorRates
+	listeners []chan bool
 }
 
-func NewRates(l *logrus.Logger, cfg config.Env) (*ExchangeRates, error) {
+// NewRates fetches the rates from the configured providers, the ECB at
+// RATE_URI when none are configured. When that fails and a
+// snapshot file is configured, the rates saved by a previous run are used
+// and marked as stale until RetryLive reaches the source. Provider errors
+// and disagreements are counted in m, from the first fetch on, unless it is nil.
+func NewRates(l *logrus.Logger, cfg config.Env, m *metrics.Registry) (*ExchangeRates, error) {
 	exchangeRates := &ExchangeRates{
-		log:   l,
-		rates: map[string]float64{},
-		mutex: &sync.Mutex{},
+		log:          l,
+		metrics:      m,
+		rates:        map[string]float64{},
+		mutex:        &sync.Mutex{},
+		maxDeviation: cfg.GetFloat64(constants.EnvRateMaxDeviation),
+		snapshotPath: cfg.GetString(constants.EnvRateSnapshotFile),
+		simulator:    NewSimulator(NewRandomWalk(time.Now().UnixNano(), 10, 0), 5*time.Second),
 	}
 
-	rateURI := cfg.GetString(constants.EnvRateUri)
+	providers, err := NewProviders(cfg.GetStringSlice(constants.EnvRateProviders), cfg.GetString(constants.EnvRateUri))
+	if err != nil {
+		return nil, err
+	}
+	exchangeRates.providers = providers
+	if exchangeRates.maxDeviation <= 0 {
+		exchangeRates.maxDeviation = constants.DefaultRateMaxDeviation
+	}
 
-	err := exchangeRates.gerRates(rateURI)
+	err = exchangeRates.fetchRates(context.Background())
+	if err == nil || exchangeRates.snapshotPath == "" {
+		return exchangeRates, err
+	}
 
-	return exchangeRates, err
+	snap, serr := LoadSnapshot(exchangeRates.snapshotPath)
+	if serr != nil {
+		l.Errorf("no rate snapshot to fall back to: %s", serr)
+		return exchangeRates, err
+	}
+	l.Warnf("unable to fetch live rates, using snapshot fetched at %s: %s", snap.FetchedAt, err)
 
+	exchangeRates.mutex.Lock()
+	exchangeRates.rates = snap.Rates
+	exchangeRates.fetchedAt = snap.FetchedAt
+	exchangeRates.updatedAt = time.Now()
+	exchangeRates.refreshedAt = exchangeRates.updatedAt
+	exchangeRates.stale = true
+	exchangeRates.source = SourceSnapshot
+	exchangeRates.sequence++
+	exchangeRates.mutex.Unlock()
+	return exchangeRates, nil
 }
 
-func (e *ExchangeRates) GetRate(base, dest string) (float64, error) {
-	br, ok := e.rates[base]
-	if !ok {
-		return 0, fmt.Errorf("rate not found for currency %s", base)
+// Stale reports whether the rates come from a snapshot rather than the live
+// source, and when they were fetched from the live source.
+func (e *ExchangeRates) Stale() (bool, time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.stale, e.fetchedAt
+}
+
+// RetryLive fetches the rates from the live source every interval while they
+// are stale, until a fetch succeeds or the context is done.
+func (e *ExchangeRates) RetryLive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if stale, _ := e.Stale(); !stale {
+			return
+		}
+		select {
+		case <-ticker.C:
+			if err := e.fetchRates(ctx); err != nil {
+				e.log.Warnf("live rates still unavailable: %s", err)
+				continue
+			}
+			e.log.Info("fetched live rates, no longer serving the snapshot")
+		case <-ctx.Done():
+			return
+		}
 	}
-	dr, ok := e.rates[dest]
+}
 
-	return dr / br, nil
+// LastUpdated returns when the rates last changed, it is zero until the
+// first snapshot has been loaded.
+func (e *ExchangeRates) LastUpdated() time.Time {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.updatedAt
 }
 
-// MonitorRates returns a channel that can be used to monitor currency exchange
-func (e *ExchangeRates) MonitorRates(ctx context.Context, interval time.Duration) chan bool {
-	// Create a new channel of type struct{} and assign it to ret variable
-	ret := make(chan bool)
-
-	// Start a new goroutine that runs the update loop in the background
-	go func(interval time.Duration) {
-		ticker := time.NewTicker(interval)
-
-		// Enter an infinite loop that waits for messages from the ticker channel
-		for {
-			select {
-			// When a message is received from the ticker channel, simulate currency
-			// rate fluctuations and notify any listeners of updates
-			case <-ticker.C:
-				// Acquire the mutex before accessing e.rates
-				e.mutex.Lock()
-
-				// Iterate through each currency rate in the map and modify its value
-				// by a random percentage between 10% increase and 10% decrease
-				for k, v := range e.rates {
-					// Generate a random percentage change between -10% and +10%
-					changePercent := rand.Intn(21) - 10 // Returns a random integer between -10 and +10
-
-					// Randomly determine whether the change should be positive or negative
-					direction := rand.Intn(2) // Returns either 0 or 1
-
-					// Modify the rate for the current currency by multiplying it with
-					// the change percentage and direction
-					if direction == 0 {
-						// If the direction is negative, subtract the change percentage from the rate
-						e.rates[k] = v * (100.0 - float64(changePercent)) / 100
-					} else {
-						// If the direction is positive, add the change percentage to the rate
-						e.rates[k] = v * (100.0 + float64(changePercent)) / 100
-					}
-				}
-
-				// Release the mutex after we are done accessing e.rates
-				e.mutex.Unlock()
-
-				// Notify any listeners of updates by sending an empty struct{} on the ret channel
-				// This will block if there is no listener on the other end
-				select {
-				case ret <- true:
-				case <-ctx.Done():
-					e.log.Info("Manually shutdown using context1")
-
-					return
-				}
-
-			case <-ctx.Done():
-				e.log.Info("Manually shutdown using context")
-				return
+// LastRefreshed returns when the rates were last loaded from the providers,
+// or from a snapshot at startup. It is zero until the rates were loaded.
+func (e *ExchangeRates) LastRefreshed() time.Time {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.refreshedAt
+}
+
+// UseOverrides makes GetRate and MonitorRates respect the manual rate overrides.
+func (e *ExchangeRates) UseOverrides(o *Overrides) {
+	e.overrides = o
+}
+
+// UseCustomCurrencies adds the custom currencies to the currencies of the
+// rate source. A custom currency takes precedence over a published currency
+// with the same code.
+func (e *ExchangeRates) UseCustomCurrencies(c *CustomCurrencies) {
+	e.custom = c
+}
+
+// Currencies returns the codes of the currencies the rate source publishes
+// rates for and of the custom currencies which can be computed, sorted.
+func (e *ExchangeRates) Currencies() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	codes := make([]string, 0, len(e.rates))
+	for k := range e.rates {
+		if e.custom == nil || !e.custom.Has(k) {
+			codes = append(codes, k)
+		}
+	}
+	if e.custom != nil {
+		for _, k := range e.custom.Codes() {
+			if _, err := e.custom.Rate(k, e.rates); err == nil {
+				codes = append(codes, k)
 			}
 		}
-	}(interval)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// HasCurrency reports whether there is a rate for the currency.
+func (e *ExchangeRates) HasCurrency(code string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, err := e.rate(code)
+	return err == nil
+}
+
+// rate returns the rate of the currency against EUR, the mutex must be held.
+func (e *ExchangeRates) rate(code string) (float64, error) {
+	if e.custom != nil && e.custom.Has(code) {
+		return e.custom.Rate(code, e.rates)
+	}
+	r, ok := e.rates[code]
+	if !ok {
+		return 0, fmt.Errorf("rate not found for currency %s", code)
+	}
+	return r, nil
+}
+
+// Sources of the rates, reported with every rate
+const (
+	// SourceECB rates are fetched from the European Central Bank
+	SourceECB = "ecb"
+	// SourceSnapshot rates are loaded from the snapshot of a previous run
+	SourceSnapshot = "snapshot"
+	// SourceSimulated rates are the fetched rates with simulated fluctuations
+	SourceSimulated = "simulated"
+	// SourceOverride rates are set by ops through the admin API
+	SourceOverride = "override"
+	// SourceConsensus rates are the consensus of several providers
+	SourceConsensus = "consensus"
+	// SourceCustom rates involve a currency defined in the custom currencies file
+	SourceCustom = "custom"
+)
+
+// fetchTimeout bounds a fetch from all providers
+const fetchTimeout = 30 * time.Second
+
+// Rate is an exchange rate together with where and when it was observed.
+type Rate struct {
+	Rate       float64
+	Source     string
+	ObservedAt time.Time
+	// Sequence is the sequence number of the update the rate comes from
+	Sequence uint64
+}
+
+// GetRate returns the rate to convert base to dest. A manual override for the
+// pair takes precedence over the market rate.
+func (e *ExchangeRates) GetRate(base, dest string) (float64, error) {
+	r, err := e.GetRateInfo(base, dest)
+	if err != nil {
+		return 0, err
+	}
+	return r.Rate, nil
+}
+
+// GetRateInfo returns the rate to convert base to dest along with its source,
+// observation time and update sequence number.
+func (e *ExchangeRates) GetRateInfo(base, dest string) (*Rate, error) {
+	var override float64
+	overridden := false
+	if e.overrides != nil {
+		override, overridden = e.overrides.Get(base, dest)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	r := &Rate{Source: e.source, ObservedAt: e.updatedAt, Sequence: e.sequence}
+	if overridden {
+		r.Rate, r.Source = override, SourceOverride
+		return r, nil
+	}
+
+	br, err := e.rate(base)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := e.rate(dest)
+	if err != nil {
+		return nil, err
+	}
+	if e.custom != nil && (e.custom.Has(base) || e.custom.Has(dest)) {
+		r.Source = SourceCustom
+	}
+
+	r.Rate = dr / br
+	return r, nil
+}
+
+// MonitorRates returns a channel that can be used to monitor currency exchange
+// rates, the rates are moved by the simulator on every tick. Setting or
+// clearing a rate override is notified on the channel as well.
+// Every call gets its own channel, updates a consumer hasn't received yet
+// are merged into one. The channel is closed when ctx is done or the update
+// loop run by Monitor ends.
+func (e *ExchangeRates) MonitorRates(ctx context.Context) chan bool {
+	ret := make(chan bool, 1)
+
+	e.mutex.Lock()
+	e.listeners = append(e.listeners, ret)
+	e.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		e.removeListener(ret)
+	}()
 
 	// Return the channel to the caller
 	return ret
 }
 
+// removeListener closes the channel unless the update loop already did.
+func (e *ExchangeRates) removeListener(ch chan bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for i, l := range e.listeners {
+		if l == ch {
+			e.listeners = append(e.listeners[:i], e.listeners[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// Monitor runs the update loop notifying the channels of MonitorRates until
+// ctx is done, all channels are closed then. It is run once for the lifetime
+// of the server, independent of the consumers.
+func (e *ExchangeRates) Monitor(ctx context.Context) {
+	e.monitor(ctx, e.simulator.Interval())
+}
+
+// monitor runs the update loop of Monitor.
+func (e *ExchangeRates) monitor(ctx context.Context, interval time.Duration) {
+	// a nil channel never receives, so without overrides only the ticker fires
+	var overridesChanged <-chan struct{}
+	if e.overrides != nil {
+		overridesChanged = e.overrides.Changed()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Enter an infinite loop that waits for messages from the ticker channel
+	for {
+		select {
+		// When an override changes the rates of the pinned pair change
+		// without any simulation
+		case <-overridesChanged:
+			e.mutex.Lock()
+			e.updatedAt = time.Now()
+			e.sequence++
+			e.mutex.Unlock()
+			e.notify()
+
+		// When a message is received from the ticker channel, simulate currency
+		// rate fluctuations and notify any listeners of updates
+		case <-ticker.C:
+			e.simulate()
+			e.notify()
+
+		case <-ctx.Done():
+			e.log.Info("Manually shutdown using context")
+			e.mutex.Lock()
+			for _, l := range e.listeners {
+				close(l)
+			}
+			e.listeners = nil
+			e.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// notify tells every listener of MonitorRates about an update.
+func (e *ExchangeRates) notify() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, l := range e.listeners {
+		select {
+		case l <- true:
+		default:
+			// the listener hasn't seen the previous update yet
+		}
+	}
+}
+
+// UseSimulator replaces the default simulator, which moves the rates by a
+// random walk of up to 10% every 5 seconds.
+func (e *ExchangeRates) UseSimulator(s *Simulator) {
+	e.simulator = s
+}
+
+// simulate moves the rates one tick forward.
+func (e *ExchangeRates) simulate() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.simulator.Step(e.rates)
+	e.updatedAt = time.Now()
+	e.source = SourceSimulated
+	e.sequence++
+}
+
 // rates at a given time interval
 //func (e *ExchangeRates) MonitorRates(ctx context.Context, interval time.Duration) chan bool {
 //	// Create a new channel of type struct{} and assign it to ret variable
@@ -168,41 +448,142 @@ func (e *ExchangeRates) MonitorRates(ctx context.Context, interval time.Duration
 //	return ret
 //}
 
-func (e *ExchangeRates) gerRates(uri string) error {
-	resp, err := http.DefaultClient.Get(uri)
-	if err != nil {
-		return fmt.Errorf("Getting error %s", err.Error())
+// fetchRates fetches the rates from every provider and swaps in their
+// consensus. It fails when no provider could be fetched. Currencies the
+// providers don't agree on keep their previous rate.
+func (e *ExchangeRates) fetchRates(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	type result struct {
+		name  string
+		rates map[string]float64
+		err   error
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected error code 200 got %d", resp.StatusCode)
+	results := make(chan result, len(e.providers))
+	for _, p := range e.providers {
+		go func(p Provider) {
+			rates, err := p.Fetch(ctx)
+			results <- result{name: p.Name(), rates: rates, err: err}
+		}(p)
 	}
 
-	defer func(body io.ReadCloser, e *ExchangeRates) {
-		if body != nil {
-			err := body.Close()
-			if err != nil {
-				e.log.Error("error closing response body")
-			}
+	fetched := map[string]map[string]float64{}
+	failed := map[string]string{}
+	for range e.providers {
+		r := <-results
+		if r.err != nil {
+			failed[r.name] = r.err.Error()
+			e.log.Warnf("unable to fetch rates from %s: %s", r.name, r.err)
+			e.metric("rates.provider_errors " + r.name)
+			continue
 		}
-	}(resp.Body, e)
+		fetched[r.name] = r.rates
+	}
+	if len(fetched) == 0 {
+		return fmt.Errorf("no rate provider available: %v", failed)
+	}
 
-	// rest of your code
+	rates, report := Consensus(fetched, e.maxDeviation)
+	report.Failed = failed
+	e.alert(report)
 
-	cubes := &Cubes{}
-	err = xml.NewDecoder(resp.Body).Decode(&cubes)
-	if err != nil {
-		return err
+	source := SourceConsensus
+	if len(e.providers) == 1 {
+		source = e.providers[0].Name()
 	}
-	for _, c := range cubes.CubeData {
-		r, err := strconv.ParseFloat(c.Rate, 64)
-		if err != nil {
-			return err
+
+	e.mutex.Lock()
+	// keep the last known rate of currencies without consensus
+	for _, code := range report.NoConsensus {
+		if v, ok := e.rates[code]; ok {
+			rates[code] = v
+		}
+	}
+	// the snapshot gets its own map, the simulation changes e.rates in place
+	snap := &Snapshot{Source: source, FetchedAt: time.Now(), Rates: make(map[string]float64, len(rates))}
+	for k, v := range rates {
+		snap.Rates[k] = v
+	}
+	e.rates = rates
+	e.updatedAt = snap.FetchedAt
+	e.fetchedAt = snap.FetchedAt
+	e.refreshedAt = snap.FetchedAt
+	e.stale = false
+	e.source = source
+	e.sequence++
+	e.consensus = report
+	e.mutex.Unlock()
+
+	if e.snapshotPath != "" {
+		// a failed snapshot only matters on the next cold start
+		if err := SaveSnapshot(e.snapshotPath, snap); err != nil {
+			e.log.Errorf("unable to save rate snapshot: %s", err)
 		}
-		e.rates[c.Currency] = r
 	}
-	e.rates["EUR"] = 1
 	return nil
+}
+
+// alert logs and counts the providers which disagreed with the others.
+func (e *ExchangeRates) alert(report *ConsensusReport) {
+	for code, rejected := range report.Rejected {
+		for name, rate := range rejected {
+			e.log.WithFields(logrus.Fields{
+				"alert":    "rate_disagreement",
+				"currency": code,
+				"provider": name,
+				"rate":     rate,
+				"agreed":   report.Agreed[code],
+			}).Warn("rejected rate deviating from the other providers")
+			e.metric("rates.rejected " + name)
+		}
+	}
+	for _, code := range report.NoConsensus {
+		e.log.WithFields(logrus.Fields{
+			"alert":    "rate_no_consensus",
+			"currency": code,
+		}).Error("providers don't agree on the rate, keeping the last rate")
+		e.metric("rates.no_consensus " + code)
+	}
+	if n := report.Disagreements(); n > 0 {
+		e.metric("rates.disagreements")
+	}
+	if e.metrics != nil {
+		e.metrics.Set("rates.providers_available", float64(len(e.providers)-len(report.Failed)))
+	}
+}
+
+func (e *ExchangeRates) metric(name string) {
+	if e.metrics != nil {
+		e.metrics.Inc(name)
+	}
+}
 
+// LastConsensus returns the report of the last fetch from the providers, nil
+// before the first successful fetch.
+func (e *ExchangeRates) LastConsensus() *ConsensusReport {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.consensus
+}
+
+// Poll fetches the rates from the providers every interval until the context
+// is done.
+func (e *ExchangeRates) Poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.fetchRates(ctx); err != nil {
+				e.log.Errorf("unable to poll rates: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 type Cubes struct {
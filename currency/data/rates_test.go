@@ -2,6 +2,7 @@ package data
 
 import (
 	"testing"
+	"time"
 
 	config "github.com/samims/ecommerceGO/currency/configs"
 	"github.com/sirupsen/logrus"
@@ -9,11 +10,25 @@ import (
 
 func TestNewRates(t *testing.T) {
 	cfg := config.NewViperConfig()
-	tr, err := NewRates(logrus.New(), cfg)
+	tr, err := NewRates(logrus.New(), cfg, nil)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	tr.log.Infoln("Rates %#v", tr.rates)
+	tr.log.Infof("Rates %#v", tr.rates)
+}
+
+func TestSimulationDoesntRefreshRates(t *testing.T) {
+	r := newTestRates()
+	refreshed := time.Now().Add(-time.Hour)
+	r.refreshedAt = refreshed
+
+	r.simulate()
+	if !r.LastRefreshed().Equal(refreshed) {
+		t.Errorf("expected the simulation to keep the refresh time %s, got %s", refreshed, r.LastRefreshed())
+	}
+	if !r.LastUpdated().After(refreshed) {
+		t.Error("expected the simulation to update the rates")
+	}
 }
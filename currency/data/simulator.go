@@ -0,0 +1,203 @@
+package data
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baseCurrency is the currency all rates are quoted against, it is never simulated.
+const baseCurrency = "EUR"
+
+// Simulation models, chosen by name in the config
+const (
+	ModelRandomWalk = "random_walk"
+	ModelGBM        = "gbm"
+	ModelReplay     = "replay"
+)
+
+// Model moves the rates one tick forward. Models are deterministic for a
+// given seed as long as they are given the same rates in the same order.
+type Model interface {
+	// Next returns the rate of currency after the current rate.
+	Next(currency string, rate float64) float64
+	// Tick is called once before the rates of a tick are moved.
+	Tick()
+}
+
+// Simulator makes the rates fluctuate between fetches from the rate source,
+// moving every rate with its model once per interval.
+type Simulator struct {
+	model    Model
+	interval time.Duration
+}
+
+// NewSimulator creates a Simulator ticking every interval.
+func NewSimulator(model Model, interval time.Duration) *Simulator {
+	return &Simulator{
+		model:    model,
+		interval: interval,
+	}
+}
+
+// Interval returns the time between ticks.
+func (s *Simulator) Interval() time.Duration {
+	return s.interval
+}
+
+// Step moves the rates one tick forward in place. Currencies are moved in
+// sorted order so that a seeded model gives the same rates on every run.
+func (s *Simulator) Step(rates map[string]float64) {
+	codes := make([]string, 0, len(rates))
+	for k := range rates {
+		if k != baseCurrency {
+			codes = append(codes, k)
+		}
+	}
+	sort.Strings(codes)
+
+	s.model.Tick()
+	for _, c := range codes {
+		rates[c] = s.model.Next(c, rates[c])
+	}
+}
+
+// RandomWalk moves every rate by a random percentage of up to MaxStep per
+// tick. With a Bound the rates stay within Bound percent of the rate they
+// were fetched at: a rate other than the one the model returned last came
+// from the rate source, and the bound is anchored to it from then on.
+type RandomWalk struct {
+	rnd     *rand.Rand
+	maxStep float64
+	bound   float64
+	initial map[string]float64
+	last    map[string]float64
+}
+
+// NewRandomWalk creates a RandomWalk, a bound of 0 leaves the rates unbounded.
+func NewRandomWalk(seed int64, maxStep, bound float64) *RandomWalk {
+	return &RandomWalk{
+		rnd:     rand.New(rand.NewSource(seed)),
+		maxStep: maxStep,
+		bound:   bound,
+		initial: map[string]float64{},
+		last:    map[string]float64{},
+	}
+}
+
+func (w *RandomWalk) Tick() {}
+
+func (w *RandomWalk) Next(currency string, rate float64) float64 {
+	if last, ok := w.last[currency]; !ok || last != rate {
+		w.initial[currency] = rate
+	}
+
+	change := (w.rnd.Float64()*2 - 1) * w.maxStep
+	next := rate * (100 + change) / 100
+
+	if w.bound > 0 {
+		lo := w.initial[currency] * (100 - w.bound) / 100
+		hi := w.initial[currency] * (100 + w.bound) / 100
+		next = math.Max(lo, math.Min(hi, next))
+	}
+	w.last[currency] = next
+	return next
+}
+
+// GBM moves the rates with geometric Brownian motion, Drift and Volatility
+// are per tick.
+type GBM struct {
+	rnd        *rand.Rand
+	drift      float64
+	volatility float64
+}
+
+// NewGBM creates a GBM model.
+func NewGBM(seed int64, drift, volatility float64) *GBM {
+	return &GBM{
+		rnd:        rand.New(rand.NewSource(seed)),
+		drift:      drift,
+		volatility: volatility,
+	}
+}
+
+func (g *GBM) Tick() {}
+
+func (g *GBM) Next(_ string, rate float64) float64 {
+	z := g.rnd.NormFloat64()
+	return rate * math.Exp(g.drift-g.volatility*g.volatility/2+g.volatility*z)
+}
+
+// Replay plays back recorded rates, one row per tick, and starts over after
+// the last row. Currencies which aren't recorded keep their rate.
+type Replay struct {
+	rows []map[string]float64
+	row  int
+}
+
+// NewReplay creates a Replay from the rows read from a CSV file.
+func NewReplay(rows []map[string]float64) *Replay {
+	return &Replay{rows: rows, row: -1}
+}
+
+// Tick moves to the next recorded row.
+func (r *Replay) Tick() {
+	r.row = (r.row + 1) % len(r.rows)
+}
+
+func (r *Replay) Next(currency string, rate float64) float64 {
+	if v, ok := r.rows[r.row][currency]; ok {
+		return v
+	}
+	return rate
+}
+
+// ReadReplay reads recorded rates from CSV. The header names the currencies,
+// e.g. "time,USD,JPY", a "time" column is ignored. Every following row is the
+// rates of one tick, empty cells keep the previous rate.
+func ReadReplay(r io.Reader) ([]map[string]float64, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read replay: %s", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("replay needs a header and at least one row")
+	}
+
+	header := records[0]
+	rows := make([]map[string]float64, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		row := map[string]float64{}
+		for j, cell := range rec {
+			code := strings.ToUpper(strings.TrimSpace(header[j]))
+			if code == "TIME" || strings.TrimSpace(cell) == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate %q for %s on line %d: %s", cell, code, i+2, err)
+			}
+			row[code] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ReadReplayFile reads recorded rates from the CSV file at path.
+func ReadReplayFile(path string) ([]map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open replay %s: %s", path, err)
+	}
+	defer f.Close()
+
+	return ReadReplay(f)
+}
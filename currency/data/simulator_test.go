@@ -0,0 +1,101 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// simulate runs the simulator for ticks and returns the USD rate after every tick
+func simulate(t *testing.T, s *Simulator, ticks int) []string {
+	t.Helper()
+	rates := map[string]float64{"EUR": 1, "USD": 1.1, "JPY": 140}
+	seq := []string{}
+	for i := 0; i < ticks; i++ {
+		s.Step(rates)
+		if rates["EUR"] != 1 {
+			t.Fatalf("tick %d: the base currency must not move, got %f", i, rates["EUR"])
+		}
+		seq = append(seq, fmt.Sprintf("%.6f", rates["USD"]))
+	}
+	return seq
+}
+
+func TestRandomWalkIsDeterministic(t *testing.T) {
+	got := simulate(t, NewSimulator(NewRandomWalk(42, 10, 0), 0), 5)
+	again := simulate(t, NewSimulator(NewRandomWalk(42, 10, 0), 0), 5)
+	if strings.Join(got, ",") != strings.Join(again, ",") {
+		t.Fatalf("expected the same rates for the same seed, got %v and %v", got, again)
+	}
+
+	other := simulate(t, NewSimulator(NewRandomWalk(43, 10, 0), 0), 5)
+	if strings.Join(got, ",") == strings.Join(other, ",") {
+		t.Fatalf("expected different rates for a different seed, got %v", other)
+	}
+}
+
+func TestRandomWalkSequence(t *testing.T) {
+	want := []string{"1.070466", "1.114522", "1.075085", "1.058172", "1.075269"}
+	got := simulate(t, NewSimulator(NewRandomWalk(7, 5, 5), 0), 5)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+}
+
+func TestRandomWalkStaysInBounds(t *testing.T) {
+	for _, r := range simulate(t, NewSimulator(NewRandomWalk(1, 10, 2), 0), 100) {
+		var v float64
+		fmt.Sscanf(r, "%f", &v)
+		if v < 1.1*0.98-1e-9 || v > 1.1*1.02+1e-9 {
+			t.Fatalf("rate %f left the 2%% bound around 1.1", v)
+		}
+	}
+}
+
+func TestRandomWalkBoundFollowsFetchedRates(t *testing.T) {
+	w := NewRandomWalk(1, 10, 2)
+	rate := 1.1
+	for i := 0; i < 50; i++ {
+		rate = w.Next("USD", rate)
+	}
+
+	// a fetched rate far from the first one moves the bound along
+	rate = 2.0
+	for i := 0; i < 50; i++ {
+		rate = w.Next("USD", rate)
+		if rate < 2.0*0.98-1e-9 || rate > 2.0*1.02+1e-9 {
+			t.Fatalf("rate %f left the 2%% bound around the fetched 2.0", rate)
+		}
+	}
+}
+
+func TestGBMSequence(t *testing.T) {
+	got := simulate(t, NewSimulator(NewGBM(42, 0, 0.01), 0), 3)
+	again := simulate(t, NewSimulator(NewGBM(42, 0, 0.01), 0), 3)
+	if strings.Join(got, ",") != strings.Join(again, ",") {
+		t.Fatalf("expected the same rates for the same seed, got %v and %v", got, again)
+	}
+
+	// without volatility the rates only drift
+	flat := simulate(t, NewSimulator(NewGBM(42, 0.1, 0), 0), 1)
+	if flat[0] != "1.215688" {
+		t.Fatalf("expected 1.1*e^0.1 = 1.215688 got %s", flat[0])
+	}
+}
+
+func TestReplay(t *testing.T) {
+	rows, err := ReadReplay(strings.NewReader("time,USD,JPY\n1,1.2,141\n2,1.3,\n3,1.25,139\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSimulator(NewReplay(rows), 0)
+	rates := map[string]float64{"EUR": 1, "USD": 1.1, "JPY": 140}
+	want := []string{"1.2/141", "1.3/141", "1.25/139", "1.2/141"}
+	for i, w := range want {
+		s.Step(rates)
+		if got := fmt.Sprintf("%g/%g", rates["USD"], rates["JPY"]); got != w {
+			t.Fatalf("tick %d: expected %s got %s", i, w, got)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Snapshot is a set of rates fetched from the live source, saved to disk so
+// that the service can start when the source is unreachable.
+type Snapshot struct {
+	Source    string             `json:"source"`
+	FetchedAt time.Time          `json:"fetched_at"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// SaveSnapshot writes the snapshot to path atomically.
+func SaveSnapshot(path string, s *Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// LoadSnapshot reads the snapshot saved at path.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rate snapshot %s: %s", path, err)
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("unable to parse rate snapshot %s: %s", path, err)
+	}
+	if len(s.Rates) == 0 {
+		return nil, fmt.Errorf("rate snapshot %s has no rates", path)
+	}
+	return s, nil
+}
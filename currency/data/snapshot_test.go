@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/sirupsen/logrus"
+)
+
+const testECBRates = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<Cube>
+		<Cube time="2023-04-03">
+			<Cube currency="USD" rate="1.0875"/>
+			<Cube currency="JPY" rate="144.08"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+// testEnv is a config.Env backed by a map
+type testEnv map[string]interface{}
+
+func (e testEnv) Get(key string) interface{}    { return e[key] }
+func (e testEnv) GetString(key string) string   { s, _ := e[key].(string); return s }
+func (e testEnv) GetInt(key string) int         { i, _ := e[key].(int); return i }
+func (e testEnv) GetBool(key string) bool       { b, _ := e[key].(bool); return b }
+func (e testEnv) GetFloat64(key string) float64 { f, _ := e[key].(float64); return f }
+func (e testEnv) GetDuration(key string) time.Duration {
+	d, _ := e[key].(time.Duration)
+	return d
+}
+func (e testEnv) GetStringSlice(key string) []string { s, _ := e[key].([]string); return s }
+
+func TestWarmStartFromSnapshot(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(testECBRates))
+	}))
+	defer srv.Close()
+
+	cfg := testEnv{
+		constants.EnvRateUri:          srv.URL,
+		constants.EnvRateSnapshotFile: filepath.Join(t.TempDir(), "rates.json"),
+	}
+
+	// a successful fetch saves the snapshot
+	if _, err := NewRates(logrus.New(), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	up.Store(false)
+	r, err := NewRates(logrus.New(), cfg, nil)
+	if err != nil {
+		t.Fatalf("expected to start from the snapshot, got %s", err)
+	}
+	if stale, fetchedAt := r.Stale(); !stale || fetchedAt.IsZero() {
+		t.Fatalf("expected stale rates with a fetch time, got %v %s", stale, fetchedAt)
+	}
+	info, err := r.GetRateInfo("EUR", "USD")
+	if err != nil || info.Rate != 1.0875 || info.Source != SourceSnapshot {
+		t.Fatalf("expected snapshot rate 1.0875, got %+v %v", info, err)
+	}
+
+	up.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.RetryLive(ctx, 10*time.Millisecond)
+	if stale, _ := r.Stale(); stale {
+		t.Fatal("expected live rates after the source came back")
+	}
+	live, _ := r.GetRateInfo("EUR", "USD")
+	if live.Source != SourceECB || live.Sequence <= info.Sequence {
+		t.Fatalf("expected a newer live rate than %+v, got %+v", info, live)
+	}
+}
+
+func TestColdStartWithoutSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := testEnv{
+		constants.EnvRateUri:          srv.URL,
+		constants.EnvRateSnapshotFile: filepath.Join(t.TempDir(), "rates.json"),
+	}
+	if _, err := NewRates(logrus.New(), cfg, nil); err == nil {
+		t.Fatal("expected an error without live rates or a snapshot")
+	}
+}
@@ -0,0 +1,365 @@
+// Package gateway serves the currency service as REST/JSON over HTTP for
+// clients which can't speak gRPC. It calls the gRPC handlers directly, so
+// both front doors behave the same.
+package gateway
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/samims/ecommerceGO/currency/handlers"
+	"github.com/samims/ecommerceGO/currency/interceptors"
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	unmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+// Gateway maps REST endpoints to the currency and admin gRPC handlers.
+type Gateway struct {
+	log      *logrus.Logger
+	currency *handlers.CurrencyService
+	admin    *handlers.AdminService
+	unary    grpc.UnaryServerInterceptor
+	stream   grpc.StreamServerInterceptor
+}
+
+// NewGateway creates a Gateway. Every endpoint except the OpenAPI document
+// calls its handler through the interceptors, which are those of the gRPC
+// server so that both front doors authenticate, log and count calls the
+// same way. Nil interceptors call the handlers directly.
+func NewGateway(l *logrus.Logger, cs *handlers.CurrencyService, as *handlers.AdminService, unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) *Gateway {
+	if unary == nil {
+		unary = interceptors.ChainUnary()
+	}
+	if stream == nil {
+		stream = interceptors.ChainStream()
+	}
+	return &Gateway{
+		log:      l,
+		currency: cs,
+		admin:    as,
+		unary:    unary,
+		stream:   stream,
+	}
+}
+
+// Router returns the router with all the endpoints of the gateway registered.
+func (g *Gateway) Router() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(g.withMetadata)
+
+	r.HandleFunc("/openapi.yaml", serveOpenAPI).Methods(http.MethodGet)
+
+	api := r.NewRoute().Subrouter()
+	api.Use(g.withRatesHeaders)
+	api.HandleFunc("/rates/stream", g.StreamRates).Methods(http.MethodGet)
+	api.HandleFunc("/rates/{base:[A-Za-z]{3}}/{dest:[A-Za-z]{3}}", g.GetRate).Methods(http.MethodGet)
+	api.HandleFunc("/candles/{base:[A-Za-z]{3}}/{dest:[A-Za-z]{3}}", g.GetCandles).Methods(http.MethodGet)
+	api.HandleFunc("/quotes", g.CreateQuote).Methods(http.MethodPost)
+	api.HandleFunc("/quotes/{id}", g.GetQuote).Methods(http.MethodGet)
+	api.HandleFunc("/quotes/{id}/redeem", g.RedeemQuote).Methods(http.MethodPost)
+
+	// the admin handlers check the admin token themselves, the auth
+	// interceptor skips them
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/overrides", g.ListOverrides).Methods(http.MethodGet)
+	admin.HandleFunc("/overrides/{base:[A-Za-z]{3}}/{dest:[A-Za-z]{3}}", g.SetRateOverride).Methods(http.MethodPut)
+	admin.HandleFunc("/overrides/{base:[A-Za-z]{3}}/{dest:[A-Za-z]{3}}", g.ClearRateOverride).Methods(http.MethodDelete)
+
+	return r
+}
+
+func serveOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPI)
+}
+
+// withMetadata copies the headers the gRPC handlers and interceptors read
+// from metadata into the request context, along with the client address as
+// the peer.
+func (g *Gateway) withMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md := metadata.MD{}
+		for _, h := range []string{"authorization", "x-admin-user", interceptors.ClientIDHeader} {
+			if v := r.Header.Get(h); v != "" {
+				md.Set(h, v)
+			}
+		}
+		ctx := metadata.NewIncomingContext(r.Context(), md)
+		if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// call runs the handler as the gRPC method through the unary interceptors.
+// Requests are parsed in the handler, so that they are only looked at once
+// the caller is authenticated.
+func (g *Gateway) call(r *http.Request, method string, handler func(ctx context.Context) (proto.Message, error)) (proto.Message, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	resp, err := g.unary(r.Context(), nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return handler(ctx)
+	})
+	m, _ := resp.(proto.Message)
+	return m, err
+}
+
+// withRatesHeaders tells clients how fresh the rates are, the same way the
+// gRPC service does in its response metadata.
+func (g *Gateway) withRatesHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range g.currency.RatesMetadata() {
+			w.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetRate handles GET /rates/{base}/{dest}?side=SELL
+func (g *Gateway) GetRate(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/Currency/GetRate", func(ctx context.Context) (proto.Message, error) {
+		rr, err := rateRequest(mux.Vars(r)["base"], mux.Vars(r)["dest"], r.URL.Query().Get("side"))
+		if err != nil {
+			return nil, err
+		}
+		return g.currency.GetRate(ctx, rr)
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// candleIntervals maps the interval query parameter to the proto intervals
+var candleIntervals = map[string]pb.CandleInterval{
+	"":   pb.CandleInterval_ONE_MINUTE,
+	"1m": pb.CandleInterval_ONE_MINUTE,
+	"1h": pb.CandleInterval_ONE_HOUR,
+	"1d": pb.CandleInterval_ONE_DAY,
+}
+
+// GetCandles handles GET /candles/{base}/{dest}?interval=1h&from=2023-04-01T00:00:00Z&to=...
+func (g *Gateway) GetCandles(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/Currency/GetCandles", func(ctx context.Context) (proto.Message, error) {
+		req, err := candlesRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		return g.currency.GetCandles(ctx, req)
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// candlesRequest builds a GetCandlesRequest from the path and query.
+func candlesRequest(r *http.Request) (*pb.GetCandlesRequest, error) {
+	rr, err := rateRequest(mux.Vars(r)["base"], mux.Vars(r)["dest"], "")
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	interval, ok := candleIntervals[q.Get("interval")]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported interval %s, use 1m, 1h or 1d", q.Get("interval"))
+	}
+	req := &pb.GetCandlesRequest{Base: rr.Base, Destination: rr.Destination, Interval: interval}
+	for name, ts := range map[string]**timestamppb.Timestamp{"from": &req.From, "to": &req.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q, expected RFC 3339", name, v)
+			}
+			*ts = timestamppb.New(t)
+		}
+	}
+	return req, nil
+}
+
+// CreateQuote handles POST /quotes with a CreateQuoteRequest as JSON body.
+func (g *Gateway) CreateQuote(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/Currency/CreateQuote", func(ctx context.Context) (proto.Message, error) {
+		req := &pb.CreateQuoteRequest{}
+		if err := readBody(r, req); err != nil {
+			return nil, err
+		}
+		return g.currency.CreateQuote(ctx, req)
+	})
+	g.respond(w, http.StatusCreated, resp, err)
+}
+
+// GetQuote handles GET /quotes/{id}
+func (g *Gateway) GetQuote(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/Currency/GetQuote", func(ctx context.Context) (proto.Message, error) {
+		return g.currency.GetQuote(ctx, &pb.GetQuoteRequest{Id: mux.Vars(r)["id"]})
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// RedeemQuote handles POST /quotes/{id}/redeem
+func (g *Gateway) RedeemQuote(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/Currency/RedeemQuote", func(ctx context.Context) (proto.Message, error) {
+		return g.currency.RedeemQuote(ctx, &pb.RedeemQuoteRequest{Id: mux.Vars(r)["id"]})
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// ListOverrides handles GET /admin/overrides
+func (g *Gateway) ListOverrides(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/CurrencyAdmin/ListOverrides", func(ctx context.Context) (proto.Message, error) {
+		return g.admin.ListOverrides(ctx, &pb.ListOverridesRequest{})
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// SetRateOverride handles PUT /admin/overrides/{base}/{dest} with a
+// SetRateOverrideRequest as JSON body, the pair is taken from the path.
+func (g *Gateway) SetRateOverride(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/CurrencyAdmin/SetRateOverride", func(ctx context.Context) (proto.Message, error) {
+		req := &pb.SetRateOverrideRequest{}
+		if err := readBody(r, req); err != nil {
+			return nil, err
+		}
+		rr, err := rateRequest(mux.Vars(r)["base"], mux.Vars(r)["dest"], "")
+		if err != nil {
+			return nil, err
+		}
+		req.Base, req.Destination = rr.Base, rr.Destination
+		return g.admin.SetRateOverride(ctx, req)
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// ClearRateOverride handles DELETE /admin/overrides/{base}/{dest}
+func (g *Gateway) ClearRateOverride(w http.ResponseWriter, r *http.Request) {
+	resp, err := g.call(r, "/CurrencyAdmin/ClearRateOverride", func(ctx context.Context) (proto.Message, error) {
+		rr, err := rateRequest(mux.Vars(r)["base"], mux.Vars(r)["dest"], "")
+		if err != nil {
+			return nil, err
+		}
+		return g.admin.ClearRateOverride(ctx, &pb.ClearRateOverrideRequest{
+			Base:        rr.Base,
+			Destination: rr.Destination,
+		})
+	})
+	g.respond(w, http.StatusOK, resp, err)
+}
+
+// rateRequest builds a RateRequest from currency codes and a side name.
+func rateRequest(base, dest, side string) (*pb.RateRequest, error) {
+	b, ok := pb.Currencies_value[strings.ToUpper(base)]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported currency %s", base)
+	}
+	d, ok := pb.Currencies_value[strings.ToUpper(dest)]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported currency %s", dest)
+	}
+	sd := int32(pb.Side_MID)
+	if side != "" {
+		if sd, ok = pb.Side_value[strings.ToUpper(side)]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported side %s", side)
+		}
+	}
+	return &pb.RateRequest{
+		Base:        pb.Currencies(b),
+		Destination: pb.Currencies(d),
+		Side:        pb.Side(sd),
+	}, nil
+}
+
+func readBody(r *http.Request, m proto.Message) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "unable to read body: %s", err)
+	}
+	if err := unmarshaler.Unmarshal(body, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid body: %s", err)
+	}
+	return nil
+}
+
+// respond writes the message as JSON, or the error when the call failed.
+func (g *Gateway) respond(w http.ResponseWriter, code int, m proto.Message, err error) {
+	if err != nil {
+		g.respondWithError(w, err)
+		return
+	}
+	body, err := marshaler.Marshal(m)
+	if err != nil {
+		g.respondWithError(w, status.Errorf(codes.Internal, "unable to serialize response: %s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// errorBody is the JSON body of failed requests
+type errorBody struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+func (g *Gateway) respondWithError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := httpStatus(st.Code())
+	if code >= http.StatusInternalServerError {
+		g.log.Errorf("gateway request failed: %s", err)
+	}
+	writeJSON(w, code, errorBody{Message: st.Message(), Code: st.Code().String()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// httpStatus maps gRPC status codes to HTTP status codes.
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,278 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/samims/ecommerceGO/currency/data"
+	"github.com/samims/ecommerceGO/currency/handlers"
+	"github.com/samims/ecommerceGO/currency/interceptors"
+	"github.com/samims/ecommerceGO/currency/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// testEnv is a config.Env holding strings only
+type testEnv map[string]string
+
+func (e testEnv) Get(key string) interface{}           { return e[key] }
+func (e testEnv) GetString(key string) string          { return e[key] }
+func (e testEnv) GetInt(key string) int                { return 0 }
+func (e testEnv) GetBool(key string) bool              { return e[key] == "true" }
+func (e testEnv) GetFloat64(key string) float64        { return 0 }
+func (e testEnv) GetDuration(key string) time.Duration { return 0 }
+func (e testEnv) GetStringSlice(key string) []string   { return strings.Split(e[key], ",") }
+
+// newTestGateway serves the gateway with rates read from a file and a PTS
+// custom currency, the interceptors are those of the gRPC server with auth
+// and metrics enabled.
+func newTestGateway(t *testing.T) (*httptest.Server, *metrics.Registry) {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(`{"EUR": 1, "USD": 1.1, "GBP": 0.9}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := data.NewRates(l, testEnv{constants.EnvRateProviders: "test=file://" + path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := data.NewCustomCurrencies([]*data.CustomCurrency{{Code: "PTS", Reference: "USD", Rate: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates.UseCustomCurrencies(custom)
+	pricing := data.NewStaticPricing(l, &data.PricingPolicy{})
+	quotes := data.NewQuotes(l, rates, pricing, data.NewMemoryQuoteStore(0), time.Minute, time.Hour)
+	overrides, err := data.NewOverrides(l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cs := handlers.NewCurrency(ctx, l, rates, quotes, pricing, nil)
+	as := handlers.NewAdmin(l, overrides, "admin-secret")
+
+	m := metrics.NewRegistry()
+	auth := interceptors.NewBearerTokens([]string{"web:secret"})
+	skip := interceptors.SkipServices("CurrencyAdmin")
+	unary := interceptors.ChainUnary(
+		interceptors.UnaryClientID(),
+		interceptors.UnaryMetrics(m),
+		interceptors.UnaryRecovery(l),
+		interceptors.UnaryAuth(l, auth, skip),
+	)
+	stream := interceptors.ChainStream(
+		interceptors.StreamClientID(),
+		interceptors.StreamMetrics(m),
+		interceptors.StreamRecovery(l),
+		interceptors.StreamAuth(l, auth, skip),
+	)
+
+	s := httptest.NewServer(NewGateway(l, cs, as, unary, stream).Router())
+	t.Cleanup(s.Close)
+	return s, m
+}
+
+func get(t *testing.T, url, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGetRate(t *testing.T) {
+	s, m := newTestGateway(t)
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		code  int
+	}{
+		{"without a token", "/rates/EUR/USD", "", http.StatusUnauthorized},
+		{"with a wrong token", "/rates/EUR/USD", "wrong", http.StatusUnauthorized},
+		{"lower case codes", "/rates/eur/usd", "secret", http.StatusOK},
+		{"sell side", "/rates/EUR/USD?side=SELL", "secret", http.StatusOK},
+		{"unknown side", "/rates/EUR/USD?side=UP", "secret", http.StatusBadRequest},
+		{"unknown currency", "/rates/EUR/XXX", "secret", http.StatusBadRequest},
+		{"same currencies", "/rates/EUR/EUR", "secret", http.StatusBadRequest},
+		{"custom currency", "/rates/EUR/PTS", "secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(t, s.URL+tt.path, tt.token)
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.code {
+				t.Fatalf("expected %d got %d", tt.code, resp.StatusCode)
+			}
+		})
+	}
+
+	resp := get(t, s.URL+"/rates/EUR/USD", "secret")
+	defer resp.Body.Close()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["base"] != "EUR" || body["destination"] != "USD" || body["mid_rate"] != 1.1 {
+		t.Errorf("unexpected rate %v", body)
+	}
+	if resp.Header.Get(handlers.RatesStaleHeader) != "false" {
+		t.Errorf("expected fresh rates got %q", resp.Header.Get(handlers.RatesStaleHeader))
+	}
+
+	// the metrics interceptor counts gateway calls, rejected ones included
+	if n := m.Counter("grpc.calls /Currency/GetRate"); n != int64(len(tests)+1) {
+		t.Errorf("expected %d calls got %d", len(tests)+1, n)
+	}
+	if n := m.Counter("grpc.codes /Currency/GetRate Unauthenticated"); n != 2 {
+		t.Errorf("expected 2 unauthenticated calls got %d", n)
+	}
+}
+
+func TestOpenAPIIsPublic(t *testing.T) {
+	s, _ := newTestGateway(t)
+
+	resp := get(t, s.URL+"/openapi.yaml", "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/yaml" {
+		t.Fatalf("expected the OpenAPI document got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestAdminChecksAdminToken(t *testing.T) {
+	s, _ := newTestGateway(t)
+
+	// the bearer token of the currency service isn't an admin token
+	resp := get(t, s.URL+"/admin/overrides", "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the admin endpoint to be refused got %d", resp.StatusCode)
+	}
+
+	resp = get(t, s.URL+"/admin/overrides", "admin-secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the overrides got %d", resp.StatusCode)
+	}
+}
+
+func TestGetCandlesWithoutCandles(t *testing.T) {
+	s, _ := newTestGateway(t)
+
+	resp := get(t, s.URL+"/candles/EUR/USD?interval=1w", "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unsupported interval to be refused got %d", resp.StatusCode)
+	}
+
+	resp = get(t, s.URL+"/candles/EUR/USD", "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected Unimplemented without candles got %d", resp.StatusCode)
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	s, _ := newTestGateway(t)
+
+	req, _ := http.NewRequest(http.MethodPost, s.URL+"/quotes", strings.NewReader(`{"base": "EUR", "destination": "USD"}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the quote to be created got %d", resp.StatusCode)
+	}
+	quote := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&quote); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = get(t, s.URL+"/quotes/"+quote["id"].(string), "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the quote got %d", resp.StatusCode)
+	}
+	resp = get(t, s.URL+"/quotes/unknown", "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected NotFound got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamRates(t *testing.T) {
+	s, m := newTestGateway(t)
+
+	resp := get(t, s.URL+"/rates/stream?pair=EUR/USD", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the stream to require a token got %d", resp.StatusCode)
+	}
+	resp = get(t, s.URL+"/rates/stream?pair=EURUSD", "secret")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid pair to be refused got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/rates/stream?pair=EUR/USD&pair=EUR/GBP", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream got %s", resp.Header.Get("Content-Type"))
+	}
+
+	// the current rate of both pairs is sent straight away
+	events := []string{}
+	r := bufio.NewReader(resp.Body)
+	for len(events) < 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimSpace(strings.TrimPrefix(line, "event: ")))
+			continue
+		}
+		if strings.HasPrefix(line, "data: ") && !strings.Contains(line, `"base":"EUR"`) {
+			t.Errorf("unexpected event data %s", line)
+		}
+	}
+	if events[0] != "rate" || events[1] != "rate" {
+		t.Errorf("expected two rate events got %v", events)
+	}
+
+	if n := m.Counter("grpc.codes /Currency/SubscribeRates Unauthenticated"); n != 1 {
+		t.Errorf("expected the unauthenticated stream to be counted got %d", n)
+	}
+}
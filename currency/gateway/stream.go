@@ -0,0 +1,155 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// keepAliveInterval is how often a comment is written to idle event streams
+// so that proxies don't close them.
+const keepAliveInterval = 30 * time.Second
+
+// eventStream is a handlers.RateSubscriber writing rate updates to a
+// text/event-stream response.
+type eventStream struct {
+	mutex   *sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// Send writes the update as a "rate" event, or as an "error" event when it
+// carries an error.
+func (e *eventStream) Send(m *pb.StreamingRateResponse) error {
+	event, msg := "rate", proto.Message(m.GetRateResponse())
+	if m.GetError() != nil {
+		event, msg = "error", m.GetError()
+	}
+
+	body, err := marshaler.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return e.write(fmt.Sprintf("event: %s\ndata: %s\n\n", event, body))
+}
+
+func (e *eventStream) write(s string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if _, err := fmt.Fprint(e.w, s); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+// httpStream is the grpc.ServerStream the stream interceptors see for an
+// event stream, they only use its context.
+type httpStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (h *httpStream) Context() context.Context {
+	return h.ctx
+}
+
+// StreamRates handles GET /rates/stream?pair=EUR/USD&pair=EUR/GBP&side=SELL
+// as server-sent events, the same way SubscribeRates streams over gRPC. The
+// current rate of every pair is sent straight away and then on every update.
+func (g *Gateway) StreamRates(w http.ResponseWriter, r *http.Request) {
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates", IsClientStream: true, IsServerStream: true}
+	err := g.stream(nil, &httpStream{ctx: r.Context()}, info, func(_ interface{}, ss grpc.ServerStream) error {
+		return g.streamRates(ss.Context(), w, r)
+	})
+	if err != nil {
+		g.respondWithError(w, err)
+	}
+}
+
+// streamRates writes the event stream until the client disconnects. It
+// returns an error only before the response is started.
+func (g *Gateway) streamRates(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return status.Error(codes.Unimplemented, "streaming is not supported")
+	}
+
+	pairs := r.URL.Query()["pair"]
+	if len(pairs) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one pair is required")
+	}
+	reqs := make([]*pb.RateRequest, 0, len(pairs))
+	for _, p := range pairs {
+		base, dest, ok := strings.Cut(p, "/")
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "invalid pair %s, expected BASE/DEST", p)
+		}
+		rr, err := rateRequest(base, dest, r.URL.Query().Get("side"))
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, rr)
+	}
+
+	// resolve the current rates before committing to a streaming response
+	initial := make([]*pb.RateResponse, 0, len(reqs))
+	for _, rr := range reqs {
+		resp, err := g.currency.GetRate(ctx, rr)
+		if err != nil {
+			return err
+		}
+		initial = append(initial, resp)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	es := &eventStream{mutex: &sync.Mutex{}, w: w, flusher: flusher}
+	for _, resp := range initial {
+		err := es.Send(&pb.StreamingRateResponse{
+			Message: &pb.StreamingRateResponse_RateResponse{RateResponse: resp},
+		})
+		if err != nil {
+			return nil
+		}
+	}
+
+	defer g.currency.Unsubscribe(es)
+	for _, rr := range reqs {
+		if err := g.currency.Subscribe(es, rr); err != nil {
+			// the same pair twice, tell the client like the gRPC stream does
+			es.Send(&pb.StreamingRateResponse{
+				Message: &pb.StreamingRateResponse_Error{Error: status.Convert(err).Proto()},
+			})
+		}
+	}
+	g.log.Infof("client %s subscribed to %d rates over the gateway", r.RemoteAddr, len(reqs))
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			g.log.Infof("client %s disconnected from the gateway", r.RemoteAddr)
+			return nil
+		case <-ticker.C:
+			if err := es.write(": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/data"
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	authorizationHeader = "authorization"
+	adminUserHeader     = "x-admin-user"
+)
+
+// AdminService implements the CurrencyAdmin gRPC service used by ops to
+// override rates by hand.
+type AdminService struct {
+	log       *logrus.Logger
+	overrides *data.Overrides
+	token     string
+	pb.UnimplementedCurrencyAdminServer
+}
+
+// NewAdmin creates an AdminService which accepts calls carrying the given
+// bearer token. With an empty token every call is rejected.
+func NewAdmin(l *logrus.Logger, o *data.Overrides, token string) *AdminService {
+	return &AdminService{
+		log:       l,
+		overrides: o,
+		token:     token,
+	}
+}
+
+// SetRateOverride pins the rate of a currency pair.
+func (a *AdminService) SetRateOverride(ctx context.Context, req *pb.SetRateOverrideRequest) (*pb.RateOverride, error) {
+	actor, err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ov := &data.Override{
+		Base:        req.GetBase().String(),
+		Destination: req.GetDestination().String(),
+		Rate:        req.GetRate(),
+		CreatedBy:   actor,
+		Reason:      req.GetReason(),
+	}
+	if req.GetExpiresAt() != nil {
+		ov.ExpiresAt = req.GetExpiresAt().AsTime()
+	}
+
+	prev, err := a.overrides.Set(ov)
+	if err != nil {
+		return nil, overrideError(err)
+	}
+
+	a.audit("set_rate_override", actor, ov, prev)
+	return overrideToProto(ov)
+}
+
+// ClearRateOverride removes the override of a currency pair, the market rate
+// is used again afterwards.
+func (a *AdminService) ClearRateOverride(ctx context.Context, req *pb.ClearRateOverrideRequest) (*pb.RateOverride, error) {
+	actor, err := a.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, err := a.overrides.Clear(req.GetBase().String(), req.GetDestination().String())
+	if err != nil {
+		return nil, overrideError(err)
+	}
+
+	a.audit("clear_rate_override", actor, nil, prev)
+	return overrideToProto(prev)
+}
+
+// ListOverrides returns the overrides which are in effect.
+func (a *AdminService) ListOverrides(ctx context.Context, _ *pb.ListOverridesRequest) (*pb.ListOverridesResponse, error) {
+	if _, err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListOverridesResponse{}
+	for _, ov := range a.overrides.List() {
+		po, err := overrideToProto(ov)
+		if err != nil {
+			// one override of a v2 only currency doesn't hide the others
+			a.log.Warnf("not listing override %s/%s: %s", ov.Base, ov.Destination, err)
+			continue
+		}
+		resp.Overrides = append(resp.Overrides, po)
+	}
+	return resp, nil
+}
+
+// authorize checks the bearer token of the call and returns the name of the
+// caller, taken from the x-admin-user metadata.
+func (a *AdminService) authorize(ctx context.Context) (string, error) {
+	if a.token == "" {
+		return "", status.Error(codes.PermissionDenied, "admin api is disabled")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get(authorizationHeader); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		a.log.Warn("rejected admin call with invalid token")
+		return "", status.Error(codes.Unauthenticated, "invalid admin token")
+	}
+
+	actor := "admin"
+	if v := md.Get(adminUserHeader); len(v) > 0 && v[0] != "" {
+		actor = v[0]
+	}
+	return actor, nil
+}
+
+// audit logs a change of an override together with the previous value.
+func (a *AdminService) audit(action, actor string, current, prev *data.Override) {
+	fields := logrus.Fields{
+		"audit":  true,
+		"action": action,
+		"actor":  actor,
+	}
+	if current != nil {
+		fields["pair"] = current.Base + "/" + current.Destination
+		fields["rate"] = current.Rate
+		fields["reason"] = current.Reason
+		if !current.ExpiresAt.IsZero() {
+			fields["expires_at"] = current.ExpiresAt.Format(time.RFC3339)
+		}
+	}
+	if prev != nil {
+		fields["pair"] = prev.Base + "/" + prev.Destination
+		fields["previous_rate"] = prev.Rate
+		fields["previous_created_by"] = prev.CreatedBy
+	}
+	a.log.WithFields(fields).Info("rate override changed")
+}
+
+// overrideError maps errors from data.Overrides to gRPC status errors.
+func overrideError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrOverrideNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, data.ErrInvalidOverride):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// overrideToProto converts the override, it fails when one of its currencies
+// isn't available in v1.
+func overrideToProto(ov *data.Override) (*pb.RateOverride, error) {
+	base, err := v1Currency(ov.Base)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := v1Currency(ov.Destination)
+	if err != nil {
+		return nil, err
+	}
+	po := &pb.RateOverride{
+		Base:        base,
+		Destination: dest,
+		Rate:        ov.Rate,
+		CreatedAt:   timestamppb.New(ov.CreatedAt),
+		CreatedBy:   ov.CreatedBy,
+		Reason:      ov.Reason,
+	}
+	if !ov.ExpiresAt.IsZero() {
+		po.ExpiresAt = timestamppb.New(ov.ExpiresAt)
+	}
+	return po, nil
+}
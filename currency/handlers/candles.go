@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/data"
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	pbv2 "github.com/samims/ecommerceGO/currency/protos/currency/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// candleIntervals maps the proto intervals to data.CandleIntervals
+var candleIntervals = map[int32]time.Duration{
+	int32(pb.CandleInterval_ONE_MINUTE): time.Minute,
+	int32(pb.CandleInterval_ONE_HOUR):   time.Hour,
+	int32(pb.CandleInterval_ONE_DAY):    24 * time.Hour,
+}
+
+// GetCandles returns the candles of a pair.
+func (c *CurrencyService) GetCandles(_ context.Context, req *pb.GetCandlesRequest) (*pb.GetCandlesResponse, error) {
+	c.log.Info("Handle GetCandles ", " base ", req.GetBase(), " destination ", req.GetDestination(), " interval ", req.GetInterval())
+
+	candles, err := c.candles(req.GetBase().String(), req.GetDestination().String(), int32(req.GetInterval()), req.GetFrom(), req.GetTo())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetCandlesResponse{
+		Base:        req.GetBase(),
+		Destination: req.GetDestination(),
+		Interval:    req.GetInterval(),
+		Candles:     candlesToProto(candles),
+	}, nil
+}
+
+// GetCandles returns the candles of a pair.
+func (c *CurrencyV2Service) GetCandles(_ context.Context, req *pbv2.GetCandlesRequest) (*pbv2.GetCandlesResponse, error) {
+	rr, err := c.validate(&pbv2.RateRequest{Base: req.GetBase(), Destination: req.GetDestination()})
+	if err != nil {
+		return nil, err
+	}
+
+	candles, err := c.v1.candles(rr.Base, rr.Destination, int32(req.GetInterval()), req.GetFrom(), req.GetTo())
+	if err != nil {
+		return nil, err
+	}
+	// the candle messages are the same in both versions
+	resp := &pbv2.GetCandlesResponse{
+		Base:        rr.Base,
+		Destination: rr.Destination,
+		Interval:    req.GetInterval(),
+	}
+	for _, v := range candles {
+		resp.Candles = append(resp.Candles, &pbv2.Candle{
+			Start:   timestamppb.New(v.Start),
+			Open:    v.Open,
+			High:    v.High,
+			Low:     v.Low,
+			Close:   v.Close,
+			Samples: uint32(v.Samples),
+		})
+	}
+	return resp, nil
+}
+
+func (c *CurrencyService) candles(base, dest string, interval int32, from, to *timestamppb.Timestamp) ([]data.Candle, error) {
+	if c.candleStore == nil {
+		return nil, status.Error(codes.Unimplemented, "candles are not recorded")
+	}
+	d, ok := candleIntervals[interval]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown candle interval %d", interval)
+	}
+
+	var f, t time.Time
+	if from != nil {
+		f = from.AsTime()
+	}
+	if to != nil {
+		t = to.AsTime()
+	}
+	if !f.IsZero() && !t.IsZero() && !f.Before(t) {
+		return nil, status.Errorf(codes.InvalidArgument, "from %s must be before to %s", f, t)
+	}
+
+	candles, err := c.candleStore.Get(base, dest, d, f, t)
+	if err != nil {
+		return nil, candleError(err)
+	}
+	return candles, nil
+}
+
+// candleError maps errors from data.Candles to gRPC status errors.
+func candleError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrCandlesNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, data.ErrInvalidInterval):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func candlesToProto(candles []data.Candle) []*pb.Candle {
+	list := make([]*pb.Candle, 0, len(candles))
+	for _, v := range candles {
+		list = append(list, &pb.Candle{
+			Start:   timestamppb.New(v.Start),
+			Open:    v.Open,
+			High:    v.High,
+			Low:     v.Low,
+			Close:   v.Close,
+			Samples: uint32(v.Samples),
+		})
+	}
+	return list
+}
@@ -3,41 +3,66 @@ package handlers
 import (
 	"context"
 	"io"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/samims/ecommerceGO/currency/data"
+	"github.com/samims/ecommerceGO/currency/interceptors"
 	pb "github.com/samims/ecommerceGO/currency/protos/currency"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-type contextKey int
-
 const (
-	contextClientIDKey contextKey = iota
+	// RatesStaleHeader is set to "true" in the response metadata while the
+	// rates come from a snapshot because the live source is unreachable
+	RatesStaleHeader = "x-rates-stale"
+	// RatesAsOfHeader is when the rates were fetched from the live source, in RFC 3339
+	RatesAsOfHeader = "x-rates-as-of"
 )
 
+// RateSubscriber receives rate updates for its subscriptions. Both gRPC
+// streams and the REST gateway's event streams are subscribers.
+type RateSubscriber interface {
+	Send(*pb.StreamingRateResponse) error
+}
+
 // CurrencyService represents a handlers that provides currency conversion rates.
 type CurrencyService struct {
 	log           *logrus.Logger
 	ctx           context.Context
 	rates         *data.ExchangeRates
-	subscriptions map[pb.Currency_SubscribeRatesServer][]*pb.RateRequest
+	quotes        *data.Quotes
+	pricing       *data.Pricing
+	candleStore   *data.Candles
+	subscriptions *subscriptions[RateSubscriber, *pb.RateRequest]
+	// listeners are called after every rate update
+	listenersMutex *sync.Mutex
+	listeners      []func()
 	pb.UnimplementedCurrencyServer
 }
 
-// NewCurrency creates a new instance of the CurrencyService with the given context, logger, and exchange rates.
+// NewCurrency creates a new instance of the CurrencyService with the given context, logger, exchange rates,
+// quotes, pricing policy and candles. Without candles GetCandles is Unimplemented.
 // It initializes the subscriptions and clients maps and starts a goroutine to handle rate updates.
 // It returns a pointer to the *CurrencyService instance.
-func NewCurrency(ctx context.Context, l *logrus.Logger, r *data.ExchangeRates) *CurrencyService {
+func NewCurrency(ctx context.Context, l *logrus.Logger, r *data.ExchangeRates, q *data.Quotes, p *data.Pricing, cs *data.Candles) *CurrencyService {
 	c := &CurrencyService{
-		ctx:           ctx,
-		log:           l,
-		rates:         r,
-		subscriptions: make(map[pb.Currency_SubscribeRatesServer][]*pb.RateRequest),
+		ctx:            ctx,
+		log:            l,
+		rates:          r,
+		quotes:         q,
+		pricing:        p,
+		candleStore:    cs,
+		listenersMutex: &sync.Mutex{},
 	}
+	c.subscriptions = newSubscriptions(l, c.sendRates)
 
 	go c.handleUpdates()
 
@@ -48,15 +73,20 @@ func NewCurrency(ctx context.Context, l *logrus.Logger, r *data.ExchangeRates) *
 // It listens for incoming client requests and sends updates periodically based on a ticker.
 // Each subscribed client is added to a subscription list, which is used to send updates to all subscribed clients.
 func (c *CurrencyService) SubscribeRates(stream pb.Currency_SubscribeRatesServer) error {
-	// generate a unique client ID
-	clientID := getClientID(c.ctx)
-
-	// register the client
-	//c.clients[clientID] = stream
+	// the client ID is set by the client ID interceptor
+	clientID := getClientID(stream.Context())
 
 	// notify that a new client has connected
 	c.log.Infof("client %s connected", clientID)
 
+	// stop sending updates once the client is gone
+	defer c.Unsubscribe(stream)
+
+	// sent along with the first update
+	if err := stream.SetHeader(c.RatesMetadata()); err != nil {
+		c.log.Errorf("unable to set rate metadata for client %s: %s", clientID, err)
+	}
+
 	// start an infinite loop to send rate updates
 	for {
 		// read the request from the client
@@ -74,98 +104,107 @@ func (c *CurrencyService) SubscribeRates(stream pb.Currency_SubscribeRatesServer
 		// log the request
 		c.log.Infof("Handling client request.%s", req.String())
 
-		rrs, ok := c.subscriptions[stream]
-		if !ok {
-			rrs = []*pb.RateRequest{}
-		}
-
-		// check that subscription does not exist
-		var validationError *status.Status
-		for _, v := range rrs {
-			if v.Base == req.Base && v.Destination == req.Destination {
-				validationError = status.Newf(
-					codes.AlreadyExists,
-					"unable to subscribe, already subscribed",
-				)
-				// add original request to the metadata
-				validationError, err = validationError.WithDetails(req)
-				if err != nil {
-					c.log.Errorf("unable to add metadata to error %s", err)
-					break
-				}
-
-				break
-			}
-		}
-		if validationError != nil {
-			stream.Send(
-				&pb.StreamingRateResponse{
-					Message: &pb.StreamingRateResponse_Error{
-						Error: validationError.Proto(),
-					},
-				},
-			)
+		if err := c.Subscribe(stream, req); err != nil {
+			c.sendError(stream, err)
 		}
-
-		rrs = append(rrs, req)
-		c.subscriptions[stream] = rrs
 	}
 
 	return nil
 }
 
+// Subscribe registers the subscriber for updates of the requested rate. It
+// returns an AlreadyExists status error, with the request in the details,
+// when the subscriber is already subscribed to the rate.
+func (c *CurrencyService) Subscribe(sub RateSubscriber, req *pb.RateRequest) error {
+	return c.subscriptions.add(sub, req)
+}
+
+// Unsubscribe removes all subscriptions of the subscriber. It waits for an
+// update being sent to the subscriber, so that nothing is sent once it returns.
+func (c *CurrencyService) Unsubscribe(sub RateSubscriber) {
+	c.subscriptions.remove(sub)
+}
+
+// sendError tells the subscriber that one of its requests failed.
+func (c *CurrencyService) sendError(sub RateSubscriber, err error) {
+	c.subscriptions.sendTo(sub, func() {
+		sub.Send(&pb.StreamingRateResponse{
+			Message: &pb.StreamingRateResponse_Error{
+				Error: status.Convert(err).Proto(),
+			},
+		})
+	})
+}
+
 // handleUpdates sends updated currency exchange rate to subscribed clients
-// every 5 seconds.
+// on every tick of the rate simulator.
 func (c *CurrencyService) handleUpdates() {
-	// Monitor exchange rate updates using a ticker with a 5-second interval
-	rateUpdates := c.rates.MonitorRates(c.ctx, 5*time.Second)
+	// Monitor exchange rate updates, the interval is set by the simulator
+	rateUpdates := c.rates.MonitorRates(c.ctx)
 
 	// Continuously loop over the ticker channel to receive rate updates
 	for range rateUpdates {
 		c.log.Info("got updated rates")
 		// Update all subscribed clients with the new exchange rate
 		c.updateSubscriptions()
+
+		c.listenersMutex.Lock()
+		listeners := c.listeners
+		c.listenersMutex.Unlock()
+		for _, f := range listeners {
+			f()
+		}
 	}
 }
 
+// OnUpdate registers f to be called after every rate update, so that other
+// versions of the API can share the update loop.
+func (c *CurrencyService) OnUpdate(f func()) {
+	c.listenersMutex.Lock()
+	defer c.listenersMutex.Unlock()
+
+	c.listeners = append(c.listeners, f)
+}
+
 // updateSubscriptions sends the updated currency exchange rate to each
 // subscribed client.
 func (c *CurrencyService) updateSubscriptions() {
-	// Loop over all subscribed clients and their requested currency pairs
-	for k, v := range c.subscriptions {
-		for _, rr := range v {
-			// Get the updated exchange rate for the client's currency pair
-			rate, err := c.rates.GetRate(rr.GetBase().String(), rr.GetDestination().String())
-			if err != nil {
-				// Log an error message if the exchange rate could not be retrieved
-				c.log.Error(
-					"unable to get updated rate",
-					"base", rr.GetBase(),
-					"destination", rr.GetDestination(),
-				)
-			}
-			err = k.Send(&pb.StreamingRateResponse{
-				Message: &pb.StreamingRateResponse_RateResponse{
-					RateResponse: &pb.RateResponse{
-						Base:        rr.Base,
-						Destination: rr.Destination,
-						Rate:        rate,
-					},
-				},
-			})
-			if err != nil {
-				c.log.Errorf(
-					"unable to send updated rate base %s destination %s",
-					rr.Base.String(), rr.Destination.String(),
-				)
-			}
+	c.subscriptions.update()
+}
+
+// sendRates sends the current rate of every requested pair to the subscriber.
+func (c *CurrencyService) sendRates(sub RateSubscriber, requests []*pb.RateRequest) {
+	// Loop over the client's requested currency pairs
+	for _, rr := range requests {
+		// Get the updated exchange rate for the client's currency pair
+		resp, err := c.rateResponse(rr)
+		if err != nil {
+			// Log an error message if the exchange rate could not be retrieved
+			c.log.Errorf(
+				"unable to get updated rate base %s destination %s: %s",
+				rr.GetBase(), rr.GetDestination(), err,
+			)
+			continue
+		}
+		err = sub.Send(&pb.StreamingRateResponse{
+			Message: &pb.StreamingRateResponse_RateResponse{
+				RateResponse: resp,
+			},
+		})
+		if err != nil {
+			c.log.Errorf(
+				"unable to send updated rate base %s destination %s",
+				rr.Base.String(), rr.Destination.String(),
+			)
+			// the client is gone, it is unsubscribed once its stream ends
+			return
 		}
 	}
 }
 
 // GetRate retrieves the exchange rate for the given base and destination currencies.
 // It returns a RateResponse containing the exchange rate and the base and destination currencies.
-func (c *CurrencyService) GetRate(_ context.Context, rr *pb.RateRequest) (*pb.RateResponse, error) {
+func (c *CurrencyService) GetRate(ctx context.Context, rr *pb.RateRequest) (*pb.RateResponse, error) {
 	c.log.Info("Handle GetRate ", " base ", rr.GetBase(), " destination ", rr.GetDestination())
 	if rr.Base == rr.Destination {
 		err := status.Newf(
@@ -182,26 +221,54 @@ func (c *CurrencyService) GetRate(_ context.Context, rr *pb.RateRequest) (*pb.Ra
 		return nil, statusObj.Err()
 	}
 
-	rate, err := c.rates.GetRate(rr.Base.String(), rr.GetDestination().String())
+	c.setRatesMetadata(ctx)
+	return c.rateResponse(rr)
+}
+
+// RatesMetadata describes how fresh the rates are, so that clients can tell
+// when they are served rates from a snapshot.
+func (c *CurrencyService) RatesMetadata() metadata.MD {
+	stale, fetchedAt := c.rates.Stale()
+	return metadata.Pairs(
+		RatesStaleHeader, strconv.FormatBool(stale),
+		RatesAsOfHeader, fetchedAt.UTC().Format(time.RFC3339),
+	)
+}
+
+// setRatesMetadata adds RatesMetadata to the response headers of a unary call.
+func (c *CurrencyService) setRatesMetadata(ctx context.Context) {
+	// fails outside of a gRPC call, e.g. in the REST gateway which sets its own headers
+	_ = grpc.SetHeader(ctx, c.RatesMetadata())
+}
+
+// rateResponse looks up the mid rate for the requested pair and applies the
+// pricing policy for the requested side.
+func (c *CurrencyService) rateResponse(rr *pb.RateRequest) (*pb.RateResponse, error) {
+	base, dest := rr.GetBase().String(), rr.GetDestination().String()
+
+	mid, err := c.rates.GetRateInfo(base, dest)
 	if err != nil {
-		return nil, err
+		// the rate source doesn't publish one of the currencies
+		return nil, status.Error(codes.NotFound, err.Error())
 	}
 	rateResp := &pb.RateResponse{
-		Rate:        rate,
+		Rate:        c.pricing.Apply(base, dest, data.Side(rr.GetSide()), mid.Rate),
+		MidRate:     mid.Rate,
+		Side:        rr.GetSide(),
 		Base:        rr.GetBase(),
-		Destination: rr.GetDestination()}
+		Destination: rr.GetDestination(),
+		Timestamp:   timestamppb.New(mid.ObservedAt),
+		Source:      mid.Source,
+		Sequence:    mid.Sequence,
+	}
 	return rateResp, nil
-
 }
 
 func getClientID(ctx context.Context) string {
-	id, ok := ctx.Value(contextClientIDKey).(string)
-	if !ok {
-		// Generate a new UUID as client ID
-		uuid := uuid.New().String()
-		// Add the UUID to the context
-		ctx = context.WithValue(ctx, contextClientIDKey, uuid)
-		return uuid
+	id := interceptors.ClientIDFromContext(ctx)
+	if id == "" {
+		// Generate a new UUID as client ID when the interceptor didn't run
+		return uuid.New().String()
 	}
 	return id
 }
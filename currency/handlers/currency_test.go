@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/samims/ecommerceGO/currency/data"
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+)
+
+// testEnv is a config.Env holding strings only
+type testEnv map[string]string
+
+func (e testEnv) Get(key string) interface{}           { return e[key] }
+func (e testEnv) GetString(key string) string          { return e[key] }
+func (e testEnv) GetInt(key string) int                { return 0 }
+func (e testEnv) GetBool(key string) bool              { return e[key] == "true" }
+func (e testEnv) GetFloat64(key string) float64        { return 0 }
+func (e testEnv) GetDuration(key string) time.Duration { return 0 }
+func (e testEnv) GetStringSlice(key string) []string   { return strings.Split(e[key], ",") }
+
+func logger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+// newTestCurrency creates a CurrencyService with the rates read from a file.
+func newTestCurrency(t *testing.T, rates string) *CurrencyService {
+	t.Helper()
+	l := logger()
+
+	path := filepath.Join(t.TempDir(), "rates.json")
+	if err := os.WriteFile(path, []byte(rates), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := data.NewRates(l, testEnv{constants.EnvRateProviders: "test=file://" + path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricing := data.NewStaticPricing(l, &data.PricingPolicy{})
+	quotes := data.NewQuotes(l, r, pricing, data.NewMemoryQuoteStore(0), time.Minute, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewCurrency(ctx, l, r, quotes, pricing, nil)
+}
+
+// testSubscriber records the updates it is sent, sends block while
+// blocked is open
+type testSubscriber struct {
+	sent    chan *pb.StreamingRateResponse
+	blocked chan struct{}
+}
+
+func newTestSubscriber() *testSubscriber {
+	return &testSubscriber{sent: make(chan *pb.StreamingRateResponse, 10)}
+}
+
+func (s *testSubscriber) Send(m *pb.StreamingRateResponse) error {
+	if s.blocked != nil {
+		<-s.blocked
+	}
+	s.sent <- m
+	return nil
+}
+
+func TestSlowSubscriberDoesntHoldUpUpdates(t *testing.T) {
+	c := newTestCurrency(t, `{"EUR": 1, "USD": 1.1}`)
+	rr := &pb.RateRequest{Base: pb.Currencies_EUR, Destination: pb.Currencies_USD}
+
+	slow, fast := newTestSubscriber(), newTestSubscriber()
+	slow.blocked = make(chan struct{})
+	for _, s := range []*testSubscriber{slow, fast} {
+		if err := c.Subscribe(s, rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the slow subscriber skips the second update while stuck in the first
+	for i := 0; i < 2; i++ {
+		c.updateSubscriptions()
+		select {
+		case m := <-fast.sent:
+			if m.GetRateResponse().GetMidRate() != 1.1 {
+				t.Fatalf("unexpected update %v", m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected update %d to reach the fast subscriber", i+1)
+		}
+	}
+
+	// subscribing and unsubscribing don't wait for the slow subscriber
+	other := newTestSubscriber()
+	if err := c.Subscribe(other, rr); err != nil {
+		t.Fatal(err)
+	}
+	c.Unsubscribe(other)
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		c.Unsubscribe(slow)
+		close(unsubscribed)
+	}()
+	select {
+	case <-unsubscribed:
+		t.Fatal("expected Unsubscribe to wait for the update being sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(slow.blocked)
+	<-unsubscribed
+
+	if n := len(slow.sent); n != 1 {
+		t.Errorf("expected the slow subscriber to get one update got %d", n)
+	}
+}
+
+func TestSubscribeTwice(t *testing.T) {
+	c := newTestCurrency(t, `{"EUR": 1, "USD": 1.1}`)
+	rr := &pb.RateRequest{Base: pb.Currencies_EUR, Destination: pb.Currencies_USD}
+
+	s := newTestSubscriber()
+	if err := c.Subscribe(s, rr); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Subscribe(s, rr)
+	if err == nil {
+		t.Fatal("expected the second subscription to be refused")
+	}
+	c.sendError(s, err)
+	if m := <-s.sent; m.GetError().GetMessage() == "" {
+		t.Errorf("expected an error message got %v", m)
+	}
+}
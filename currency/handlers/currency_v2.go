@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/samims/ecommerceGO/currency/data"
+	pbv2 "github.com/samims/ecommerceGO/currency/protos/currency/v2"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CurrencyV2Service implements v2 of the Currency gRPC service, which
+// identifies currencies by ISO 4217 code. It serves the same rates, quotes
+// and pricing as the v1 CurrencyService and shares its update loop.
+type CurrencyV2Service struct {
+	log           *logrus.Logger
+	v1            *CurrencyService
+	subscriptions *subscriptions[pbv2.Currency_SubscribeRatesServer, *pbv2.RateRequest]
+	pbv2.UnimplementedCurrencyServer
+}
+
+// NewCurrencyV2 creates a CurrencyV2Service on top of the v1 service and
+// subscribes it to the v1 rate updates.
+func NewCurrencyV2(l *logrus.Logger, v1 *CurrencyService) *CurrencyV2Service {
+	c := &CurrencyV2Service{
+		log: l,
+		v1:  v1,
+	}
+	c.subscriptions = newSubscriptions(l, c.sendRates)
+	v1.OnUpdate(c.updateSubscriptions)
+	return c
+}
+
+// GetRate returns the rate of the requested pair.
+func (c *CurrencyV2Service) GetRate(ctx context.Context, rr *pbv2.RateRequest) (*pbv2.RateResponse, error) {
+	c.log.Info("Handle v2 GetRate ", " base ", rr.GetBase(), " destination ", rr.GetDestination())
+
+	rr, err := c.validate(rr)
+	if err != nil {
+		return nil, err
+	}
+	c.v1.setRatesMetadata(ctx)
+	return c.rateResponse(rr)
+}
+
+// ListCurrencies returns the currencies the rate source publishes rates for
+// and the configured custom currencies.
+func (c *CurrencyV2Service) ListCurrencies(_ context.Context, _ *pbv2.ListCurrenciesRequest) (*pbv2.ListCurrenciesResponse, error) {
+	return &pbv2.ListCurrenciesResponse{Currencies: c.v1.rates.Currencies()}, nil
+}
+
+// SubscribeRates streams the rates of the requested pairs on every update.
+// Invalid and duplicate requests are answered with an error message on the
+// stream, the stream stays open.
+func (c *CurrencyV2Service) SubscribeRates(stream pbv2.Currency_SubscribeRatesServer) error {
+	clientID := getClientID(stream.Context())
+	c.log.Infof("v2 client %s connected", clientID)
+
+	defer c.subscriptions.remove(stream)
+
+	if err := stream.SetHeader(c.v1.RatesMetadata()); err != nil {
+		c.log.Errorf("unable to set rate metadata for client %s: %s", clientID, err)
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			c.log.Infof("v2 client %s disconnected", clientID)
+			return nil
+		}
+		if err != nil {
+			c.log.Errorf("error receiving stream from client %s: %v", clientID, err)
+			return err
+		}
+
+		if err := c.subscribe(stream, req); err != nil {
+			c.sendError(stream, err)
+		}
+	}
+}
+
+func (c *CurrencyV2Service) subscribe(stream pbv2.Currency_SubscribeRatesServer, req *pbv2.RateRequest) error {
+	req, err := c.validate(req)
+	if err != nil {
+		return err
+	}
+
+	return c.subscriptions.add(stream, req)
+}
+
+func (c *CurrencyV2Service) sendError(stream pbv2.Currency_SubscribeRatesServer, err error) {
+	c.subscriptions.sendTo(stream, func() {
+		stream.Send(&pbv2.StreamingRateResponse{
+			Message: &pbv2.StreamingRateResponse_Error{Error: status.Convert(err).Proto()},
+		})
+	})
+}
+
+// updateSubscriptions sends the current rate of every subscribed pair.
+func (c *CurrencyV2Service) updateSubscriptions() {
+	c.subscriptions.update()
+}
+
+func (c *CurrencyV2Service) sendRates(stream pbv2.Currency_SubscribeRatesServer, requests []*pbv2.RateRequest) {
+	for _, rr := range requests {
+		resp, err := c.rateResponse(rr)
+		if err != nil {
+			// the currency may have been dropped by the rate source
+			c.log.Errorf("unable to get updated rate %s/%s: %s", rr.Base, rr.Destination, err)
+			continue
+		}
+		err = stream.Send(&pbv2.StreamingRateResponse{
+			Message: &pbv2.StreamingRateResponse_RateResponse{RateResponse: resp},
+		})
+		if err != nil {
+			c.log.Errorf("unable to send updated rate %s/%s: %s", rr.Base, rr.Destination, err)
+			return
+		}
+	}
+}
+
+// CreateQuote locks in the current rate of the requested pair.
+func (c *CurrencyV2Service) CreateQuote(ctx context.Context, req *pbv2.CreateQuoteRequest) (*pbv2.Quote, error) {
+	c.log.Info("Handle v2 CreateQuote ", " base ", req.GetBase(), " destination ", req.GetDestination())
+
+	rr, err := c.validate(&pbv2.RateRequest{Base: req.GetBase(), Destination: req.GetDestination(), Side: req.GetSide()})
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.v1.quotes.Create(rr.Base, rr.Destination, data.Side(rr.Side), req.GetTtl().AsDuration())
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	c.v1.setRatesMetadata(ctx)
+	return quoteToProtoV2(q), nil
+}
+
+// GetQuote returns a quote by its id, quotes created through v1 included.
+func (c *CurrencyV2Service) GetQuote(_ context.Context, req *pbv2.GetQuoteRequest) (*pbv2.Quote, error) {
+	q, err := c.v1.quotes.Get(req.GetId())
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	return quoteToProtoV2(q), nil
+}
+
+// RedeemQuote marks the quote as used.
+func (c *CurrencyV2Service) RedeemQuote(_ context.Context, req *pbv2.RedeemQuoteRequest) (*pbv2.Quote, error) {
+	q, err := c.v1.quotes.Redeem(req.GetId())
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	return quoteToProtoV2(q), nil
+}
+
+// validate normalizes the currency codes of the request and checks that the
+// rate source publishes both currencies.
+func (c *CurrencyV2Service) validate(rr *pbv2.RateRequest) (*pbv2.RateRequest, error) {
+	base, err := c.currencyCode("base", rr.GetBase())
+	if err != nil {
+		return nil, err
+	}
+	dest, err := c.currencyCode("destination", rr.GetDestination())
+	if err != nil {
+		return nil, err
+	}
+	if base == dest {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"base currency %s and destination currency %s shouldn't be same", base, dest,
+		)
+	}
+	if _, ok := pbv2.Side_name[int32(rr.GetSide())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown side %d", rr.GetSide())
+	}
+	return &pbv2.RateRequest{Base: base, Destination: dest, Side: rr.GetSide()}, nil
+}
+
+// currencyCode returns the code in upper case. Codes which are neither three
+// letters nor a custom currency are InvalidArgument, codes without rates NotFound.
+func (c *CurrencyV2Service) currencyCode(field, code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if c.v1.rates.HasCurrency(code) {
+		return code, nil
+	}
+	if !isISO4217(code) {
+		return "", status.Errorf(codes.InvalidArgument, "%s %q is not an ISO 4217 currency code", field, code)
+	}
+	return "", status.Errorf(codes.NotFound, "%s currency %s is not supported", field, code)
+}
+
+func isISO4217(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CurrencyV2Service) rateResponse(rr *pbv2.RateRequest) (*pbv2.RateResponse, error) {
+	mid, err := c.v1.rates.GetRateInfo(rr.Base, rr.Destination)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return &pbv2.RateResponse{
+		Base:        rr.Base,
+		Destination: rr.Destination,
+		Rate:        c.v1.pricing.Apply(rr.Base, rr.Destination, data.Side(rr.Side), mid.Rate),
+		MidRate:     mid.Rate,
+		Side:        rr.Side,
+		Timestamp:   timestamppb.New(mid.ObservedAt),
+		Source:      mid.Source,
+		Sequence:    mid.Sequence,
+	}, nil
+}
+
+func quoteToProtoV2(q *data.Quote) *pbv2.Quote {
+	pq := &pbv2.Quote{
+		Id:          q.ID,
+		Base:        q.Base,
+		Destination: q.Destination,
+		Rate:        q.Rate,
+		MidRate:     q.MidRate,
+		Side:        pbv2.Side(q.Side),
+		CreatedAt:   timestamppb.New(q.CreatedAt),
+		ExpiresAt:   timestamppb.New(q.ExpiresAt),
+		Redeemed:    q.Redeemed(),
+	}
+	if q.RedeemedAt != nil {
+		pq.RedeemedAt = timestamppb.New(*q.RedeemedAt)
+	}
+	return pq
+}
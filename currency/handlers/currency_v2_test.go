@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/data"
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	pbv2 "github.com/samims/ecommerceGO/currency/protos/currency/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testRates has AED, which v1 has no value for
+const testRates = `{"EUR": 1, "USD": 1.1, "AED": 4}`
+
+// testStream is a v2 rate stream, requests are received from requests until
+// it is closed
+type testStream struct {
+	grpc.ServerStream
+	requests chan *pbv2.RateRequest
+	sent     chan *pbv2.StreamingRateResponse
+}
+
+func newTestStream() *testStream {
+	return &testStream{
+		requests: make(chan *pbv2.RateRequest),
+		sent:     make(chan *pbv2.StreamingRateResponse, 10),
+	}
+}
+
+func (s *testStream) Context() context.Context    { return context.Background() }
+func (s *testStream) SetHeader(metadata.MD) error { return nil }
+func (s *testStream) Send(m *pbv2.StreamingRateResponse) error {
+	s.sent <- m
+	return nil
+}
+
+func (s *testStream) Recv() (*pbv2.RateRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *testStream) next(t *testing.T) *pbv2.StreamingRateResponse {
+	t.Helper()
+	select {
+	case m := <-s.sent:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("expected a message on the stream")
+		return nil
+	}
+}
+
+func TestV2GetRate(t *testing.T) {
+	c := NewCurrencyV2(logger(), newTestCurrency(t, testRates))
+
+	tests := []struct {
+		name string
+		req  *pbv2.RateRequest
+		code codes.Code
+		rate float64
+	}{
+		{"mid rate", &pbv2.RateRequest{Base: "EUR", Destination: "USD"}, codes.OK, 1.1},
+		{"lower case and spaces", &pbv2.RateRequest{Base: " eur", Destination: "usd "}, codes.OK, 1.1},
+		{"cross rate", &pbv2.RateRequest{Base: "USD", Destination: "AED"}, codes.OK, 4 / 1.1},
+		{"sell side", &pbv2.RateRequest{Base: "EUR", Destination: "USD", Side: pbv2.Side_SELL}, codes.OK, 1.1},
+		{"unknown side", &pbv2.RateRequest{Base: "EUR", Destination: "USD", Side: 7}, codes.InvalidArgument, 0},
+		{"same currencies", &pbv2.RateRequest{Base: "EUR", Destination: "eur"}, codes.InvalidArgument, 0},
+		{"not a code", &pbv2.RateRequest{Base: "EURO", Destination: "USD"}, codes.InvalidArgument, 0},
+		{"digits", &pbv2.RateRequest{Base: "EUR", Destination: "U5D"}, codes.InvalidArgument, 0},
+		{"missing", &pbv2.RateRequest{Base: "EUR"}, codes.InvalidArgument, 0},
+		{"without rates", &pbv2.RateRequest{Base: "EUR", Destination: "XAU"}, codes.NotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := c.GetRate(context.Background(), tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %s got %v", tt.code, err)
+			}
+			if err != nil {
+				return
+			}
+			if resp.GetMidRate() != tt.rate {
+				t.Errorf("expected mid rate %v got %v", tt.rate, resp.GetMidRate())
+			}
+			if resp.GetBase() != "EUR" && resp.GetBase() != "USD" {
+				t.Errorf("expected a normalized base got %q", resp.GetBase())
+			}
+		})
+	}
+}
+
+func TestV2SubscribeRates(t *testing.T) {
+	c := NewCurrencyV2(logger(), newTestCurrency(t, testRates))
+	stream := newTestStream()
+	done := make(chan error)
+	go func() { done <- c.SubscribeRates(stream) }()
+
+	// every request is handled before the next one is received
+	stream.requests <- &pbv2.RateRequest{Base: "EUR", Destination: "AED"}
+	stream.requests <- &pbv2.RateRequest{Base: "EUR", Destination: "XXXX"}
+	stream.requests <- &pbv2.RateRequest{Base: "eur", Destination: "aed"}
+
+	for _, code := range []codes.Code{codes.InvalidArgument, codes.AlreadyExists} {
+		m := stream.next(t)
+		if got := codes.Code(m.GetError().GetCode()); got != code {
+			t.Fatalf("expected a %s error on the stream got %v", code, m)
+		}
+	}
+
+	c.updateSubscriptions()
+	m := stream.next(t)
+	if rr := m.GetRateResponse(); rr.GetDestination() != "AED" || rr.GetMidRate() != 4 {
+		t.Fatalf("expected the AED rate got %v", m)
+	}
+
+	close(stream.requests)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.subscriptions.mutex.Lock()
+	n := len(c.subscriptions.subs)
+	c.subscriptions.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("expected the stream to be unsubscribed, %d left", n)
+	}
+}
+
+func TestV2ListCurrencies(t *testing.T) {
+	c := NewCurrencyV2(logger(), newTestCurrency(t, testRates))
+
+	resp, err := c.ListCurrencies(context.Background(), &pbv2.ListCurrenciesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, code := range resp.GetCurrencies() {
+		found[code] = true
+	}
+	if !found["EUR"] || !found["USD"] || !found["AED"] {
+		t.Errorf("expected every currency with rates got %v", resp.GetCurrencies())
+	}
+}
+
+func TestV1RefusesV2OnlyQuotes(t *testing.T) {
+	v1 := newTestCurrency(t, testRates)
+	c := NewCurrencyV2(logger(), v1)
+
+	q, err := c.CreateQuote(context.Background(), &pbv2.CreateQuoteRequest{Base: "EUR", Destination: "AED"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// AED isn't EUR to v1, the quote can't be returned
+	if _, err := v1.GetQuote(context.Background(), &pb.GetQuoteRequest{Id: q.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition got %v", err)
+	}
+	if _, err := v1.RedeemQuote(context.Background(), &pb.RedeemQuoteRequest{Id: q.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition got %v", err)
+	}
+
+	q, err = c.RedeemQuote(context.Background(), &pbv2.RedeemQuoteRequest{Id: q.GetId()})
+	if err != nil {
+		t.Fatalf("expected the refused v1 redemption to leave the quote open, got %v", err)
+	}
+	if !q.GetRedeemed() {
+		t.Error("expected the quote to be redeemed through v2")
+	}
+}
+
+func TestCustomCurrenciesAreV2Only(t *testing.T) {
+	v1 := newTestCurrency(t, testRates)
+	custom, err := data.NewCustomCurrencies([]*data.CustomCurrency{{Code: "PTS", Reference: "USD", Rate: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1.rates.UseCustomCurrencies(custom)
+	c := NewCurrencyV2(logger(), v1)
+
+	resp, err := c.GetRate(context.Background(), &pbv2.RateRequest{Base: "EUR", Destination: "pts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(resp.GetMidRate()-110) > 1e-9 {
+		t.Errorf("expected 110 PTS per EUR got %v", resp.GetMidRate())
+	}
+	list, err := c.ListCurrencies(context.Background(), &pbv2.ListCurrenciesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, code := range list.GetCurrencies() {
+		found = found || code == "PTS"
+	}
+	if !found {
+		t.Errorf("expected PTS to be listed got %v", list.GetCurrencies())
+	}
+
+	// v1 has no enum value for PTS, quotes in PTS can't be returned
+	q, err := c.CreateQuote(context.Background(), &pbv2.CreateQuoteRequest{Base: "EUR", Destination: "PTS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pb.Currencies_value["PTS"]; ok {
+		t.Fatal("expected PTS not to be a v1 currency")
+	}
+	if _, err := v1.GetQuote(context.Background(), &pb.GetQuoteRequest{Id: q.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition got %v", err)
+	}
+}
+
+func TestListOverridesSkipsV2Only(t *testing.T) {
+	o, err := data.NewOverrides(logger(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ov := range []*data.Override{{Base: "EUR", Destination: "USD", Rate: 1.2}, {Base: "EUR", Destination: "AED", Rate: 4.2}} {
+		if _, err := o.Set(ov); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := NewAdmin(logger(), o, "secret")
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationHeader, "Bearer secret"))
+	resp, err := a.ListOverrides(ctx, &pb.ListOverridesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetOverrides()) != 1 || resp.GetOverrides()[0].GetDestination() != pb.Currencies_USD {
+		t.Errorf("expected only the USD override got %v", resp.GetOverrides())
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/samims/ecommerceGO/currency/data"
+	pb "github.com/samims/ecommerceGO/currency/protos/currency"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CreateQuote locks in the current exchange rate for the requested currency pair.
+// The returned quote can be looked up with GetQuote and used once with RedeemQuote.
+func (c *CurrencyService) CreateQuote(ctx context.Context, req *pb.CreateQuoteRequest) (*pb.Quote, error) {
+	c.log.Info("Handle CreateQuote ", " base ", req.GetBase(), " destination ", req.GetDestination())
+	if req.GetBase() == req.GetDestination() {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"base currency %s and destination currency %s shouldn't be same",
+			req.GetBase().String(),
+			req.GetDestination().String(),
+		)
+	}
+
+	q, err := c.quotes.Create(
+		req.GetBase().String(),
+		req.GetDestination().String(),
+		data.Side(req.GetSide()),
+		req.GetTtl().AsDuration(),
+	)
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	c.setRatesMetadata(ctx)
+	return quoteToProto(q)
+}
+
+// GetQuote returns a quote by its id.
+func (c *CurrencyService) GetQuote(_ context.Context, req *pb.GetQuoteRequest) (*pb.Quote, error) {
+	c.log.Info("Handle GetQuote ", " id ", req.GetId())
+
+	q, err := c.quotes.Get(req.GetId())
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	return quoteToProto(q)
+}
+
+// RedeemQuote marks the quote as used. Expired or already redeemed quotes
+// are rejected with FailedPrecondition.
+func (c *CurrencyService) RedeemQuote(_ context.Context, req *pb.RedeemQuoteRequest) (*pb.Quote, error) {
+	c.log.Info("Handle RedeemQuote ", " id ", req.GetId())
+
+	// a quote v1 can't return isn't redeemed
+	q, err := c.quotes.Get(req.GetId())
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	if _, err := quoteToProto(q); err != nil {
+		return nil, err
+	}
+
+	q, err = c.quotes.Redeem(req.GetId())
+	if err != nil {
+		return nil, quoteError(err)
+	}
+	return quoteToProto(q)
+}
+
+// quoteError maps errors from data.Quotes to gRPC status errors.
+func quoteError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrQuoteNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, data.ErrQuoteExpired), errors.Is(err, data.ErrQuoteRedeemed):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, data.ErrInvalidTTL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, data.ErrTooManyQuotes):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// v1Currency returns the v1 value of a currency code. Quotes created through
+// v2 and overrides loaded from the overrides file can have currencies v1 has
+// no value for, which are FailedPrecondition rather than the zero value EUR.
+func v1Currency(code string) (pb.Currencies, error) {
+	v, ok := pb.Currencies_value[code]
+	if !ok {
+		return 0, status.Errorf(codes.FailedPrecondition, "currency %s is only available in the v2 API", code)
+	}
+	return pb.Currencies(v), nil
+}
+
+// quoteToProto converts the quote, it fails when one of its currencies isn't
+// available in v1.
+func quoteToProto(q *data.Quote) (*pb.Quote, error) {
+	base, err := v1Currency(q.Base)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := v1Currency(q.Destination)
+	if err != nil {
+		return nil, err
+	}
+	pq := &pb.Quote{
+		Id:          q.ID,
+		Base:        base,
+		Destination: dest,
+		Rate:        q.Rate,
+		MidRate:     q.MidRate,
+		Side:        pb.Side(q.Side),
+		CreatedAt:   timestamppb.New(q.CreatedAt),
+		ExpiresAt:   timestamppb.New(q.ExpiresAt),
+		Redeemed:    q.Redeemed(),
+	}
+	if q.RedeemedAt != nil {
+		pq.RedeemedAt = timestamppb.New(*q.RedeemedAt)
+	}
+	return pq, nil
+}
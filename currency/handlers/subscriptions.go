@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// rateRequest is the rate request of either version of the API, status
+// details take the messages by their older interface.
+type rateRequest interface {
+	proto.Message
+	Reset()
+	String() string
+	ProtoMessage()
+}
+
+// subscriptions keeps the rate requests of the subscribers of either version
+// of the API, S is the subscriber and R its rate request. The subscribers are
+// streams, the interfaces aren't comparable type arguments before Go 1.20 so
+// they are keyed as any.
+type subscriptions[S any, R rateRequest] struct {
+	log   *logrus.Logger
+	mutex *sync.Mutex
+	subs  map[any]*subscription[S, R]
+	// sendRates sends the current rates of the requests to the subscriber
+	sendRates func(sub S, requests []R)
+}
+
+// subscription holds the rates a subscriber asked for. Updates are sent
+// without holding the mutex of the subscriptions, sending serializes the
+// sends to the subscriber as a stream must not be sent to concurrently.
+type subscription[S any, R rateRequest] struct {
+	sub      S
+	sending  *sync.Mutex
+	requests []R
+}
+
+func newSubscriptions[S any, R rateRequest](l *logrus.Logger, sendRates func(sub S, requests []R)) *subscriptions[S, R] {
+	return &subscriptions[S, R]{
+		log:       l,
+		mutex:     &sync.Mutex{},
+		subs:      make(map[any]*subscription[S, R]),
+		sendRates: sendRates,
+	}
+}
+
+// add registers the subscriber for updates of the requested rate. It returns
+// an AlreadyExists status error, with the request in the details, when the
+// subscriber is already subscribed to the rate.
+func (s *subscriptions[S, R]) add(sub S, req R) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sn, ok := s.subs[sub]
+	if !ok {
+		sn = &subscription[S, R]{sub: sub, sending: &sync.Mutex{}}
+		s.subs[sub] = sn
+	}
+
+	for _, v := range sn.requests {
+		if proto.Equal(v, req) {
+			validationError := status.New(codes.AlreadyExists, "unable to subscribe, already subscribed")
+			// add original request to the metadata
+			withDetails, err := validationError.WithDetails(req)
+			if err != nil {
+				s.log.Errorf("unable to add metadata to error %s", err)
+				return validationError.Err()
+			}
+			return withDetails.Err()
+		}
+	}
+
+	sn.requests = append(sn.requests, req)
+	return nil
+}
+
+// remove removes all subscriptions of the subscriber. It waits for an update
+// being sent to the subscriber, so that nothing is sent once it returns.
+func (s *subscriptions[S, R]) remove(sub S) {
+	s.mutex.Lock()
+	sn, ok := s.subs[sub]
+	delete(s.subs, sub)
+	s.mutex.Unlock()
+
+	if ok {
+		sn.sending.Lock()
+		sn.sending.Unlock()
+	}
+}
+
+// sendTo calls send while no update is sent to the subscriber.
+func (s *subscriptions[S, R]) sendTo(sub S, send func()) {
+	s.mutex.Lock()
+	sn, ok := s.subs[sub]
+	s.mutex.Unlock()
+	if ok {
+		sn.sending.Lock()
+		defer sn.sending.Unlock()
+	}
+	send()
+}
+
+// update sends the current rates to each subscriber. Every subscriber is sent
+// to on its own goroutine, a subscriber still busy with the previous update
+// skips this one, so that slow subscribers don't hold up the others.
+func (s *subscriptions[S, R]) update() {
+	s.mutex.Lock()
+	subs := make([]subscription[S, R], 0, len(s.subs))
+	for _, v := range s.subs {
+		subs = append(subs, *v)
+	}
+	s.mutex.Unlock()
+
+	for _, v := range subs {
+		if !v.sending.TryLock() {
+			s.log.Warnf("skipping a rate update, the client is still receiving the previous one")
+			continue
+		}
+		go func(sn subscription[S, R]) {
+			defer sn.sending.Unlock()
+			s.sendRates(sn.sub, sn.requests)
+		}(v)
+	}
+}
@@ -0,0 +1,127 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator checks the credentials of a call. It returns the name of the
+// authenticated caller, or an error with a gRPC status when the call is rejected.
+type Authenticator interface {
+	Authenticate(ctx context.Context) (string, error)
+}
+
+// PrincipalFromContext returns the caller set by the auth interceptors, or
+// an empty string for unauthenticated calls.
+func PrincipalFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(contextPrincipalKey).(string)
+	return p
+}
+
+// BearerTokens authenticates calls carrying "authorization: Bearer <token>"
+// metadata against a fixed set of tokens.
+type BearerTokens struct {
+	// tokens maps a token to the name of the client using it
+	tokens map[string]string
+}
+
+// NewBearerTokens creates a BearerTokens from "name:token" pairs, entries
+// without a name are named after their position.
+func NewBearerTokens(pairs []string) *BearerTokens {
+	b := &BearerTokens{tokens: map[string]string{}}
+	for i, p := range pairs {
+		name, token, ok := strings.Cut(strings.TrimSpace(p), ":")
+		if !ok {
+			name, token = "client-"+strconv.Itoa(i), name
+		}
+		if token != "" {
+			b.tokens[token] = name
+		}
+	}
+	return b
+}
+
+func (b *BearerTokens) Authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	v := md.Get("authorization")
+	if len(v) == 0 || !strings.HasPrefix(v[0], "Bearer ") {
+		return "", status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	token := strings.TrimPrefix(v[0], "Bearer ")
+
+	// compare against every token so that the time taken doesn't leak which
+	// prefix matched
+	name := ""
+	for t, n := range b.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			name = n
+		}
+	}
+	if name == "" {
+		return "", status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+	return name, nil
+}
+
+// SkipMethod reports whether a method doesn't need authentication.
+type SkipMethod func(fullMethod string) bool
+
+// SkipServices returns a SkipMethod which skips every method of the given services.
+func SkipServices(services ...string) SkipMethod {
+	return func(fullMethod string) bool {
+		for _, s := range services {
+			if strings.HasPrefix(fullMethod, "/"+s+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// UnaryAuth rejects unary calls the Authenticator doesn't accept.
+func UnaryAuth(l *logrus.Logger, a Authenticator, skip SkipMethod) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if skip != nil && skip(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(l, a, ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth rejects streams the Authenticator doesn't accept.
+func StreamAuth(l *logrus.Logger, a Authenticator, skip SkipMethod) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if skip != nil && skip(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(l, a, ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticate(l *logrus.Logger, a Authenticator, ctx context.Context, method string) (context.Context, error) {
+	principal, err := a.Authenticate(ctx)
+	if err != nil {
+		l.WithFields(logrus.Fields{
+			"grpc.method": method,
+			"client_id":   ClientIDFromContext(ctx),
+		}).Warn("rejected unauthenticated call")
+		return nil, err
+	}
+	return context.WithValue(ctx, contextPrincipalKey, principal), nil
+}
@@ -0,0 +1,71 @@
+// Package interceptors provides the gRPC server interceptors of the currency
+// service: client identification, request logging, metrics, panic recovery
+// and authentication.
+package interceptors
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ClientIDHeader is the metadata key clients can use to identify themselves.
+const ClientIDHeader = "x-client-id"
+
+type contextKey int
+
+const (
+	contextClientIDKey contextKey = iota
+	contextPrincipalKey
+)
+
+// ClientIDFromContext returns the client ID stored by the client ID
+// interceptors, or an empty string when there is none.
+func ClientIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextClientIDKey).(string)
+	return id
+}
+
+// withClientID stores the client ID sent in the x-client-id metadata in the
+// context, clients which don't send one get a generated UUID.
+func withClientID(ctx context.Context) context.Context {
+	if ClientIDFromContext(ctx) != "" {
+		return ctx
+	}
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(ClientIDHeader); len(v) > 0 {
+			id = v[0]
+		}
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	return context.WithValue(ctx, contextClientIDKey, id)
+}
+
+// UnaryClientID adds the client ID to the context of unary calls.
+func UnaryClientID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withClientID(ctx), req)
+	}
+}
+
+// StreamClientID adds the client ID to the context of streams.
+func StreamClientID() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: withClientID(ss.Context())})
+	}
+}
+
+// wrappedStream is a grpc.ServerStream with a replaced context.
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
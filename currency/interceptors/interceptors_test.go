@@ -0,0 +1,267 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/samims/ecommerceGO/currency/metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func testLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+// fakeStream is a grpc.ServerStream with only a context
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeStream) Context() context.Context {
+	return f.ctx
+}
+
+func TestUnaryRecovery(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/Currency/GetRate"}
+	_, err := UnaryRecovery(testLogger())(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal got %v", err)
+	}
+}
+
+func TestUnaryAuth(t *testing.T) {
+	auth := NewBearerTokens([]string{"product-api:secret"})
+	info := &grpc.UnaryServerInfo{FullMethod: "/Currency/GetRate"}
+	interceptor := UnaryAuth(testLogger(), auth, SkipServices("grpc.health.v1.Health"))
+
+	var principal string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		principal = PrincipalFromContext(ctx)
+		return nil, nil
+	}
+
+	if _, err := interceptor(context.Background(), nil, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token got %v", err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	if _, err := interceptor(ctx, nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if principal != "product-api" {
+		t.Fatalf("expected principal product-api got %q", principal)
+	}
+
+	health := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	if _, err := interceptor(context.Background(), nil, health, handler); err != nil {
+		t.Fatalf("expected health checks to skip auth got %v", err)
+	}
+}
+
+func TestStreamRecovery(t *testing.T) {
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates"}
+	err := StreamRecovery(testLogger())(nil, &fakeStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal got %v", err)
+	}
+}
+
+func TestStreamAuth(t *testing.T) {
+	auth := NewBearerTokens([]string{"product-api:secret"})
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates"}
+	interceptor := StreamAuth(testLogger(), auth, nil)
+
+	var principal string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		principal = PrincipalFromContext(ss.Context())
+		return nil
+	}
+
+	if err := interceptor(nil, &fakeStream{ctx: context.Background()}, info, handler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a token got %v", err)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	if err := interceptor(nil, &fakeStream{ctx: ctx}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if principal != "product-api" {
+		t.Fatalf("expected principal product-api got %q", principal)
+	}
+}
+
+func TestStreamClientID(t *testing.T) {
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates"}
+	var id string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		id = ClientIDFromContext(ss.Context())
+		return nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ClientIDHeader, "checkout"))
+	if err := StreamClientID()(nil, &fakeStream{ctx: ctx}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if id != "checkout" {
+		t.Fatalf("expected client ID checkout got %q", id)
+	}
+
+	if err := StreamClientID()(nil, &fakeStream{ctx: context.Background()}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || id == "checkout" {
+		t.Fatalf("expected a generated client ID got %q", id)
+	}
+}
+
+func TestUnaryLogging(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	info := &grpc.UnaryServerInfo{FullMethod: "/Currency/GetRate"}
+	ctx := withClientID(metadata.NewIncomingContext(context.Background(), metadata.Pairs(ClientIDHeader, "checkout")))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000}})
+
+	_, err := UnaryLogging(l)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "no rate")
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the handler error got %v", err)
+	}
+
+	e := hook.LastEntry()
+	if e == nil || e.Level != logrus.WarnLevel || e.Message != "finished call" {
+		t.Fatalf("expected a finished call warning got %+v", e)
+	}
+	want := logrus.Fields{
+		"grpc.method": "/Currency/GetRate",
+		"grpc.code":   "NotFound",
+		"client_id":   "checkout",
+		"peer":        "127.0.0.1:4000",
+	}
+	for k, v := range want {
+		if e.Data[k] != v {
+			t.Errorf("expected %s %v got %v", k, v, e.Data[k])
+		}
+	}
+	if _, ok := e.Data["duration_ms"]; !ok {
+		t.Error("expected the duration to be logged")
+	}
+}
+
+func TestStreamLogging(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates"}
+	err := StreamLogging(l)(nil, &fakeStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 || entries[0].Message != "stream started" || entries[1].Message != "finished call" {
+		t.Fatalf("expected the start and end of the stream to be logged got %v", entries)
+	}
+	if entries[1].Level != logrus.InfoLevel || entries[1].Data["grpc.code"] != "OK" {
+		t.Errorf("expected an OK stream got %+v", entries[1])
+	}
+}
+
+func TestUnaryMetrics(t *testing.T) {
+	m := metrics.NewRegistry()
+	info := &grpc.UnaryServerInfo{FullMethod: "/Currency/GetRate"}
+	interceptor := UnaryMetrics(m)
+
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	failed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "no rate")
+	}
+	interceptor(context.Background(), nil, info, ok)
+	interceptor(context.Background(), nil, info, failed)
+
+	if n := m.Counter("grpc.calls /Currency/GetRate"); n != 2 {
+		t.Errorf("expected 2 calls got %d", n)
+	}
+	if n := m.Counter("grpc.errors /Currency/GetRate"); n != 1 {
+		t.Errorf("expected 1 error got %d", n)
+	}
+	if n := m.Counter("grpc.codes /Currency/GetRate NotFound"); n != 1 {
+		t.Errorf("expected 1 NotFound got %d", n)
+	}
+	if timer := m.Snapshot().Timers["grpc.latency /Currency/GetRate"]; timer.Count != 2 {
+		t.Errorf("expected 2 latencies got %d", timer.Count)
+	}
+}
+
+func TestStreamMetrics(t *testing.T) {
+	m := metrics.NewRegistry()
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates"}
+	err := StreamMetrics(m)(nil, &fakeStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		return errors.New("closed")
+	})
+	if err == nil {
+		t.Fatal("expected the handler error")
+	}
+
+	if n := m.Counter("grpc.calls /Currency/SubscribeRates"); n != 1 {
+		t.Errorf("expected 1 stream got %d", n)
+	}
+	if n := m.Counter("grpc.codes /Currency/SubscribeRates Unknown"); n != 1 {
+		t.Errorf("expected the error to be counted as Unknown got %d", n)
+	}
+}
+
+func TestChainUnary(t *testing.T) {
+	var order []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/Currency/GetRate"}
+	resp, err := ChainUnary(interceptor("first"), interceptor("second"))(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		order = append(order, "handler")
+		return req, nil
+	})
+	if err != nil || resp != "req" {
+		t.Fatalf("expected the handler response got %v %v", resp, err)
+	}
+	if len(order) != 3 || order[0] != "first" || order[1] != "second" || order[2] != "handler" {
+		t.Fatalf("expected the interceptors to run in order got %v", order)
+	}
+}
+
+func TestChainStream(t *testing.T) {
+	auth := NewBearerTokens([]string{"product-api:secret"})
+	info := &grpc.StreamServerInfo{FullMethod: "/Currency/SubscribeRates"}
+	chain := ChainStream(StreamClientID(), StreamAuth(testLogger(), auth, nil))
+
+	var id, principal string
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		id, principal = ClientIDFromContext(ss.Context()), PrincipalFromContext(ss.Context())
+		return nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret", ClientIDHeader, "checkout"))
+	if err := chain(nil, &fakeStream{ctx: ctx}, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	if id != "checkout" || principal != "product-api" {
+		t.Fatalf("expected both interceptors to run got client %q principal %q", id, principal)
+	}
+}
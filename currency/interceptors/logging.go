@@ -0,0 +1,57 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryLogging logs every unary call with its peer, client ID, duration and status code.
+func UnaryLogging(l *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(l, ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamLogging logs every stream with its peer, client ID, duration and status
+// code once the stream ends.
+func StreamLogging(l *logrus.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		l.WithFields(callFields(ss.Context(), info.FullMethod)).Info("stream started")
+		err := handler(srv, ss)
+		logCall(l, ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+func callFields(ctx context.Context, method string) logrus.Fields {
+	fields := logrus.Fields{
+		"grpc.method": method,
+		"client_id":   ClientIDFromContext(ctx),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields["peer"] = p.Addr.String()
+	}
+	return fields
+}
+
+func logCall(l *logrus.Logger, ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	entry := l.WithFields(callFields(ctx, method)).WithFields(logrus.Fields{
+		"grpc.code":   code.String(),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+	})
+	if err != nil {
+		entry.WithError(err).Warn("finished call")
+		return
+	}
+	entry.Info("finished call")
+}
@@ -0,0 +1,39 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryMetrics records the latency, call count and error count per method.
+func UnaryMetrics(m *metrics.Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		record(m, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamMetrics records the duration, stream count and error count per method.
+func StreamMetrics(m *metrics.Registry) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		record(m, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func record(m *metrics.Registry, method string, start time.Time, err error) {
+	m.Observe("grpc.latency "+method, time.Since(start))
+	m.Inc("grpc.calls " + method)
+	if err != nil {
+		m.Inc("grpc.errors " + method)
+		m.Inc("grpc.codes " + method + " " + status.Code(err).String())
+	}
+}
@@ -0,0 +1,46 @@
+package interceptors
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryRecovery turns a panic in a handler into a codes.Internal error
+// instead of crashing the process.
+func UnaryRecovery(l *logrus.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(l, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery turns a panic in a stream handler into a codes.Internal error
+// instead of crashing the process.
+func StreamRecovery(l *logrus.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(l, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(l *logrus.Logger, method string, r interface{}) error {
+	l.WithFields(logrus.Fields{
+		"grpc.method": method,
+		"panic":       r,
+		"stack":       string(debug.Stack()),
+	}).Error("recovered from panic")
+	return status.Errorf(codes.Internal, "internal error")
+}
@@ -0,0 +1,149 @@
+// Package metrics keeps in-process counters and latency timers and serves
+// them as JSON.
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in milliseconds of the timer histograms.
+var latencyBuckets = []float64{1, 5, 10, 50, 100, 500, 1000, 5000}
+
+// Timer records the count and distribution of durations.
+type Timer struct {
+	Count   int64            `json:"count"`
+	SumMs   float64          `json:"sum_ms"`
+	MaxMs   float64          `json:"max_ms"`
+	Buckets map[string]int64 `json:"buckets"`
+}
+
+func (t *Timer) observe(d time.Duration) {
+	ms := float64(d.Microseconds()) / 1000
+	t.Count++
+	t.SumMs += ms
+	if ms > t.MaxMs {
+		t.MaxMs = ms
+	}
+	for _, b := range latencyBuckets {
+		if ms <= b {
+			t.Buckets[bucketName(b)]++
+			return
+		}
+	}
+	t.Buckets["+Inf"]++
+}
+
+func bucketName(b float64) string {
+	return "le_" + time.Duration(b*float64(time.Millisecond)).String()
+}
+
+// Registry holds named counters, gauges and timers.
+type Registry struct {
+	mutex    *sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	timers   map[string]*Timer
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mutex:    &sync.Mutex{},
+		counters: map[string]int64{},
+		gauges:   map[string]float64{},
+		timers:   map[string]*Timer{},
+	}
+}
+
+// Inc increments the counter with the given name.
+func (r *Registry) Inc(name string) {
+	r.Add(name, 1)
+}
+
+// Add adds delta to the counter with the given name.
+func (r *Registry) Add(name string, delta int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counters[name] += delta
+}
+
+// Set sets the gauge with the given name.
+func (r *Registry) Set(name string, v float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gauges[name] = v
+}
+
+// Observe records a duration in the timer with the given name.
+func (r *Registry) Observe(name string, d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t, ok := r.timers[name]
+	if !ok {
+		t = &Timer{Buckets: map[string]int64{}}
+		r.timers[name] = t
+	}
+	t.observe(d)
+}
+
+// Counter returns the current value of a counter.
+func (r *Registry) Counter(name string) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.counters[name]
+}
+
+// Snapshot is a copy of all metrics at a point in time.
+type Snapshot struct {
+	Counters map[string]int64   `json:"counters"`
+	Gauges   map[string]float64 `json:"gauges"`
+	Timers   map[string]Timer   `json:"timers"`
+}
+
+// Snapshot returns a copy of all metrics.
+func (r *Registry) Snapshot() Snapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := Snapshot{
+		Counters: make(map[string]int64, len(r.counters)),
+		Gauges:   make(map[string]float64, len(r.gauges)),
+		Timers:   make(map[string]Timer, len(r.timers)),
+	}
+	for k, v := range r.counters {
+		s.Counters[k] = v
+	}
+	for k, v := range r.gauges {
+		s.Gauges[k] = v
+	}
+	for k, v := range r.timers {
+		t := *v
+		t.Buckets = make(map[string]int64, len(v.Buckets))
+		for b, c := range v.Buckets {
+			t.Buckets[b] = c
+		}
+		s.Timers[k] = t
+	}
+	return s
+}
+
+// String returns the metrics as JSON, which makes the Registry an expvar.Var.
+func (r *Registry) String() string {
+	b, err := json.Marshal(r.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// ServeHTTP writes the metrics as JSON.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Snapshot())
+}
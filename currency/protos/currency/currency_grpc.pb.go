@@ -24,6 +24,15 @@ const _ = grpc.SupportPackageIsVersion7
 type CurrencyClient interface {
 	GetRate(ctx context.Context, in *RateRequest, opts ...grpc.CallOption) (*RateResponse, error)
 	SubscribeRates(ctx context.Context, opts ...grpc.CallOption) (Currency_SubscribeRatesClient, error)
+	// CreateQuote locks in the current rate for a currency pair until the quote expires
+	CreateQuote(ctx context.Context, in *CreateQuoteRequest, opts ...grpc.CallOption) (*Quote, error)
+	// GetQuote looks up a previously created quote
+	GetQuote(ctx context.Context, in *GetQuoteRequest, opts ...grpc.CallOption) (*Quote, error)
+	// RedeemQuote marks a quote as used, it fails if the quote is expired or already redeemed
+	RedeemQuote(ctx context.Context, in *RedeemQuoteRequest, opts ...grpc.CallOption) (*Quote, error)
+	// GetCandles returns the open, high, low and close mid rates of a pair per
+	// interval, oldest first. Only recent candles are kept, older ones are dropped.
+	GetCandles(ctx context.Context, in *GetCandlesRequest, opts ...grpc.CallOption) (*GetCandlesResponse, error)
 }
 
 type currencyClient struct {
@@ -74,12 +83,57 @@ func (x *currencySubscribeRatesClient) Recv() (*StreamingRateResponse, error) {
 	return m, nil
 }
 
+func (c *currencyClient) CreateQuote(ctx context.Context, in *CreateQuoteRequest, opts ...grpc.CallOption) (*Quote, error) {
+	out := new(Quote)
+	err := c.cc.Invoke(ctx, "/Currency/CreateQuote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) GetQuote(ctx context.Context, in *GetQuoteRequest, opts ...grpc.CallOption) (*Quote, error) {
+	out := new(Quote)
+	err := c.cc.Invoke(ctx, "/Currency/GetQuote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) RedeemQuote(ctx context.Context, in *RedeemQuoteRequest, opts ...grpc.CallOption) (*Quote, error) {
+	out := new(Quote)
+	err := c.cc.Invoke(ctx, "/Currency/RedeemQuote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) GetCandles(ctx context.Context, in *GetCandlesRequest, opts ...grpc.CallOption) (*GetCandlesResponse, error) {
+	out := new(GetCandlesResponse)
+	err := c.cc.Invoke(ctx, "/Currency/GetCandles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CurrencyServer is the server API for Currency service.
 // All implementations must embed UnimplementedCurrencyServer
 // for forward compatibility
 type CurrencyServer interface {
 	GetRate(context.Context, *RateRequest) (*RateResponse, error)
 	SubscribeRates(Currency_SubscribeRatesServer) error
+	// CreateQuote locks in the current rate for a currency pair until the quote expires
+	CreateQuote(context.Context, *CreateQuoteRequest) (*Quote, error)
+	// GetQuote looks up a previously created quote
+	GetQuote(context.Context, *GetQuoteRequest) (*Quote, error)
+	// RedeemQuote marks a quote as used, it fails if the quote is expired or already redeemed
+	RedeemQuote(context.Context, *RedeemQuoteRequest) (*Quote, error)
+	// GetCandles returns the open, high, low and close mid rates of a pair per
+	// interval, oldest first. Only recent candles are kept, older ones are dropped.
+	GetCandles(context.Context, *GetCandlesRequest) (*GetCandlesResponse, error)
 	mustEmbedUnimplementedCurrencyServer()
 }
 
@@ -93,6 +147,18 @@ func (UnimplementedCurrencyServer) GetRate(context.Context, *RateRequest) (*Rate
 func (UnimplementedCurrencyServer) SubscribeRates(Currency_SubscribeRatesServer) error {
 	return status.Errorf(codes.Unimplemented, "method SubscribeRates not implemented")
 }
+func (UnimplementedCurrencyServer) CreateQuote(context.Context, *CreateQuoteRequest) (*Quote, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateQuote not implemented")
+}
+func (UnimplementedCurrencyServer) GetQuote(context.Context, *GetQuoteRequest) (*Quote, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuote not implemented")
+}
+func (UnimplementedCurrencyServer) RedeemQuote(context.Context, *RedeemQuoteRequest) (*Quote, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RedeemQuote not implemented")
+}
+func (UnimplementedCurrencyServer) GetCandles(context.Context, *GetCandlesRequest) (*GetCandlesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCandles not implemented")
+}
 func (UnimplementedCurrencyServer) mustEmbedUnimplementedCurrencyServer() {}
 
 // UnsafeCurrencyServer may be embedded to opt out of forward compatibility for this service.
@@ -150,6 +216,78 @@ func (x *currencySubscribeRatesServer) Recv() (*RateRequest, error) {
 	return m, nil
 }
 
+func _Currency_CreateQuote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateQuoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).CreateQuote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Currency/CreateQuote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).CreateQuote(ctx, req.(*CreateQuoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_GetQuote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQuoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).GetQuote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Currency/GetQuote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).GetQuote(ctx, req.(*GetQuoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_RedeemQuote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RedeemQuoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).RedeemQuote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Currency/RedeemQuote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).RedeemQuote(ctx, req.(*RedeemQuoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_GetCandles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCandlesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).GetCandles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Currency/GetCandles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).GetCandles(ctx, req.(*GetCandlesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Currency_ServiceDesc is the grpc.ServiceDesc for Currency service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,6 +299,22 @@ var Currency_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetRate",
 			Handler:    _Currency_GetRate_Handler,
 		},
+		{
+			MethodName: "CreateQuote",
+			Handler:    _Currency_CreateQuote_Handler,
+		},
+		{
+			MethodName: "GetQuote",
+			Handler:    _Currency_GetQuote_Handler,
+		},
+		{
+			MethodName: "RedeemQuote",
+			Handler:    _Currency_RedeemQuote_Handler,
+		},
+		{
+			MethodName: "GetCandles",
+			Handler:    _Currency_GetCandles_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -172,3 +326,167 @@ var Currency_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "currency.proto",
 }
+
+// CurrencyAdminClient is the client API for CurrencyAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CurrencyAdminClient interface {
+	// SetRateOverride replaces the market rate of a pair until expires_at, or until cleared when unset
+	SetRateOverride(ctx context.Context, in *SetRateOverrideRequest, opts ...grpc.CallOption) (*RateOverride, error)
+	// ClearRateOverride removes the override of a pair
+	ClearRateOverride(ctx context.Context, in *ClearRateOverrideRequest, opts ...grpc.CallOption) (*RateOverride, error)
+	// ListOverrides returns the overrides which are in effect
+	ListOverrides(ctx context.Context, in *ListOverridesRequest, opts ...grpc.CallOption) (*ListOverridesResponse, error)
+}
+
+type currencyAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCurrencyAdminClient(cc grpc.ClientConnInterface) CurrencyAdminClient {
+	return &currencyAdminClient{cc}
+}
+
+func (c *currencyAdminClient) SetRateOverride(ctx context.Context, in *SetRateOverrideRequest, opts ...grpc.CallOption) (*RateOverride, error) {
+	out := new(RateOverride)
+	err := c.cc.Invoke(ctx, "/CurrencyAdmin/SetRateOverride", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyAdminClient) ClearRateOverride(ctx context.Context, in *ClearRateOverrideRequest, opts ...grpc.CallOption) (*RateOverride, error) {
+	out := new(RateOverride)
+	err := c.cc.Invoke(ctx, "/CurrencyAdmin/ClearRateOverride", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyAdminClient) ListOverrides(ctx context.Context, in *ListOverridesRequest, opts ...grpc.CallOption) (*ListOverridesResponse, error) {
+	out := new(ListOverridesResponse)
+	err := c.cc.Invoke(ctx, "/CurrencyAdmin/ListOverrides", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CurrencyAdminServer is the server API for CurrencyAdmin service.
+// All implementations must embed UnimplementedCurrencyAdminServer
+// for forward compatibility
+type CurrencyAdminServer interface {
+	// SetRateOverride replaces the market rate of a pair until expires_at, or until cleared when unset
+	SetRateOverride(context.Context, *SetRateOverrideRequest) (*RateOverride, error)
+	// ClearRateOverride removes the override of a pair
+	ClearRateOverride(context.Context, *ClearRateOverrideRequest) (*RateOverride, error)
+	// ListOverrides returns the overrides which are in effect
+	ListOverrides(context.Context, *ListOverridesRequest) (*ListOverridesResponse, error)
+	mustEmbedUnimplementedCurrencyAdminServer()
+}
+
+// UnimplementedCurrencyAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedCurrencyAdminServer struct {
+}
+
+func (UnimplementedCurrencyAdminServer) SetRateOverride(context.Context, *SetRateOverrideRequest) (*RateOverride, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRateOverride not implemented")
+}
+func (UnimplementedCurrencyAdminServer) ClearRateOverride(context.Context, *ClearRateOverrideRequest) (*RateOverride, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearRateOverride not implemented")
+}
+func (UnimplementedCurrencyAdminServer) ListOverrides(context.Context, *ListOverridesRequest) (*ListOverridesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOverrides not implemented")
+}
+func (UnimplementedCurrencyAdminServer) mustEmbedUnimplementedCurrencyAdminServer() {}
+
+// UnsafeCurrencyAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CurrencyAdminServer will
+// result in compilation errors.
+type UnsafeCurrencyAdminServer interface {
+	mustEmbedUnimplementedCurrencyAdminServer()
+}
+
+func RegisterCurrencyAdminServer(s grpc.ServiceRegistrar, srv CurrencyAdminServer) {
+	s.RegisterService(&CurrencyAdmin_ServiceDesc, srv)
+}
+
+func _CurrencyAdmin_SetRateOverride_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRateOverrideRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyAdminServer).SetRateOverride(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/CurrencyAdmin/SetRateOverride",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyAdminServer).SetRateOverride(ctx, req.(*SetRateOverrideRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CurrencyAdmin_ClearRateOverride_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearRateOverrideRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyAdminServer).ClearRateOverride(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/CurrencyAdmin/ClearRateOverride",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyAdminServer).ClearRateOverride(ctx, req.(*ClearRateOverrideRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CurrencyAdmin_ListOverrides_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOverridesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyAdminServer).ListOverrides(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/CurrencyAdmin/ListOverrides",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyAdminServer).ListOverrides(ctx, req.(*ListOverridesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CurrencyAdmin_ServiceDesc is the grpc.ServiceDesc for CurrencyAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CurrencyAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "CurrencyAdmin",
+	HandlerType: (*CurrencyAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetRateOverride",
+			Handler:    _CurrencyAdmin_SetRateOverride_Handler,
+		},
+		{
+			MethodName: "ClearRateOverride",
+			Handler:    _CurrencyAdmin_ClearRateOverride_Handler,
+		},
+		{
+			MethodName: "ListOverrides",
+			Handler:    _CurrencyAdmin_ListOverrides_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "currency.proto",
+}
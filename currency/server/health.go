@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	config "github.com/samims/ecommerceGO/currency/configs"
+	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/samims/ecommerceGO/currency/data"
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	protosv2 "github.com/samims/ecommerceGO/currency/protos/currency/v2"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthChecker keeps the grpc.health.v1 status of the server in line with
+// the freshness of the exchange rates. The server stays SERVING with snapshot
+// rates until they are older than the max age, constants.LiveRatesService tells
+// whether the rates are live.
+type healthChecker struct {
+	log      *logrus.Logger
+	rates    *data.ExchangeRates
+	hs       *health.Server
+	maxAge   time.Duration
+	interval time.Duration
+	status   healthpb.HealthCheckResponse_ServingStatus
+	live     healthpb.HealthCheckResponse_ServingStatus
+}
+
+func newHealthChecker(l *logrus.Logger, rates *data.ExchangeRates, hs *health.Server, maxAge time.Duration) *healthChecker {
+	hc := &healthChecker{
+		log:      l,
+		rates:    rates,
+		hs:       hs,
+		maxAge:   maxAge,
+		interval: time.Second,
+		status:   healthpb.HealthCheckResponse_SERVICE_UNKNOWN,
+		live:     healthpb.HealthCheckResponse_SERVICE_UNKNOWN,
+	}
+	hc.check()
+	return hc
+}
+
+// run re-evaluates the status every interval until the context is done.
+func (h *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check reports NOT_SERVING until the first rates have been loaded and
+// whenever the last successful fetch is older than maxAge, and SERVING
+// otherwise. The simulation moving the rates doesn't make them fresh, so a
+// stalled provider is noticed. A zero maxAge disables the age check, for
+// rates which are only fetched at startup.
+func (h *healthChecker) check() {
+	h.checkLive()
+
+	st := healthpb.HealthCheckResponse_SERVING
+	refreshedAt := h.rates.LastRefreshed()
+	if refreshedAt.IsZero() || (h.maxAge > 0 && time.Since(refreshedAt) > h.maxAge) {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	if st == h.status {
+		return
+	}
+
+	h.log.Infof("health status changed from %s to %s, rates fetched at %s", h.status, st, refreshedAt)
+	h.status = st
+	// the empty service name is the overall health of the server
+	h.hs.SetServingStatus("", st)
+	h.hs.SetServingStatus(protos.Currency_ServiceDesc.ServiceName, st)
+	h.hs.SetServingStatus(protosv2.Currency_ServiceDesc.ServiceName, st)
+}
+
+// checkLive reports on constants.LiveRatesService whether the rates are stale.
+func (h *healthChecker) checkLive() {
+	st := healthpb.HealthCheckResponse_SERVING
+	stale, fetchedAt := h.rates.Stale()
+	if stale {
+		st = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	if st == h.live {
+		return
+	}
+
+	h.log.Infof("live rates status changed from %s to %s, fetched at %s", h.live, st, fetchedAt)
+	h.live = st
+	h.hs.SetServingStatus(constants.LiveRatesService, st)
+}
+
+// rateMaxAge returns how old the last fetch may get before the server reports
+// NOT_SERVING. A configured max age always applies. Without one a fetch may be
+// missed before polled rates count as stale, and rates which aren't polled
+// aren't checked, they are only fetched at startup and can't get fresher.
+func rateMaxAge(cfg config.Env) time.Duration {
+	if maxAge := cfg.GetDuration(constants.EnvRateMaxAge); maxAge > 0 {
+		return maxAge
+	}
+	poll := cfg.GetDuration(constants.EnvRatePollInterval)
+	if poll <= 0 {
+		return 0
+	}
+	if constants.DefaultRateMaxAge > 2*poll {
+		return constants.DefaultRateMaxAge
+	}
+	return 2 * poll
+}
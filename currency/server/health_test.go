@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+)
+
+// testEnv is a config.Env backed by a map
+type testEnv map[string]interface{}
+
+func (e testEnv) Get(key string) interface{}    { return e[key] }
+func (e testEnv) GetString(key string) string   { s, _ := e[key].(string); return s }
+func (e testEnv) GetInt(key string) int         { i, _ := e[key].(int); return i }
+func (e testEnv) GetBool(key string) bool       { b, _ := e[key].(bool); return b }
+func (e testEnv) GetFloat64(key string) float64 { f, _ := e[key].(float64); return f }
+func (e testEnv) GetDuration(key string) time.Duration {
+	d, _ := e[key].(time.Duration)
+	return d
+}
+func (e testEnv) GetStringSlice(key string) []string { s, _ := e[key].([]string); return s }
+
+func TestRateMaxAge(t *testing.T) {
+	tests := []struct {
+		name string
+		env  testEnv
+		want time.Duration
+	}{
+		{"not polled", testEnv{}, 0},
+		{"not polled with max age", testEnv{constants.EnvRateMaxAge: time.Hour}, time.Hour},
+		{"polled", testEnv{constants.EnvRatePollInterval: time.Second}, constants.DefaultRateMaxAge},
+		{"polled slowly", testEnv{constants.EnvRatePollInterval: time.Hour}, 2 * time.Hour},
+		{"polled with max age", testEnv{constants.EnvRatePollInterval: time.Hour, constants.EnvRateMaxAge: time.Minute}, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := rateMaxAge(tt.env); got != tt.want {
+			t.Errorf("%s: expected %s got %s", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strings"
+
+	config "github.com/samims/ecommerceGO/currency/configs"
+	"github.com/samims/ecommerceGO/currency/constants"
+	"github.com/samims/ecommerceGO/currency/interceptors"
+	"github.com/samims/ecommerceGO/currency/metrics"
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// serverInterceptors builds the interceptor chains of the gRPC server and the
+// REST gateway from the toggles in the config. The client ID is always set,
+// so that the other interceptors and the handlers can use it. Logging and
+// metrics wrap recovery so that recovered panics are logged and counted as
+// Internal errors.
+func serverInterceptors(cfg config.Env, log *logrus.Logger, m *metrics.Registry) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	unary := []grpc.UnaryServerInterceptor{interceptors.UnaryClientID()}
+	stream := []grpc.StreamServerInterceptor{interceptors.StreamClientID()}
+
+	if cfg.GetBool(constants.EnvGrpcLogging) {
+		unary = append(unary, interceptors.UnaryLogging(log))
+		stream = append(stream, interceptors.StreamLogging(log))
+	}
+	if cfg.GetBool(constants.EnvGrpcMetrics) {
+		unary = append(unary, interceptors.UnaryMetrics(m))
+		stream = append(stream, interceptors.StreamMetrics(m))
+	}
+	if cfg.GetBool(constants.EnvGrpcRecovery) {
+		unary = append(unary, interceptors.UnaryRecovery(log))
+		stream = append(stream, interceptors.StreamRecovery(log))
+	}
+	if cfg.GetBool(constants.EnvGrpcAuth) {
+		auth := interceptors.NewBearerTokens(authTokens(cfg))
+		// health checks and reflection stay open, the admin service checks its own token
+		skip := interceptors.SkipServices(
+			healthpb.Health_ServiceDesc.ServiceName,
+			reflectionpb.ServerReflection_ServiceDesc.ServiceName,
+			protos.CurrencyAdmin_ServiceDesc.ServiceName,
+		)
+		unary = append(unary, interceptors.UnaryAuth(log, auth, skip))
+		stream = append(stream, interceptors.StreamAuth(log, auth, skip))
+	}
+
+	return unary, stream
+}
+
+// authTokens reads the tokens, viper only splits slices set in the config
+// file, so values from the environment are split here.
+func authTokens(cfg config.Env) []string {
+	tokens := []string{}
+	for _, v := range cfg.GetStringSlice(constants.EnvAuthTokens) {
+		tokens = append(tokens, strings.Split(v, ",")...)
+	}
+	return tokens
+}
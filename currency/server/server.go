@@ -4,43 +4,163 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"time"
 
+	"github.com/samims/ecommerceGO/currency/certs"
 	"github.com/samims/ecommerceGO/currency/configs"
 	"github.com/samims/ecommerceGO/currency/constants"
 	"github.com/samims/ecommerceGO/currency/data"
+	"github.com/samims/ecommerceGO/currency/gateway"
 	"github.com/samims/ecommerceGO/currency/handlers"
+	"github.com/samims/ecommerceGO/currency/interceptors"
+	"github.com/samims/ecommerceGO/currency/metrics"
 	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	protosv2 "github.com/samims/ecommerceGO/currency/protos/currency/v2"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
 type Server struct {
-	cfg   config.Env
-	log   *logrus.Logger
-	rates *data.ExchangeRates
-	gs    *grpc.Server
+	cfg    config.Env
+	log    *logrus.Logger
+	rates  *data.ExchangeRates
+	gs     *grpc.Server
+	hs     *health.Server
+	ms     *http.Server
+	gw     *http.Server
+	certs  *certs.Reloader
+	cancel context.CancelFunc
 }
 
-func NewServer(cfg config.Env, log *logrus.Logger, rates *data.ExchangeRates) (*Server, error) {
+func NewServer(
+	cfg config.Env,
+	log *logrus.Logger,
+	rates *data.ExchangeRates,
+	quotes *data.Quotes,
+	pricing *data.Pricing,
+	overrides *data.Overrides,
+	candles *data.Candles,
+	m *metrics.Registry,
+) (*Server, error) {
 
-	gs := grpc.NewServer()
+	unary, stream := serverInterceptors(cfg, log, m)
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+
+	cr, err := tlsReloader(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	if cr != nil {
+		tlsCfg := certs.ServerConfig(cr, cfg.GetBool(constants.EnvTLSClientAuth))
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
 
-	cs := handlers.NewCurrency(context.Background(), log, rates)
+	gs := grpc.NewServer(opts...)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the rate updates of every consumer run until the server stops
+	go rates.Monitor(ctx)
+
+	cs := handlers.NewCurrency(ctx, log, rates, quotes, pricing, candles)
 	protos.RegisterCurrencyServer(gs, cs)
+	// v2 identifies currencies by ISO code, v1 stays for existing clients
+	protosv2.RegisterCurrencyServer(gs, handlers.NewCurrencyV2(log, cs))
+
+	as := handlers.NewAdmin(log, overrides, cfg.GetString(constants.EnvAdminToken))
+	protos.RegisterCurrencyAdminServer(gs, as)
+
+	// standard grpc.health.v1 service, SERVING only while the rates are fresh
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(gs, hs)
+	go newHealthChecker(log, rates, hs, rateMaxAge(cfg)).run(ctx)
+
+	// a snapshot served at startup is replaced once the live source is back
+	retry := cfg.GetDuration(constants.EnvRateRetryInterval)
+	if retry == 0 {
+		retry = constants.DefaultRateRetryInterval
+	}
+	go rates.RetryLive(ctx, retry)
+
+	if poll := cfg.GetDuration(constants.EnvRatePollInterval); poll > 0 {
+		go rates.Poll(ctx, poll)
+	}
+
+	// without candles GetCandles is Unimplemented
+	if candles != nil {
+		flush := cfg.GetDuration(constants.EnvCandleFlushInterval)
+		if flush == 0 {
+			flush = constants.DefaultCandleFlushInterval
+		}
+		go candles.Run(ctx, flush)
+	}
+
 	reflection.Register(gs)
 
+	var gw *http.Server
+	if port := cfg.GetString(constants.EnvGatewayPort); port != "" {
+		// gateway requests are authenticated, logged and counted like gRPC calls
+		g := gateway.NewGateway(log, cs, as, interceptors.ChainUnary(unary...), interceptors.ChainStream(stream...))
+		gw = &http.Server{
+			Addr:    ":" + port,
+			Handler: g.Router(),
+			// event streams end when the server is stopped
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		}
+		if cr != nil {
+			gw.TLSConfig = certs.ServerConfig(cr, cfg.GetBool(constants.EnvTLSClientAuth))
+		}
+	}
+
+	var ms *http.Server
+	if addr := cfg.GetString(constants.EnvMetricsAddr); addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		ms = &http.Server{Addr: addr, Handler: mux}
+	}
+
 	return &Server{
-		cfg:   cfg,
-		log:   log,
-		rates: rates,
-		gs:    gs,
+		cfg:    cfg,
+		log:    log,
+		rates:  rates,
+		gs:     gs,
+		hs:     hs,
+		ms:     ms,
+		gw:     gw,
+		certs:  cr,
+		cancel: cancel,
 	}, nil
 }
 
+// tlsReloader loads the server certificate, it returns nil when TLS is not
+// configured and the server listens in plaintext.
+func tlsReloader(cfg config.Env, log *logrus.Logger) (*certs.Reloader, error) {
+	certFile := cfg.GetString(constants.EnvTLSCertFile)
+	if certFile == "" {
+		log.Warn("TLS is not configured, serving plaintext")
+		return nil, nil
+	}
+	caFile := cfg.GetString(constants.EnvTLSCAFile)
+	if cfg.GetBool(constants.EnvTLSClientAuth) && caFile == "" {
+		return nil, fmt.Errorf("%s is required to verify client certificates", constants.EnvTLSCAFile)
+	}
+
+	cr, err := certs.NewReloader(log, certFile, cfg.GetString(constants.EnvTLSKeyFile), caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load TLS certificates: %s", err)
+	}
+	return cr, nil
+}
+
 func (s *Server) Start() error {
 	portStr := s.cfg.GetString(constants.EnvPort)
 	l, err := net.Listen("tcp", fmt.Sprintf(":%s", portStr))
@@ -49,6 +169,32 @@ func (s *Server) Start() error {
 	}
 	s.log.Info("Serving on port ", portStr)
 
+	if s.ms != nil {
+		go func() {
+			s.log.Info("Serving metrics on ", s.ms.Addr)
+			if err := s.ms.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.log.Error("unable to serve metrics ", err)
+			}
+		}()
+	}
+
+	if s.gw != nil {
+		go func() {
+			s.log.Info("Serving REST gateway on ", s.gw.Addr)
+			var err error
+			if s.gw.TLSConfig != nil {
+				// no certificate files, certs.ServerConfig sets GetCertificate
+				// to serve the reloaded key pair
+				err = s.gw.ListenAndServeTLS("", "")
+			} else {
+				err = s.gw.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				s.log.Error("unable to serve REST gateway ", err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 
@@ -100,6 +246,19 @@ func (s *Server) Start() error {
 func (s *Server) Stop(ctx context.Context) error {
 	s.log.Info("Stopping the server")
 
+	// tell clients doing health checks to move away before the connections close
+	s.hs.Shutdown()
+	s.cancel()
+	if s.gw != nil {
+		s.gw.Shutdown(ctx)
+	}
+	if s.ms != nil {
+		s.ms.Shutdown(ctx)
+	}
+	if s.certs != nil {
+		s.certs.Close()
+	}
+
 	// Stop the gRPC server
 	s.gs.Stop()
 
@@ -10,6 +10,7 @@ type AppConfig interface {
 	GetImageDir() string
 	GetMediaURL() string
 	GetCurrencyServerBase() string
+	GetProductImagesBase() string
 }
 
 type appConfig struct {
@@ -17,14 +18,16 @@ type appConfig struct {
 	ImageDIR           string   `mapstructure:"image_dir"`
 	MediaURL           string   `mapstructure:"media_url"`
 	CurrencyServerBase string   `mapstructure:"currency_server_base"`
+	ProductImagesBase  string   `mapstructure:"product_images_base"`
 }
 
-func NewAppConfig(allowedHosts []string, imageDIR, mediaURL, currencyServerBase string) AppConfig {
+func NewAppConfig(allowedHosts []string, imageDIR, mediaURL, currencyServerBase, productImagesBase string) AppConfig {
 	appCfg := &appConfig{
 		AllowedHosts:       allowedHosts,
 		ImageDIR:           imageDIR,
 		MediaURL:           mediaURL,
 		CurrencyServerBase: currencyServerBase,
+		ProductImagesBase:  productImagesBase,
 	}
 	return appCfg
 }
@@ -44,3 +47,7 @@ func (a appConfig) GetMediaURL() string {
 func (a appConfig) GetCurrencyServerBase() string {
 	return viper.GetString("CURRENCY_SERVER_BASE")
 }
+
+func (a appConfig) GetProductImagesBase() string {
+	return viper.GetString("PRODUCT_IMAGES_BASE")
+}
@@ -7,4 +7,17 @@ const (
 	LogLevel           = "LOG_LEVEL"
 	ImageDir           = "IMAGE_DIR"
 	MediaURL           = "MEDIA_URL"
+	CurrencyToken      = "CURRENCY_TOKEN"
+	// TLS to the currency service is enabled when a CA file is configured,
+	// the key pair is only needed when the currency service requires mTLS
+	CurrencyTLSCAFile     = "CURRENCY_TLS_CA_FILE"
+	CurrencyTLSCertFile   = "CURRENCY_TLS_CERT_FILE"
+	CurrencyTLSKeyFile    = "CURRENCY_TLS_KEY_FILE"
+	CurrencyTLSServerName = "CURRENCY_TLS_SERVER_NAME"
+	// base URL of the product-images service, e.g. http://localhost:8080,
+	// without it the image routes are off
+	ProductImagesBase = "PRODUCT_IMAGES_BASE"
+	// token product-images requires to sign the URLs of private images and
+	// to claim and release images
+	ProductImagesToken = "PRODUCT_IMAGES_TOKEN"
 )
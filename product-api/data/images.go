@@ -0,0 +1,67 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var ErrImageAlreadyAdded = fmt.Errorf("image already added to the product")
+
+// ProductImage references an image stored by product-images
+// swagger:model
+type ProductImage struct {
+	// the id of the image in product-images
+	//
+	// required: true
+	ID string `json:"id" validate:"required"`
+	// the primary image is shown first, a product has at most one
+	Primary bool   `json:"primary"`
+	Alt     string `json:"alt,omitempty" validate:"max=250"`
+	// URL, Width and Height are only set when the images are expanded
+	URL    string `json:"url,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+func (i *ProductImage) Validate() error {
+	return validator.New().Struct(i)
+}
+
+// AddImage inserts the image into the images of the product at position,
+// it is appended when position is out of range. The first image of a product
+// is its primary image, and a new primary image replaces the former one.
+func AddImage(productID int, img ProductImage, position int) (*Product, error) {
+	idx := findIndexByProductID(productID)
+	if idx == -1 {
+		return nil, ErrProductNotFound
+	}
+	p := productList[idx]
+	for _, i := range p.Images {
+		if i.ID == img.ID {
+			return nil, ErrImageAlreadyAdded
+		}
+	}
+
+	img.URL, img.Width, img.Height = "", 0, 0
+	if len(p.Images) == 0 {
+		img.Primary = true
+	}
+	// the list is copied so that readers of the former list aren't affected
+	images := make([]ProductImage, 0, len(p.Images)+1)
+	for _, i := range p.Images {
+		if img.Primary {
+			i.Primary = false
+		}
+		images = append(images, i)
+	}
+	if position < 0 || position > len(images) {
+		position = len(images)
+	}
+	images = append(images[:position], append([]ProductImage{img}, images[position:]...)...)
+
+	np := *p
+	np.Images = images
+	productList[idx] = &np
+	return &np, nil
+}
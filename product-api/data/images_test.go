@@ -0,0 +1,37 @@
+package data
+
+import "testing"
+
+func TestAddImage(t *testing.T) {
+	saved := productList
+	defer func() { productList = saved }()
+	productList = []*Product{{ID: 1, Name: "Latte"}}
+
+	if _, err := AddImage(1, ProductImage{ID: "a.png"}, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddImage(1, ProductImage{ID: "b.png"}, -1); err != nil {
+		t.Fatal(err)
+	}
+	p, err := AddImage(1, ProductImage{ID: "c.png", Primary: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ProductImage{{ID: "c.png", Primary: true}, {ID: "a.png"}, {ID: "b.png"}}
+	if len(p.Images) != len(want) {
+		t.Fatalf("expected %d images got %v", len(want), p.Images)
+	}
+	for i := range want {
+		if p.Images[i] != want[i] {
+			t.Errorf("expected image %d to be %+v got %+v", i, want[i], p.Images[i])
+		}
+	}
+
+	if _, err := AddImage(1, ProductImage{ID: "a.png"}, -1); err != ErrImageAlreadyAdded {
+		t.Errorf("expected ErrImageAlreadyAdded got %v", err)
+	}
+	if _, err := AddImage(2, ProductImage{ID: "a.png"}, -1); err != ErrProductNotFound {
+		t.Errorf("expected ErrProductNotFound got %v", err)
+	}
+}
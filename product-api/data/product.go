@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"sync"
 	"time"
 
 	protos "github.com/samims/ecommerceGO/currency/protos/currency"
@@ -33,6 +34,13 @@ type Product struct {
 	CreatedOn   string  `json:"-"`
 	UpdatedOn   string  `json:"-"`
 	DeletedOn   string  `json:"-"`
+	// the id of the currency quote the price was converted with, the converted
+	// price is guaranteed until QuoteExpiresAt
+	QuoteID        string     `json:"quote_id,omitempty"`
+	QuoteExpiresAt *time.Time `json:"quote_expires_at,omitempty"`
+	// the images of the product in display order, they are added through
+	// POST /{id}/images and kept when the product is updated
+	Images []ProductImage `json:"images,omitempty"`
 }
 
 type Products []*Product
@@ -40,15 +48,52 @@ type Products []*Product
 type ProductsDB struct {
 	currency protos.CurrencyClient
 	log      *logrus.Logger
-	rates    map[string]float64
-	client   protos.Currency_SubscribeRatesClient
+	mutex    *sync.RWMutex
+	rates    map[string]*cachedRate
+	// client is the open rate subscription, nil while it is reopened.
+	// streamMutex guards it, sends on a stream must not run concurrently.
+	client      protos.Currency_SubscribeRatesClient
+	streamMutex *sync.Mutex
+	// minBackoff and maxBackoff bound the wait before resubscribing
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// cachedRate is a rate received from the currency service along with when and
+// where it was observed.
+type cachedRate struct {
+	rate      float64
+	timestamp time.Time
+	source    string
+	sequence  uint64
+}
+
+func newCachedRate(resp *protos.RateResponse) *cachedRate {
+	return &cachedRate{
+		rate:      resp.GetRate(),
+		timestamp: resp.GetTimestamp().AsTime(),
+		source:    resp.GetSource(),
+		sequence:  resp.GetSequence(),
+	}
+}
+
+// outOfOrder reports whether c is an older update than the cached rate.
+// Sequence numbers restart with the currency service, so a lower sequence
+// number with a newer timestamp is a new update after a restart.
+func (c *cachedRate) outOfOrder(cached *cachedRate) bool {
+	return c.sequence < cached.sequence && !c.timestamp.After(cached.timestamp)
 }
 
 func NewProductsDB(c protos.CurrencyClient, l *logrus.Logger) *ProductsDB {
 	pdb := &ProductsDB{
 		currency: c,
 		log:      l,
-		rates:    make(map[string]float64),
+		mutex:    &sync.RWMutex{},
+		rates:    make(map[string]*cachedRate),
+
+		streamMutex: &sync.Mutex{},
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
 	}
 
 	go pdb.handleUpdates()
@@ -56,34 +101,92 @@ func NewProductsDB(c protos.CurrencyClient, l *logrus.Logger) *ProductsDB {
 	return pdb
 }
 
+// handleUpdates keeps a rate subscription open and caches the rates it
+// receives. The currency service refuses subscriptions while it isn't
+// serving and streams end with the connection, so the subscription is
+// reopened with an exponential backoff until it succeeds.
 func (p *ProductsDB) handleUpdates() {
-	subscribedClient, err := p.currency.SubscribeRates(context.Background())
+	backoff := p.minBackoff
+	for {
+		received, err := p.subscribe()
+		if received {
+			backoff = p.minBackoff
+		}
+		p.log.Errorf("rate subscription ended, resubscribing in %s: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// subscribe opens a rate subscription, subscribes it to the cached rates and
+// caches the updates until the stream fails. It reports whether any update
+// was received.
+func (p *ProductsDB) subscribe() (bool, error) {
+	client, err := p.currency.SubscribeRates(context.Background())
 	if err != nil {
-		p.log.Error("unable to subscribe for rates ", " error ", err)
+		return false, fmt.Errorf("unable to subscribe for rates: %w", err)
 	}
-	p.client = subscribedClient
 
-	for {
-		rr, err := subscribedClient.Recv()
-		if err == io.EOF {
-			p.log.Error("eof receiving message ", " error ", err)
-			break
+	p.mutex.RLock()
+	requests := make([]*protos.RateRequest, 0, len(p.rates))
+	for dest := range p.rates {
+		requests = append(requests, sellRateRequest(dest))
+	}
+	p.mutex.RUnlock()
+
+	// the new stream knows nothing about the previous subscriptions
+	p.streamMutex.Lock()
+	for _, rr := range requests {
+		if err := client.Send(rr); err != nil {
+			p.streamMutex.Unlock()
+			return false, fmt.Errorf("unable to resubscribe to %s: %w", rr.Destination, err)
 		}
+	}
+	p.client = client
+	p.streamMutex.Unlock()
+
+	defer func() {
+		p.streamMutex.Lock()
+		p.client = nil
+		p.streamMutex.Unlock()
+	}()
+
+	received := false
+	for {
+		rr, err := client.Recv()
 		if err != nil {
-			p.log.Errorf("error receiving message: %v", err)
-			continue
+			return received, err
 		}
+		received = true
 		if grpcError := rr.GetError(); grpcError != nil {
-			p.log.Errorf("error subscribing for rate  error: %s", err)
+			p.log.Errorf("error subscribing for rate error: %s", grpcError.GetMessage())
 			continue
 		}
 		if resp := rr.GetRateResponse(); resp != nil {
-			p.log.Info("received updated rate ", " dest ", resp.Destination.String(), " ", resp.Rate)
-			p.rates[rr.GetRateResponse().Destination.String()] = resp.Rate
+			p.log.Info("received updated rate ", " dest ", resp.Destination.String(), " ", resp.Rate, " sequence ", resp.Sequence)
+			p.cacheRate(resp)
 		}
 	}
 }
 
+// subscribeRate asks the open subscription for updates of the rate. Without
+// an open subscription the rate is subscribed once it is reopened, as it is
+// cached by then.
+func (p *ProductsDB) subscribeRate(rr *protos.RateRequest) {
+	p.streamMutex.Lock()
+	defer p.streamMutex.Unlock()
+
+	if p.client == nil {
+		return
+	}
+	if err := p.client.Send(rr); err != nil {
+		p.log.Errorf("unable to subscribe to %s: %s", rr.Destination, err)
+	}
+}
+
 // ProductResponseWrapper is list of product in response
 // swagger:response ProductResponseWrapper
 type ProductResponseWrapper struct {
@@ -126,23 +229,25 @@ func (p *Product) FromJSON(r io.Reader) error {
 // If currency is empty, returns the original product list. Otherwise, it gets the
 // exchange rate using getRate and applies it to each product's price to create a new
 // list. May modify the ProductsDB state if getRate is called.
+// When withQuote is set the rate is locked in with a quote from the currency service
+// and the quote id is returned with every product.
 // Parameters:
 //
-//	id (int): The ID of the product to retrieve.
 //	currency (string): The currency to use for the product price (optional).
+//	withQuote (bool): Whether to lock in the converted price with a quote.
 //
 // Returns:
 //
 //	*Product: The product object.
 //	error: Returns an error if the product is not found or if an error occurred.
-func (p *ProductsDB) GetProducts(currency string) (Products, error) {
+func (p *ProductsDB) GetProducts(currency string, withQuote bool) (Products, error) {
 	// If the currency parameter is empty, return the original productList.
 	if currency == "" {
 		return productList, nil
 	}
 
 	// Otherwise, retrieve the exchange rate for the given currency.
-	rate, err := p.getRate(currency)
+	rate, quote, err := p.convert(currency, withQuote)
 
 	if err != nil {
 		p.log.Error("unable to get rate currency", currency, "error", err)
@@ -156,6 +261,7 @@ func (p *ProductsDB) GetProducts(currency string) (Products, error) {
 	for _, p := range productList {
 		np := *p
 		np.Price = np.Price * rate
+		np.setQuote(quote)
 		pr = append(pr, &np)
 	}
 	return pr, nil
@@ -169,11 +275,12 @@ func (p *ProductsDB) GetProducts(currency string) (Products, error) {
 // Parameters:
 // id (int): The ID of the product to retrieve.
 // currency (string): The currency in which to retrieve the product's price.
+// withQuote (bool): Whether to lock in the converted price with a quote.
 //
 // Returns:
 // (*Product): A pointer to the retrieved product object.
 // error: Returns an error if the product is not found or if there's an issue with the currency rate conversion.
-func (p *ProductsDB) GetProductByID(id int, currency string) (*Product, error) {
+func (p *ProductsDB) GetProductByID(id int, currency string, withQuote bool) (*Product, error) {
 	idx := findIndexByProductID(id)
 	if idx == -1 {
 		return new(Product), ErrProductNotFound
@@ -181,7 +288,7 @@ func (p *ProductsDB) GetProductByID(id int, currency string) (*Product, error) {
 	if currency == "" {
 		return productList[idx], nil
 	}
-	rate, err := p.getRate(currency)
+	rate, quote, err := p.convert(currency, withQuote)
 	if err != nil {
 		p.log.Error("unable to get rate", currency, currency, "error", err)
 		return nil, err
@@ -190,11 +297,23 @@ func (p *ProductsDB) GetProductByID(id int, currency string) (*Product, error) {
 	// This is done to avoid modifying the original product list.
 	npObj := *productList[idx]
 	npObj.Price = npObj.Price * rate
+	npObj.setQuote(quote)
 	return &npObj, nil
 }
 
+// setQuote records the quote the product price was converted with.
+func (p *Product) setQuote(q *protos.Quote) {
+	if q == nil {
+		return
+	}
+	expiresAt := q.GetExpiresAt().AsTime()
+	p.QuoteID = q.GetId()
+	p.QuoteExpiresAt = &expiresAt
+}
+
 func AddProduct(p *Product) {
 	p.ID = getNextID()
+	p.Images = nil
 	productList = append(productList, p)
 
 }
@@ -223,6 +342,7 @@ func (p *ProductsDB) UpdateProducts(id int, pObj *Product) error {
 		return ErrProductNotFound
 	}
 	pObj.ID = id
+	pObj.Images = productList[idx].Images
 	productList[idx] = pObj
 	return nil
 
@@ -257,53 +377,130 @@ func findIndexByProductID(id int) int {
 //   - error: an error indicating whether the request to the currency service was successful
 //     or not.
 func (p *ProductsDB) getRate(destination string) (float64, error) {
-	if r, ok := p.rates[destination]; ok {
-		return r, nil
+	p.mutex.RLock()
+	r, ok := p.rates[destination]
+	p.mutex.RUnlock()
+	if ok {
+		return r.rate, nil
 	}
 	return p.fetchRate(destination)
 }
 
-func (p *ProductsDB) fetchRate(destination string) (float64, error) {
-	rr := &protos.RateRequest{
+// cacheRate stores the rate unless a later update of it is already cached,
+// it reports whether the rate was stored.
+func (p *ProductsDB) cacheRate(resp *protos.RateResponse) bool {
+	dest := resp.GetDestination().String()
+	r := newCachedRate(resp)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if cached, ok := p.rates[dest]; ok && r.outOfOrder(cached) {
+		p.log.Warnf(
+			"discarding out of order rate for %s, sequence %d at %s is older than %d at %s",
+			dest, r.sequence, r.timestamp, cached.sequence, cached.timestamp,
+		)
+		return false
+	}
+	p.rates[dest] = r
+	return true
+}
+
+// convert returns the rate to convert prices to the destination currency. When
+// withQuote is set a quote is created on the currency service and its locked
+// in rate is used instead of the cached one.
+func (p *ProductsDB) convert(destination string, withQuote bool) (float64, *protos.Quote, error) {
+	if !withQuote {
+		rate, err := p.getRate(destination)
+		return rate, nil, err
+	}
+	q, err := p.createQuote(destination)
+	if err != nil {
+		return -1, nil, err
+	}
+	return q.GetRate(), q, nil
+}
+
+func (p *ProductsDB) createQuote(destination string) (*protos.Quote, error) {
+	qr := &protos.CreateQuoteRequest{
+		Base:        protos.Currencies(protos.Currencies_value["EUR"]),
+		Destination: protos.Currencies(protos.Currencies_value[destination]),
+		Side:        protos.Side_SELL,
+	}
+
+	q, err := p.currency.CreateQuote(context.Background(), qr)
+	if err != nil {
+		if s, ok := status.FromError(err); ok {
+			return nil, fmt.Errorf(
+				"unable to create quote, base %s & destination %s: %s",
+				qr.Base.String(),
+				qr.Destination.String(),
+				s.Message(),
+			)
+		}
+		return nil, err
+	}
+	return q, nil
+}
+
+// sellRateRequest asks for the rate of the destination against EUR, catalog
+// prices are sold to customers, so it is the sell side rate.
+func sellRateRequest(destination string) *protos.RateRequest {
+	return &protos.RateRequest{
 		Base:        protos.Currencies(protos.Currencies_value["EUR"]),
 		Destination: protos.Currencies(protos.Currencies_value[destination]),
+		Side:        protos.Side_SELL,
 	}
+}
+
+func (p *ProductsDB) fetchRate(destination string) (float64, error) {
+	rr := sellRateRequest(destination)
 
 	resp, err := p.currency.GetRate(context.Background(), rr)
 	if err != nil {
 		s, ok := status.FromError(err)
-		if ok {
-			md := s.Details()[0].(*protos.RateRequest)
-			if s.Code() == codes.InvalidArgument {
-				return -1, fmt.Errorf(
-					"unable to get rate from currency server, base - %s & destination - %s is same ",
-					md.Base.String(),
-					md.Destination.String(),
-				)
-			}
+		if !ok {
+			return -1, err
+		}
+		// only the errors about the request carry it, health, auth and unknown
+		// pairs come without details
+		var md *protos.RateRequest
+		if len(s.Details()) > 0 {
+			md, _ = s.Details()[0].(*protos.RateRequest)
+		}
+		if md == nil {
+			return -1, fmt.Errorf("unable to get rate from currency server: %w", err)
+		}
+		if s.Code() == codes.InvalidArgument {
 			return -1, fmt.Errorf(
-				"%s, base %s & destination %s",
-				s.Err().Error(),
+				"unable to get rate from currency server, base - %s & destination - %s is same ",
 				md.Base.String(),
 				md.Destination.String(),
 			)
 		}
-		return -1, err
+		return -1, fmt.Errorf(
+			"%s, base %s & destination %s",
+			s.Err().Error(),
+			md.Base.String(),
+			md.Destination.String(),
+		)
 	}
 
-	p.rates[destination] = resp.Rate
-	p.client.Send(rr)
+	p.cacheRate(resp)
+	p.subscribeRate(rr)
 
 	return resp.Rate, nil
 }
 
-func DeleteProduct(id int) error {
+// DeleteProduct removes the product and returns it.
+func DeleteProduct(id int) (*Product, error) {
 	idx := findIndexByProductID(id)
 	if idx == -1 {
-		return ErrProductNotFound
+		return nil, ErrProductNotFound
 	}
+	p := productList[idx]
 	productList = append(productList[:idx], productList[idx+1:]...)
-	return nil
+	return p, nil
 
 }
 
@@ -1,6 +1,20 @@
 package data
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
 
 func TestCheckValidation(t *testing.T) {
 	p := &Product{
@@ -15,3 +29,150 @@ func TestCheckValidation(t *testing.T) {
 	}
 
 }
+
+func TestCacheRateDiscardsOutOfOrder(t *testing.T) {
+	p := &ProductsDB{log: logrus.New(), mutex: &sync.RWMutex{}, rates: map[string]*cachedRate{}}
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	rate := func(r float64, seq uint64, at time.Time) *protos.RateResponse {
+		return &protos.RateResponse{
+			Destination: protos.Currencies_USD,
+			Rate:        r,
+			Sequence:    seq,
+			Timestamp:   timestamppb.New(at),
+		}
+	}
+
+	if !p.cacheRate(rate(1.1, 5, now)) {
+		t.Fatal("expected the first rate to be cached")
+	}
+	if p.cacheRate(rate(1.0, 4, now.Add(-time.Second))) {
+		t.Fatal("expected an older update to be discarded")
+	}
+	if r, _ := p.getRate("USD"); r != 1.1 {
+		t.Fatalf("expected cached rate 1.1 got %f", r)
+	}
+
+	// the currency service restarted and counts from the start again
+	if !p.cacheRate(rate(1.2, 1, now.Add(time.Minute))) {
+		t.Fatal("expected a newer update with a lower sequence to be cached")
+	}
+	if r, _ := p.getRate("USD"); r != 1.2 {
+		t.Fatalf("expected cached rate 1.2 got %f", r)
+	}
+}
+
+// fakeCurrency refuses the first subscription, like a currency service which
+// isn't serving yet, and hands out the streams afterwards
+type fakeCurrency struct {
+	protos.CurrencyClient
+	mutex   *sync.Mutex
+	calls   int
+	streams chan *fakeStream
+}
+
+func (f *fakeCurrency) SubscribeRates(ctx context.Context, opts ...grpc.CallOption) (protos.Currency_SubscribeRatesClient, error) {
+	f.mutex.Lock()
+	f.calls++
+	first := f.calls == 1
+	f.mutex.Unlock()
+	if first {
+		return nil, status.Error(codes.Unavailable, "not serving")
+	}
+	return <-f.streams, nil
+}
+
+type fakeStream struct {
+	grpc.ClientStream
+	sent      chan *protos.RateRequest
+	responses chan *protos.StreamingRateResponse
+}
+
+func (s *fakeStream) Send(rr *protos.RateRequest) error {
+	s.sent <- rr
+	return nil
+}
+
+func (s *fakeStream) Recv() (*protos.StreamingRateResponse, error) {
+	rr, ok := <-s.responses
+	if !ok {
+		return nil, status.Error(codes.Unavailable, "connection lost")
+	}
+	return rr, nil
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{sent: make(chan *protos.RateRequest, 10), responses: make(chan *protos.StreamingRateResponse)}
+}
+
+func TestHandleUpdatesResubscribes(t *testing.T) {
+	currency := &fakeCurrency{mutex: &sync.Mutex{}, streams: make(chan *fakeStream)}
+	p := &ProductsDB{
+		currency:    currency,
+		log:         logrus.New(),
+		mutex:       &sync.RWMutex{},
+		rates:       map[string]*cachedRate{"USD": {rate: 1.1}},
+		streamMutex: &sync.Mutex{},
+		minBackoff:  time.Millisecond,
+		maxBackoff:  time.Millisecond,
+	}
+	go p.handleUpdates()
+
+	for i := 0; i < 3; i++ {
+		stream := newFakeStream()
+		currency.streams <- stream
+
+		// the new stream is subscribed to the cached rates
+		select {
+		case rr := <-stream.sent:
+			if rr.Destination != protos.Currencies_USD {
+				t.Fatalf("expected a subscription to USD, got %s", rr.Destination)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the cached rates to be subscribed again")
+		}
+		if i == 2 {
+			// the previous stream is done with its updates
+			break
+		}
+
+		stream.responses <- &protos.StreamingRateResponse{Message: &protos.StreamingRateResponse_RateResponse{
+			RateResponse: &protos.RateResponse{Destination: protos.Currencies_USD, Rate: float64(2 + i), Sequence: uint64(i + 1)},
+		}}
+		// the stream failing makes it subscribe again
+		close(stream.responses)
+	}
+
+	if rate, _ := p.getRate("USD"); rate != 3 {
+		t.Errorf("expected the rate of the last stream, got %f", rate)
+	}
+}
+
+// failingRates fails every rate request with err
+type failingRates struct {
+	protos.CurrencyClient
+	err error
+}
+
+func (f failingRates) GetRate(ctx context.Context, rr *protos.RateRequest, opts ...grpc.CallOption) (*protos.RateResponse, error) {
+	return nil, f.err
+}
+
+func TestFetchRateErrorsWithoutDetails(t *testing.T) {
+	for _, code := range []codes.Code{codes.NotFound, codes.Unauthenticated, codes.Unavailable} {
+		refused := status.Error(code, "refused")
+		p := &ProductsDB{log: logrus.New(), currency: failingRates{err: refused}}
+		_, err := p.fetchRate("USD")
+		if !errors.Is(err, refused) {
+			t.Errorf("expected the %s status to be returned got %v", code, err)
+		}
+	}
+
+	s, err := status.New(codes.InvalidArgument, "same currency").WithDetails(&protos.RateRequest{Base: protos.Currencies_EUR, Destination: protos.Currencies_EUR})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProductsDB{log: logrus.New(), currency: failingRates{err: s.Err()}}
+	if _, err := p.fetchRate("EUR"); err == nil || !strings.Contains(err.Error(), "base - EUR & destination - EUR") {
+		t.Errorf("expected the request to be described got %v", err)
+	}
+}
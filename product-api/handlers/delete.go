@@ -14,7 +14,8 @@ import (
 // responses:
 //	200: noContent
 
-// DeleteProduct delete a product
+// DeleteProduct delete a product, with release_images=true its images are
+// released in product-images, which deletes those no other product uses
 func (p *Products) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
@@ -22,7 +23,8 @@ func (p *Products) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 
 	p.l.Debugln("Handle delete product", id)
 
-	if err := data.DeleteProduct(id); err != nil {
+	prod, err := data.DeleteProduct(id)
+	if err != nil {
 		switch err {
 		case data.ErrProductNotFound:
 			p.l.Errorln("Product not found for deletion with id ", id)
@@ -34,4 +36,7 @@ func (p *Products) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	if release, _ := strconv.ParseBool(r.URL.Query().Get("release_images")); release {
+		p.releaseImages(r.Context(), prod)
+	}
 }
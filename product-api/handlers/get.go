@@ -16,7 +16,7 @@ func (p *Products) GetByID(w http.ResponseWriter, r *http.Request) {
 
 	cur := r.URL.Query().Get("currency")
 	id := getProductID(r)
-	product, err := p.productDB.GetProductByID(id, cur)
+	product, err := p.productDB.GetProductByID(id, cur, withQuote(r))
 
 	if err != nil {
 		switch err {
@@ -31,6 +31,16 @@ func (p *Products) GetByID(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if p.expandsImages(r) {
+		expanded, err := p.expandImages(r.Context(), data.Products{product})
+		if err != nil {
+			p.l.Errorf("unable expanding images %s", err.Error())
+			utils.RespondWithError(w, http.StatusFailedDependency, err.Error())
+			return
+		}
+		product = expanded[0]
+	}
+
 	utils.RespondWithJSON(w, http.StatusOK, product)
 
 }
@@ -43,3 +53,10 @@ func getProductID(r *http.Request) int {
 	}
 	return id
 }
+
+// withQuote reports whether the client asked for converted prices to be
+// locked in with a quote using the quote query parameter.
+func withQuote(r *http.Request) bool {
+	q, _ := strconv.ParseBool(r.URL.Query().Get("quote"))
+	return q
+}
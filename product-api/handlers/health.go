@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"product-api/utils"
+
+	"github.com/samims/ecommerceGO/currency/constants"
+	protos "github.com/samims/ecommerceGO/currency/protos/currency"
+	"github.com/sirupsen/logrus"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthStatus is the body of the health endpoints
+// swagger:model
+type HealthStatus struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// Health serves the liveness and readiness endpoints
+type Health struct {
+	l       *logrus.Logger
+	hc      healthpb.HealthClient
+	timeout time.Duration
+}
+
+func NewHealth(l *logrus.Logger, hc healthpb.HealthClient) *Health {
+	return &Health{
+		l:       l,
+		hc:      hc,
+		timeout: 2 * time.Second,
+	}
+}
+
+// swagger:route GET /healthz health liveness
+// Returns ok while the process is running
+// responses:
+//	200: HealthStatus
+
+// Liveness reports that the process is up, it doesn't check any dependency.
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// swagger:route GET /readyz health readiness
+// Returns whether the service and its dependencies can serve requests
+// responses:
+//	200: HealthStatus
+//	503: HealthStatus
+
+// Readiness reports whether the service can serve requests, which requires
+// the currency service to report SERVING.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	currency := healthpb.HealthCheckResponse_UNKNOWN.String()
+	resp, err := h.hc.Check(ctx, &healthpb.HealthCheckRequest{Service: protos.Currency_ServiceDesc.ServiceName})
+	if err != nil {
+		h.l.Errorf("unable to check currency service health: %s", err)
+	} else {
+		currency = resp.GetStatus().String()
+	}
+
+	body := HealthStatus{
+		Status:       "ready",
+		Dependencies: map[string]string{"currency": currency},
+	}
+	// stale rates are still good enough to serve, they are only reported
+	if rates := h.ratesStatus(ctx); rates != "" {
+		body.Dependencies["currency_rates"] = rates
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		body.Status = "not ready"
+		utils.RespondWithJSON(w, http.StatusServiceUnavailable, body)
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, body)
+}
+
+// ratesStatus returns "live" or "stale" depending on where the currency
+// service gets its rates from, or an empty string when it doesn't say.
+func (h *Health) ratesStatus(ctx context.Context) string {
+	resp, err := h.hc.Check(ctx, &healthpb.HealthCheckRequest{Service: constants.LiveRatesService})
+	if err != nil {
+		return ""
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return "stale"
+	}
+	return "live"
+}
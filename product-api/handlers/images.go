@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"product-api/data"
+	"product-api/images"
+	"product-api/utils"
+)
+
+// AddImageRequest is the body of POST /{id}/images
+// swagger:model
+type AddImageRequest struct {
+	data.ProductImage
+	// the index to insert the image at, it is appended when omitted
+	Position *int `json:"position,omitempty"`
+}
+
+// swagger:route POST /{id}/images productAPIs addProductImage
+// Adds an image uploaded to product-images to the product
+
+// AddImage adds an image to the product once product-images confirmed it
+// stores the image and recorded that it belongs to the product.
+func (p *Products) AddImage(w http.ResponseWriter, r *http.Request) {
+	id := getProductID(r)
+
+	req := AddImageRequest{}
+	if err := utils.FromJSON(&req, r.Body); err != nil {
+		p.l.Errorln("unable to deserialize image", err)
+		utils.RespondWithError(w, http.StatusBadRequest, "Unable to unmarshal JSON")
+		return
+	}
+	if err := req.ProductImage.Validate(); err != nil {
+		utils.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error validating image: %s", err))
+		return
+	}
+
+	if _, err := p.images.Claim(r.Context(), req.ID, id); err != nil {
+		if errors.Is(err, images.ErrImageNotFound) {
+			utils.RespondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("image %s not found in product-images", req.ID))
+			return
+		}
+		p.l.Errorf("unable to verify image %s: %s", req.ID, err)
+		utils.RespondWithError(w, http.StatusFailedDependency, err.Error())
+		return
+	}
+
+	position := -1
+	if req.Position != nil {
+		position = *req.Position
+	}
+	product, err := data.AddImage(id, req.ProductImage, position)
+	if err != nil {
+		if err != data.ErrImageAlreadyAdded {
+			p.releaseImage(r.Context(), req.ID, id)
+		}
+		switch err {
+		case data.ErrProductNotFound:
+			utils.RespondWithError(w, http.StatusNotFound, err.Error())
+		case data.ErrImageAlreadyAdded:
+			utils.RespondWithError(w, http.StatusConflict, err.Error())
+		default:
+			utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	p.l.Debugf("added image %s to product %d", req.ID, id)
+
+	utils.RespondWithJSON(w, http.StatusCreated, product)
+}
+
+// expandsImages reports whether the client asked for the URLs and dimensions
+// of the images using expand=images. Without product-images they can't be
+// expanded.
+func (p *Products) expandsImages(r *http.Request) bool {
+	if p.images == nil {
+		return false
+	}
+	for _, e := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.TrimSpace(e) == "images" {
+			return true
+		}
+	}
+	return false
+}
+
+// signedImageTTL is how long the URLs of private images in expanded products
+// are valid
+const signedImageTTL = time.Hour
+
+// maxImageLookups bounds the concurrent calls to product-images expanding
+// the images of products
+const maxImageLookups = 8
+
+// expandImages returns copies of the products with the URL and dimensions of
+// their images filled in from product-images. Private images get a signed URL.
+// Images product-images no longer stores are returned as they are.
+func (p *Products) expandImages(ctx context.Context, products data.Products) (data.Products, error) {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, prod := range products {
+		for _, ref := range prod.Images {
+			if !seen[ref.ID] {
+				seen[ref.ID] = true
+				ids = append(ids, ref.ID)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mutex := &sync.Mutex{}
+	found := map[string]*images.Image{}
+	var lookupErr error
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, maxImageLookups)
+	for _, id := range ids {
+		// the lookups left are skipped once one failed
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			img, err := p.lookupImage(ctx, id)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if lookupErr == nil {
+					lookupErr = err
+					cancel()
+				}
+				return
+			}
+			found[id] = img
+		}(id)
+	}
+	wg.Wait()
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+
+	expanded := make(data.Products, 0, len(products))
+	for _, prod := range products {
+		np := *prod
+		np.Images = make([]data.ProductImage, len(prod.Images))
+		for i, ref := range prod.Images {
+			if img := found[ref.ID]; img != nil {
+				ref.URL, ref.Width, ref.Height = img.URL, img.Width, img.Height
+			} else {
+				p.l.Warnf("image %s of product %d is missing in product-images", ref.ID, prod.ID)
+			}
+			np.Images[i] = ref
+		}
+		expanded = append(expanded, &np)
+	}
+	return expanded, nil
+}
+
+// lookupImage returns the metadata of the image with a signed URL when it's
+// private, nil when product-images no longer stores it.
+func (p *Products) lookupImage(ctx context.Context, id string) (*images.Image, error) {
+	img, err := p.images.Get(ctx, id)
+	if errors.Is(err, images.ErrImageNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if img.Private {
+		signed, err := p.images.SignURL(ctx, id, nil, signedImageTTL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign url of image %s: %s", id, err)
+		}
+		img.URL = signed.URL
+	}
+	return img, nil
+}
+
+// releaseImages releases the images of a deleted product in product-images,
+// which deletes those no other product uses.
+func (p *Products) releaseImages(ctx context.Context, prod *data.Product) {
+	if p.images == nil {
+		return
+	}
+	for _, ref := range prod.Images {
+		p.releaseImage(ctx, ref.ID, prod.ID)
+	}
+}
+
+func (p *Products) releaseImage(ctx context.Context, id string, productID int) {
+	deleted, err := p.images.Release(ctx, id, productID)
+	if err != nil && !errors.Is(err, images.ErrImageNotFound) {
+		p.l.Errorf("unable to release image %s of product %d: %s", id, productID, err)
+		return
+	}
+	p.l.Debugf("released image %s of product %d, deleted: %t", id, productID, deleted)
+}
@@ -27,7 +27,7 @@ func (p *Products) GetProducts(w http.ResponseWriter, r *http.Request) {
 	cr := r.URL.Query().Get("currency")
 
 	// Call the GetProducts method of the product database to retrieve the list of products.
-	listProducts, err := p.productDB.GetProducts(cr)
+	listProducts, err := p.productDB.GetProducts(cr, withQuote(r))
 
 	if err != nil {
 		p.l.Error("error getting products")
@@ -35,6 +35,15 @@ func (p *Products) GetProducts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if p.expandsImages(r) {
+		listProducts, err = p.expandImages(r.Context(), listProducts)
+		if err != nil {
+			p.l.Error("error expanding product images ", err)
+			utils.RespondWithError(w, http.StatusFailedDependency, err.Error())
+			return
+		}
+	}
+
 	// Encode the product list as JSON and write it to the response stream
 	//err = listProducts.ToJSON(w)
 	utils.RespondWithJSON(w, http.StatusOK, listProducts)
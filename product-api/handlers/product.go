@@ -14,8 +14,13 @@
 package handlers
 
 import (
+	"context"
+	"net/url"
+	"time"
+
 	"product-api/configs"
 	"product-api/data"
+	"product-api/images"
 
 	"github.com/sirupsen/logrus"
 )
@@ -50,15 +55,28 @@ type productIDParameterWrapper struct {
 type productsNoContent struct {
 }
 
+// ImageClient looks up, claims and releases the images of the products in
+// product-images, see images.Client
+type ImageClient interface {
+	Get(ctx context.Context, id string) (*images.Image, error)
+	Claim(ctx context.Context, id string, productID int) (*images.Image, error)
+	Release(ctx context.Context, id string, productID int) (bool, error)
+	SignURL(ctx context.Context, id string, variant url.Values, ttl time.Duration) (*images.SignedURL, error)
+}
+
 type Products struct {
 	l         *logrus.Logger
 	cfg       *configs.Config
 	productDB *data.ProductsDB
+	// images is nil when product-images isn't configured, images are
+	// neither added, expanded nor released then
+	images ImageClient
 }
 
-func NewProduct(l *logrus.Logger, pdb *data.ProductsDB) *Products {
+func NewProduct(l *logrus.Logger, pdb *data.ProductsDB, ic ImageClient) *Products {
 	return &Products{
 		l:         l,
 		productDB: pdb,
+		images:    ic,
 	}
 }
@@ -0,0 +1,181 @@
+// Package images is a client of the product-images service, which stores
+// the images of the products.
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrImageNotFound = fmt.Errorf("image not found")
+
+// Image is the metadata product-images keeps about an uploaded image
+type Image struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	// Private images are only served through signed URLs, see Client.SignURL
+	Private bool `json:"private"`
+}
+
+// SignedURL is a URL granting access to a private image until ExpiresAt
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Client calls the HTTP API of product-images.
+type Client struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+// NewClient returns a client of the product-images service at base, e.g.
+// http://localhost:8080. The token is the one product-images requires to
+// sign URLs and change images. Every call gives up after timeout.
+func NewClient(base, token string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid product-images base URL %q", base)
+	}
+	return &Client{
+		base:  u,
+		token: token,
+		http:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Get returns the metadata of the image, ErrImageNotFound when product-images
+// doesn't store it. The URL of the image is made absolute.
+func (c *Client) Get(ctx context.Context, id string) (*Image, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/images/"+url.PathEscape(id)+"/meta")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	img := &Image{}
+	if err := json.NewDecoder(resp.Body).Decode(img); err != nil {
+		return nil, fmt.Errorf("unable to decode image %s: %s", id, err)
+	}
+	img.URL = c.absolute(img.URL)
+	return img, nil
+}
+
+// SignURL mints a URL to the image valid for ttl, to the resized variant
+// selected by the w, h, fit and q values of variant if any.
+func (c *Client) SignURL(ctx context.Context, id string, variant url.Values, ttl time.Duration) (*SignedURL, error) {
+	q := url.Values{}
+	for k, v := range variant {
+		q[k] = v
+	}
+	q.Set("expires_in", strconv.Itoa(int(ttl.Seconds())))
+
+	resp, err := c.do(ctx, http.MethodPost, "/images/"+url.PathEscape(id)+"/signed-url?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	signed := &SignedURL{}
+	if err := json.NewDecoder(resp.Body).Decode(signed); err != nil {
+		return nil, fmt.Errorf("unable to decode signed url of %s: %s", id, err)
+	}
+	signed.URL = c.absolute(signed.URL)
+	return signed, nil
+}
+
+// absolute resolves a URL returned by product-images against its base.
+func (c *Client) absolute(u string) string {
+	ref, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return c.base.ResolveReference(ref).String()
+}
+
+// Claim records in product-images that the image belongs to the product and
+// returns its metadata, ErrImageNotFound when product-images doesn't store it.
+func (c *Client) Claim(ctx context.Context, id string, productID int) (*Image, error) {
+	resp, err := c.do(ctx, http.MethodPut, productPath(id, productID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	img := &Image{}
+	if err := json.NewDecoder(resp.Body).Decode(img); err != nil {
+		return nil, fmt.Errorf("unable to decode image %s: %s", id, err)
+	}
+	img.URL = c.absolute(img.URL)
+	return img, nil
+}
+
+// Release records that the image no longer belongs to the product.
+// product-images deletes it once no product is left, which is reported by
+// deleted. It returns ErrImageNotFound when product-images doesn't store it.
+func (c *Client) Release(ctx context.Context, id string, productID int) (deleted bool, err error) {
+	resp, err := c.do(ctx, http.MethodDelete, productPath(id, productID))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return true, nil
+	}
+	return false, checkStatus(resp, http.StatusOK)
+}
+
+func productPath(id string, productID int) string {
+	return "/images/" + url.PathEscape(id) + "/products/" + strconv.Itoa(productID)
+}
+
+func (c *Client) do(ctx context.Context, method, path string) (*http.Response, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base.ResolveReference(ref).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach product-images: %s", err)
+	}
+	return resp, nil
+}
+
+func checkStatus(resp *http.Response, want int) error {
+	switch resp.StatusCode {
+	case want:
+		return nil
+	case http.StatusNotFound:
+		return ErrImageNotFound
+	default:
+		return fmt.Errorf("unexpected status from product-images: %s", resp.Status)
+	}
+}
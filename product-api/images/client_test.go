@@ -0,0 +1,73 @@
+package images
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/images/a.png/meta":
+			w.Write([]byte(`{"id":"a.png","url":"/images/a.png","width":600,"height":400}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/images/a.png/products/1":
+			w.Write([]byte(`{"id":"a.png","url":"/images/a.png","product_ids":[1,2]}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/images/a.png/products/1":
+			w.Write([]byte(`{"id":"a.png","url":"/images/a.png","product_ids":[2]}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/images/a.png/products/2":
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && r.URL.Path == "/images/a.png/signed-url":
+			if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("expires_in") != "3600" || r.URL.Query().Get("w") != "300" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"url":"/images/a.png?w=300&sig=x","expires_at":"2023-04-01T13:00:00Z"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.URL, "token", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := c.Get(context.Background(), "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.URL != srv.URL+"/images/a.png" || img.Width != 600 || img.Height != 400 {
+		t.Errorf("unexpected image %+v", img)
+	}
+	if _, err := c.Get(context.Background(), "b.png"); err != ErrImageNotFound {
+		t.Errorf("expected ErrImageNotFound got %v", err)
+	}
+	signed, err := c.SignURL(context.Background(), "a.png", url.Values{"w": {"300"}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.URL != srv.URL+"/images/a.png?w=300&sig=x" {
+		t.Errorf("unexpected signed url %s", signed.URL)
+	}
+	if img, err := c.Claim(context.Background(), "a.png", 1); err != nil || img.URL != srv.URL+"/images/a.png" {
+		t.Errorf("unexpected claimed image %+v %v", img, err)
+	}
+	// the image is only deleted with its last product
+	if deleted, err := c.Release(context.Background(), "a.png", 1); err != nil || deleted {
+		t.Errorf("expected product 2 to keep the image got %t %v", deleted, err)
+	}
+	if deleted, err := c.Release(context.Background(), "a.png", 2); err != nil || !deleted {
+		t.Errorf("expected the image to be deleted got %t %v", deleted, err)
+	}
+	if _, err := c.Release(context.Background(), "b.png", 1); err != ErrImageNotFound {
+		t.Errorf("expected ErrImageNotFound got %v", err)
+	}
+
+	if _, err := NewClient("localhost:8080", "", time.Second); err == nil {
+		t.Error("expected a base URL without scheme to be rejected")
+	}
+}
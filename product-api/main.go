@@ -1,19 +1,28 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"product-api/configs"
 	"product-api/constants"
+	"product-api/handlers"
+	"product-api/images"
 	"product-api/logger"
 	"product-api/router"
 	"product-api/server"
 
 	"github.com/gorilla/mux"
+	"github.com/samims/ecommerceGO/currency/certs"
 	protos "github.com/samims/ecommerceGO/currency/protos/currency"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	// registers the client-side health checking used by currencyServiceConfig
+	_ "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
@@ -26,24 +35,41 @@ func main() {
 	mediaURL := envs.GetString(constants.MediaURL)
 	allowedHosts := envs.GetStringSlice(constants.AllowedHosts)
 	currencyServerBase := envs.GetString(constants.CurrencyServerBase)
+	productImagesBase := envs.GetString(constants.ProductImagesBase)
 
 	// Initialize the logger.
 	l := initLogger(logLevel)
 
 	// app cfg
-	appCfg := createAppConfig(allowedHosts, imageDir, mediaURL, currencyServerBase)
+	appCfg := createAppConfig(allowedHosts, imageDir, mediaURL, currencyServerBase, productImagesBase)
 	// Create the handlers configuration.
 	serverCfg := createServerConfig(bindAddress)
 
 	// Create the application configuration.
 	cfg := configs.NewConfig(serverCfg, appCfg).(configs.Config)
 
-	cc, conn, _ := getCurrencyGrpcClient(cfg)
+	cc, conn, err := getCurrencyGrpcClient(cfg, l, currencyClientOptions{
+		token:      envs.GetString(constants.CurrencyToken),
+		caFile:     envs.GetString(constants.CurrencyTLSCAFile),
+		certFile:   envs.GetString(constants.CurrencyTLSCertFile),
+		keyFile:    envs.GetString(constants.CurrencyTLSKeyFile),
+		serverName: envs.GetString(constants.CurrencyTLSServerName),
+	})
+	if err != nil {
+		l.Fatal(err)
+	}
 
 	defer conn.Close()
 
+	hc := healthpb.NewHealthClient(conn)
+
+	ic, err := getImagesClient(cfg, l, envs.GetString(constants.ProductImagesToken))
+	if err != nil {
+		l.Fatal(err)
+	}
+
 	// Create the router.
-	r := createRouter(l, &cfg, cc)
+	r := createRouter(l, &cfg, cc, hc, ic)
 
 	// Create the handlers.
 	routerObj := r.GetRouter()
@@ -53,8 +79,8 @@ func main() {
 	startServer(s, l)
 }
 
-func createAppConfig(allowedHosts []string, imageDIR, mediaURL, currencyServerBase string) configs.AppConfig {
-	return configs.NewAppConfig(allowedHosts, imageDIR, mediaURL, currencyServerBase)
+func createAppConfig(allowedHosts []string, imageDIR, mediaURL, currencyServerBase, productImagesBase string) configs.AppConfig {
+	return configs.NewAppConfig(allowedHosts, imageDIR, mediaURL, currencyServerBase, productImagesBase)
 
 }
 
@@ -70,19 +96,93 @@ func createServerConfig(bindAddress string) configs.ServerConfig {
 	return configs.NewServerConf(bindAddress, 10*time.Second, 15*time.Second, 15*time.Second)
 }
 
-func getCurrencyGrpcClient(cfg configs.Config) (protos.CurrencyClient, *grpc.ClientConn, error) {
-	creds := insecure.NewCredentials()
-	conn, err := grpc.Dial(cfg.AppConfig().GetCurrencyServerBase(), grpc.WithTransportCredentials(creds))
+// imagesTimeout bounds the calls to product-images
+const imagesTimeout = 5 * time.Second
+
+// getImagesClient returns the client of product-images, nil when its base URL
+// isn't configured and the image routes are off.
+func getImagesClient(cfg configs.Config, l *logrus.Logger, token string) (handlers.ImageClient, error) {
+	base := cfg.AppConfig().GetProductImagesBase()
+	if base == "" {
+		l.Warn("product-images is not configured, the image routes are off")
+		return nil, nil
+	}
+	c, err := images.NewClient(base, token, imagesTimeout)
 	if err != nil {
-		panic(err)
-		//return nil, nil, err
+		return nil, err
+	}
+	return c, nil
+}
+
+// currencyServiceConfig enables client-side health checking, connections to a
+// currency server which doesn't report SERVING are not used for RPCs.
+// Health checking is not supported by pick_first, so round_robin is used.
+var currencyServiceConfig = fmt.Sprintf(
+	`{"loadBalancingConfig": [{"round_robin": {}}], "healthCheckConfig": {"serviceName": %q}}`,
+	protos.Currency_ServiceDesc.ServiceName,
+)
+
+// currencyClientOptions are the credentials used to connect to the currency service.
+type currencyClientOptions struct {
+	token      string
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+}
+
+func getCurrencyGrpcClient(cfg configs.Config, l *logrus.Logger, co currencyClientOptions) (protos.CurrencyClient, *grpc.ClientConn, error) {
+	creds, err := currencyCredentials(l, co)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(currencyServiceConfig),
+	}
+	if co.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(co.token)))
+	}
+	conn, err := grpc.Dial(cfg.AppConfig().GetCurrencyServerBase(), opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to the currency service: %s", err)
 	}
 	currencyGrpcClient := protos.NewCurrencyClient(conn)
 	return currencyGrpcClient, conn, nil
 }
 
-func createRouter(l *logrus.Logger, cfg *configs.Config, cc protos.CurrencyClient) *router.Router {
-	r := router.NewRouter(l, cfg, cc)
+// currencyCredentials returns TLS credentials when a CA file is configured,
+// the certificates are reloaded whenever the files change. The token is never
+// sent in plaintext, it requires TLS.
+func currencyCredentials(l *logrus.Logger, co currencyClientOptions) (credentials.TransportCredentials, error) {
+	if co.caFile == "" {
+		if co.token != "" {
+			return nil, fmt.Errorf("%s requires TLS to the currency service, set %s", constants.CurrencyToken, constants.CurrencyTLSCAFile)
+		}
+		l.Warn("TLS to the currency service is not configured, connecting in plaintext")
+		return insecure.NewCredentials(), nil
+	}
+	r, err := certs.NewReloader(l, co.certFile, co.keyFile, co.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load currency TLS certificates: %s", err)
+	}
+	return credentials.NewTLS(certs.ClientConfig(r, co.serverName)), nil
+}
+
+// bearerToken sends the token as "authorization: Bearer <token>" metadata
+// with every call to the currency service, over TLS only.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+func createRouter(l *logrus.Logger, cfg *configs.Config, cc protos.CurrencyClient, hc healthpb.HealthClient, ic handlers.ImageClient) *router.Router {
+	r := router.NewRouter(l, cfg, cc, hc, ic)
 	return r
 }
 
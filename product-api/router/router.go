@@ -11,21 +11,24 @@ import (
 	"github.com/gorilla/mux"
 	protos "github.com/samims/ecommerceGO/currency/protos/currency"
 	"github.com/sirupsen/logrus"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Router struct {
 	router *mux.Router
 }
 
-func NewRouter(logger *logrus.Logger, cfg *configs.Config, cc protos.CurrencyClient) *Router {
+func NewRouter(logger *logrus.Logger, cfg *configs.Config, cc protos.CurrencyClient, hc healthpb.HealthClient, ic handlers.ImageClient) *Router {
 	logger.Infof("Router is being initialized with config: %+v", *cfg)
 
 	router := mux.NewRouter()
 
 	pdb := data.NewProductsDB(cc, logger)
-	ph := handlers.NewProduct(logger, pdb)
+	ph := handlers.NewProduct(logger, pdb, ic)
+	hh := handlers.NewHealth(logger, hc)
 
-	registerRoutes(router, ph)
+	registerRoutes(router, ph, ic != nil)
+	registerHealth(router, hh)
 
 	return &Router{
 		router: router,
@@ -38,7 +41,9 @@ func (r *Router) GetRouter() *mux.Router {
 	return r.router
 }
 
-func registerRoutes(router *mux.Router, ph *handlers.Products) {
+// registerRoutes registers the product routes, those of the images only
+// when product-images is configured.
+func registerRoutes(router *mux.Router, ph *handlers.Products, withImages bool) {
 	currencyRegex := "{[A-Z]{3}}"
 	///^[A-Z]{3}$
 
@@ -47,6 +52,11 @@ func registerRoutes(router *mux.Router, ph *handlers.Products) {
 	getRouter.HandleFunc("/", ph.GetProducts).Queries("currency", currencyRegex)
 	getRouter.HandleFunc("/{id:[0-9]+}", ph.GetByID)
 
+	// registered before the POST routes, which expect a product body
+	if withImages {
+		router.HandleFunc("/{id:[0-9]+}/images", ph.AddImage).Methods(http.MethodPost)
+	}
+
 	putRouter := router.Methods(http.MethodPut).Subrouter()
 	putRouter.HandleFunc("/{id:[0-9]+}", ph.UpdateProducts)
 	putRouter.Use(ph.MiddlewareValidateProduct)
@@ -62,6 +72,12 @@ func registerRoutes(router *mux.Router, ph *handlers.Products) {
 	registerStatic(router)
 }
 
+func registerHealth(router *mux.Router, hh *handlers.Health) {
+	getRouter := router.Methods(http.MethodGet).Subrouter()
+	getRouter.HandleFunc("/healthz", hh.Liveness)
+	getRouter.HandleFunc("/readyz", hh.Readiness)
+}
+
 func registerDocs(router *mux.Router) {
 	ops := middleware.RedocOpts{SpecURL: "/swagger.yaml"}
 	sh := middleware.Redoc(ops, nil)
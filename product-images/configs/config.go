@@ -5,14 +5,24 @@ type Config struct {
 	ImageDIR     string
 	MediaURL     string
 	ServerCfg    *ServerConf
+	ResizeCfg    *ResizeConf
+	UploadCfg    *UploadConf
+	StorageCfg   *StorageConf
+	SigningCfg   *SigningConf
+	ResumableCfg *ResumableConf
 }
 
-func NewConfig(allowedHosts []string, imageDIR, mediaURL string, sCfg *ServerConf) *Config {
+func NewConfig(allowedHosts []string, imageDIR, mediaURL string, sCfg *ServerConf, rCfg *ResizeConf, uCfg *UploadConf, stCfg *StorageConf, sgCfg *SigningConf, rsCfg *ResumableConf) *Config {
 	cfg := &Config{
 		AllowedHosts: allowedHosts,
 		ImageDIR:     imageDIR,
 		ServerCfg:    sCfg,
 		MediaURL:     mediaURL,
+		ResizeCfg:    rCfg,
+		UploadCfg:    uCfg,
+		StorageCfg:   stCfg,
+		SigningCfg:   sgCfg,
+		ResumableCfg: rsCfg,
 	}
 
 	return cfg
@@ -0,0 +1,35 @@
+package configs
+
+// ImageSize is a size resized images may be requested in, a zero Width or
+// Height follows the aspect ratio of the image.
+type ImageSize struct {
+	Width  int
+	Height int
+}
+
+type ResizeConf struct {
+	// Sizes is the allowlist of sizes, other sizes are rejected
+	Sizes []ImageSize
+	// CacheDIR is where resized variants are kept, at most CacheMaxBytes of them
+	CacheDIR      string
+	CacheMaxBytes int64
+}
+
+// NewResizeConf returns initialized pointer of ResizeConf
+func NewResizeConf(sizes []ImageSize, cacheDIR string, cacheMaxBytes int64) *ResizeConf {
+	return &ResizeConf{
+		Sizes:         sizes,
+		CacheDIR:      cacheDIR,
+		CacheMaxBytes: cacheMaxBytes,
+	}
+}
+
+// SizeAllowed reports whether images may be resized to width x height.
+func (c *ResizeConf) SizeAllowed(width, height int) bool {
+	for _, s := range c.Sizes {
+		if s.Width == width && s.Height == height {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package configs
+
+import "time"
+
+type ResumableConf struct {
+	// DIR is where the partial uploads are kept until they are finished
+	DIR string
+	// ExpireAfter is how long an upload may go without receiving bytes before
+	// it is removed, finished uploads can be asked for until then as well
+	ExpireAfter time.Duration
+	// GCInterval is how often expired uploads are removed
+	GCInterval time.Duration
+	// MaxUploads is the number of unfinished uploads and MaxBytes the sum of
+	// their lengths beyond which new uploads are refused, zero is unbounded
+	MaxUploads int
+	MaxBytes   int64
+}
+
+// NewResumableConf returns initialized pointer of ResumableConf
+func NewResumableConf(dir string, expireAfter, gcInterval time.Duration, maxUploads int, maxBytes int64) *ResumableConf {
+	return &ResumableConf{
+		DIR:         dir,
+		ExpireAfter: expireAfter,
+		GCInterval:  gcInterval,
+		MaxUploads:  maxUploads,
+		MaxBytes:    maxBytes,
+	}
+}
@@ -0,0 +1,26 @@
+package configs
+
+import "time"
+
+type SigningConf struct {
+	// Keys are the keys image URLs are signed with as comma separated id:secret
+	// pairs, the first one signs new URLs, see signing.ParseKeys
+	Keys string
+	// Token is the bearer token required to mint signed URLs and to change,
+	// claim, release or delete images
+	Token string
+	// DefaultTTL is how long minted URLs are valid unless asked otherwise,
+	// MaxTTL the longest validity which may be asked for
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+}
+
+// NewSigningConf returns initialized pointer of SigningConf
+func NewSigningConf(keys, token string, defaultTTL, maxTTL time.Duration) *SigningConf {
+	return &SigningConf{
+		Keys:       keys,
+		Token:      token,
+		DefaultTTL: defaultTTL,
+		MaxTTL:     maxTTL,
+	}
+}
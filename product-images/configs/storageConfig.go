@@ -0,0 +1,29 @@
+package configs
+
+type StorageConf struct {
+	// Backend is "file" to store the images in ImageDIR or "s3" to store them
+	// in the bucket of an S3-compatible object store
+	Backend string
+	// S3Endpoint is the URL of the object store, buckets are addressed by path
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+	// IndexFile is where the metadata index of the images is saved, it is
+	// rebuilt from the storage when missing
+	IndexFile string
+}
+
+// NewStorageConf returns initialized pointer of StorageConf
+func NewStorageConf(backend, s3Endpoint, s3Bucket, s3Region, s3AccessKey, s3SecretKey, indexFile string) *StorageConf {
+	return &StorageConf{
+		Backend:     backend,
+		S3Endpoint:  s3Endpoint,
+		S3Bucket:    s3Bucket,
+		S3Region:    s3Region,
+		S3AccessKey: s3AccessKey,
+		S3SecretKey: s3SecretKey,
+		IndexFile:   indexFile,
+	}
+}
@@ -0,0 +1,40 @@
+package configs
+
+import (
+	"time"
+)
+
+type UploadConf struct {
+	// MaxBytes is the largest image accepted
+	MaxBytes int64
+	// AllowedTypes are the content types accepted, detected from the content
+	// rather than the file name, JPEG, PNG and GIF images can be checked
+	AllowedTypes []string
+	// MaxWidth and MaxHeight are the largest dimensions accepted in pixels
+	MaxWidth  int
+	MaxHeight int
+	// MaxFiles is the most images accepted in one multipart upload
+	MaxFiles int
+	// Timeout is how long reading an upload and answering it may take, it
+	// replaces the server timeouts, which are too short for slow clients
+	Timeout time.Duration
+	// StripMetadata removes the EXIF, XMP and ICC metadata of uploaded
+	// images, after applying their EXIF orientation. KeepICC keeps the color
+	// profile.
+	StripMetadata bool
+	KeepICC       bool
+}
+
+// NewUploadConf returns initialized pointer of UploadConf
+func NewUploadConf(maxBytes int64, allowedTypes []string, maxWidth, maxHeight, maxFiles int, timeout time.Duration, stripMetadata, keepICC bool) *UploadConf {
+	return &UploadConf{
+		MaxBytes:      maxBytes,
+		AllowedTypes:  allowedTypes,
+		MaxWidth:      maxWidth,
+		MaxHeight:     maxHeight,
+		MaxFiles:      maxFiles,
+		Timeout:       timeout,
+		StripMetadata: stripMetadata,
+		KeepICC:       keepICC,
+	}
+}
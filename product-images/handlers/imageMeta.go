@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"product-images/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// page sizes of ListImages
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// imageJSON is the metadata of an image along with its URL
+type imageJSON struct {
+	URL string `json:"url"`
+	*storage.Metadata
+}
+
+// ListImages lists the uploaded images, latest first. The images are filtered
+// by the product_id, uploaded_after and uploaded_before query parameters and
+// paged by limit and offset.
+func (f *Files) ListImages(w http.ResponseWriter, r *http.Request) {
+	filter, err := listFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	found, total := f.store.Find(*filter)
+	images := make([]imageJSON, 0, len(found))
+	for _, m := range found {
+		images = append(images, imageJSON{URL: f.imageURL(m.ID), Metadata: m})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Images []imageJSON `json:"images"`
+		Total  int         `json:"total"`
+		Limit  int         `json:"limit"`
+		Offset int         `json:"offset"`
+	}{images, total, filter.Limit, filter.Offset})
+}
+
+func listFilter(q url.Values) (*storage.Filter, error) {
+	filter := &storage.Filter{Limit: defaultListLimit}
+	var err error
+
+	if v := q.Get("product_id"); v != "" {
+		if filter.ProductID, err = productID(v); err != nil {
+			return nil, err
+		}
+	}
+	if filter.From, err = uploadTime(q, "uploaded_after"); err != nil {
+		return nil, err
+	}
+	if filter.To, err = uploadTime(q, "uploaded_before"); err != nil {
+		return nil, err
+	}
+	if v := q.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			return nil, fmt.Errorf("limit should be between 1 and %d", maxListLimit)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		filter.Offset, err = strconv.Atoi(v)
+		if err != nil || filter.Offset < 0 {
+			return nil, fmt.Errorf("offset should be zero or a positive number")
+		}
+	}
+	return filter, nil
+}
+
+func productID(v string) (int, error) {
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("product_id should be a positive number")
+	}
+	return id, nil
+}
+
+// uploadTime parses a time given as RFC 3339 or as a date, 2006-01-02.
+func uploadTime(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s should be an RFC 3339 time or a date like 2006-01-02", name)
+	}
+	return t, nil
+}
+
+// ImageMeta returns the metadata of an image.
+func (f *Files) ImageMeta(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	meta, err := f.store.Meta(id)
+	if err != nil {
+		f.imageError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imageJSON{URL: f.imageURL(id), Metadata: meta})
+}
+
+// UpdateImageMeta changes whether an image is private, the body is
+// {"private": true}. It applies to every product the image belongs to.
+func (f *Files) UpdateImageMeta(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	body := struct {
+		Private *bool `json:"private"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Private == nil {
+		http.Error(w, `body should be {"private": true} or {"private": false}`, http.StatusBadRequest)
+		return
+	}
+
+	meta, err := f.store.SetPrivate(id, *body.Private)
+	if err != nil {
+		f.imageError(w, id, err)
+		return
+	}
+	f.log.Infof("Image %s is private: %t", id, meta.Private)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imageJSON{URL: f.imageURL(id), Metadata: meta})
+}
+
+// ClaimImage makes an image belong to the product too, e.g. when product-api
+// adds an image uploaded for another product.
+func (f *Files) ClaimImage(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	pid, err := productID(mux.Vars(r)["product_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := f.store.Claim(id, pid)
+	if err != nil {
+		f.imageError(w, id, err)
+		return
+	}
+	f.log.Infof("Image %s belongs to product %d", id, pid)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imageJSON{URL: f.imageURL(id), Metadata: meta})
+}
+
+// ReleaseImage makes an image no longer belong to the product. The image is
+// deleted with its last product and 204 returned, otherwise its metadata
+// tells the products left.
+func (f *Files) ReleaseImage(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	pid, err := productID(mux.Vars(r)["product_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := f.store.Release(id, pid)
+	if err != nil {
+		f.imageError(w, id, err)
+		return
+	}
+	if meta == nil {
+		f.log.Infof("Deleted image %s released by its last product %d", id, pid)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.log.Infof("Image %s released by product %d", id, pid)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imageJSON{URL: f.imageURL(id), Metadata: meta})
+}
+
+// DeleteImage removes an image and its metadata for every product it belongs
+// to. Cached variants age out of the cache.
+func (f *Files) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	if err := f.store.Delete(id); err != nil {
+		f.imageError(w, id, err)
+		return
+	}
+	f.log.Infof("Deleted image %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// imageError answers 404 for unknown or invalid ids and 500 otherwise.
+func (f *Files) imageError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidID) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	f.log.Errorf("Unable to access image %s: %s", id, err)
+	http.Error(w, "Unable to access image", http.StatusInternalServerError)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"product-images/imaging"
+	"product-images/storage"
+)
+
+// resizeOptions parses the w, h, fit and q query parameters, it returns nil
+// when the original image is requested. Only the sizes of the allowlist are
+// accepted so that clients can't fill the cache with arbitrary variants.
+func (f *Files) resizeOptions(q url.Values) (*imaging.Options, error) {
+	if q.Get("w") == "" && q.Get("h") == "" && q.Get("fit") == "" && q.Get("q") == "" {
+		return nil, nil
+	}
+
+	opts := &imaging.Options{Fit: imaging.FitContain}
+	var err error
+	if opts.Width, err = dimension(q, "w"); err != nil {
+		return nil, err
+	}
+	if opts.Height, err = dimension(q, "h"); err != nil {
+		return nil, err
+	}
+	if opts.Width == 0 && opts.Height == 0 {
+		return nil, fmt.Errorf("w or h is required to resize an image")
+	}
+	if !f.cfg.ResizeCfg.SizeAllowed(opts.Width, opts.Height) {
+		return nil, fmt.Errorf("size %dx%d is not allowed", opts.Width, opts.Height)
+	}
+
+	switch fit := imaging.Fit(q.Get("fit")); fit {
+	case "":
+	case imaging.FitContain, imaging.FitCover:
+		opts.Fit = fit
+	default:
+		return nil, fmt.Errorf("fit should be %s or %s", imaging.FitCover, imaging.FitContain)
+	}
+
+	if v := q.Get("q"); v != "" {
+		quality, err := strconv.Atoi(v)
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("q should be a quality between 1 and 100")
+		}
+		opts.Quality = quality
+	}
+	return opts, nil
+}
+
+func dimension(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := strconv.Atoi(v)
+	if err != nil || d < 1 {
+		return 0, fmt.Errorf("%s should be a positive number of pixels", name)
+	}
+	return d, nil
+}
+
+// serveVariant serves the resized variant of the image from the cache, the
+// variant is created on the first request.
+func (f *Files) serveVariant(w http.ResponseWriter, r *http.Request, file io.ReadSeeker, obj *storage.Object, opts imaging.Options) {
+	key := imaging.Key(obj.ID, obj.ModTime, opts)
+	variant, err := f.cache.Open(key)
+	if err != nil {
+		variant, err = f.resize(file, key, opts)
+	}
+	if err != nil {
+		f.log.Errorf("Unable to resize %s to %s: %s", obj.ID, opts, err)
+		http.Error(w, "Unable to resize image", http.StatusUnsupportedMediaType)
+		return
+	}
+	defer variant.Close()
+
+	// the content type is sniffed from the variant, GIFs are resized to PNG
+	http.ServeContent(w, r, "", obj.ModTime, variant)
+}
+
+func (f *Files) resize(file io.ReadSeeker, key string, opts imaging.Options) (*os.File, error) {
+	src, format, err := imaging.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	img := imaging.Resize(src, opts)
+	return f.cache.Put(key, func(w io.Writer) error {
+		return imaging.Encode(w, img, format, opts.Quality)
+	})
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"product-images/imaging"
+	"product-images/uploads"
+
+	"github.com/gorilla/mux"
+)
+
+// Resumable uploads implement the tus 1.0 core protocol with the creation
+// and termination extensions, see https://tus.io/protocols/resumable-upload.
+// Clients create an upload with POST /uploads, send its bytes in any number of
+// PATCH requests and resume after a failure from the offset HEAD returns.
+// The finished upload is validated and saved like UploadMultipart does.
+// Uploads require the API token, and the number of uploads in progress and
+// their bytes are bounded, see configs.ResumableConf.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	// uploadsURL is where the uploads are created
+	uploadsURL = "/uploads"
+	// imageURLHeader is set on the response finishing an upload and when
+	// asking for a finished upload, the URL of the saved image
+	imageURLHeader = "Image-URL"
+)
+
+// TusResumable rejects requests of other tus versions than 1.0.0, the
+// version is sent with every response.
+func (f *Files) TusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "Tus-Resumable should be "+tusVersion, http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TusOptions tells tus clients the supported version, extensions and the
+// largest upload.
+func (f *Files) TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(f.cfg.UploadCfg.MaxBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload creates an upload of Upload-Length bytes. The product_id and
+// private keys of Upload-Metadata are used like the form fields of
+// UploadMultipart, the filename key names the image.
+func (f *Files) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length should be the size of the upload in bytes", http.StatusBadRequest)
+		return
+	}
+	if length > f.cfg.UploadCfg.MaxBytes {
+		f.validationFailed(w, &imaging.ValidationError{
+			Rule:    imaging.RuleMaxSize,
+			Message: fmt.Sprintf("image of %d bytes exceeds the maximum of %d bytes", length, f.cfg.UploadCfg.MaxBytes),
+		})
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	meta, err := uploadMetadata(func(k string) string { return metadata[k] })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !f.authorizedMetadata(w, r, meta) {
+		return
+	}
+	if name, ok := metadata["filename"]; ok && !validName(name) {
+		http.Error(w, "Upload-Metadata filename should be a file name", http.StatusBadRequest)
+		return
+	}
+
+	u, err := f.uploads.Create(length, metadata)
+	if errors.Is(err, uploads.ErrCapacity) {
+		f.log.Infof("Refused upload of %d bytes: %s", length, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		f.log.Error("Unable to create upload ", err)
+		http.Error(w, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+	f.log.Debugf("Created upload %s of %d bytes", u.ID, length)
+
+	w.Header().Set("Location", uploadsURL+"/"+u.ID)
+	if u.Complete() {
+		// nothing to wait for, the empty upload fails the validation
+		f.finishUpload(w, u, http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UploadStatus returns the offset to resume an upload from.
+func (f *Files) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	u, err := f.uploads.Get(mux.Vars(r)["id"])
+	if err != nil {
+		// HEAD responses have no body
+		w.WriteHeader(uploadStatus(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if len(u.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", encodeTusMetadata(u.Metadata))
+	}
+	if u.ImageID != "" {
+		w.Header().Set(imageURLHeader, f.imageURL(u.ImageID))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AppendUpload writes the body to the upload at Upload-Offset. The request
+// sending the last bytes saves the image.
+func (f *Files) AppendUpload(w http.ResponseWriter, r *http.Request) {
+	f.extendDeadlines(w)
+	id := mux.Vars(r)["id"]
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type should be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset should be the number of bytes uploaded", http.StatusBadRequest)
+		return
+	}
+
+	u, err := f.uploads.Append(id, offset, r.Body)
+	if err != nil {
+		if u != nil {
+			// the client went away, it resumes from the bytes written
+			f.log.Infof("Upload %s interrupted at %d of %d bytes: %s", id, u.Offset, u.Length, err)
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		}
+		f.uploadFailed(w, id, err)
+		return
+	}
+
+	if !u.Complete() {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.finishUpload(w, u, http.StatusNoContent)
+}
+
+// TerminateUpload removes an unfinished upload.
+func (f *Files) TerminateUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := f.uploads.Delete(id); err != nil {
+		f.uploadFailed(w, id, err)
+		return
+	}
+	f.log.Debugf("Terminated upload %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishUpload saves the image of a complete upload. An upload failing the
+// validation is removed, it can't pass on retry.
+func (f *Files) finishUpload(w http.ResponseWriter, u *uploads.Upload, status int) {
+	id, metadata := u.ID, u.Metadata
+	u, err := f.uploads.Finish(id, func(file *os.File, size int64) (string, error) {
+		// the metadata was checked when the upload was created
+		meta, err := uploadMetadata(func(k string) string { return metadata[k] })
+		if err != nil {
+			return "", err
+		}
+		meta.Name = metadata["filename"]
+		meta, err = f.saveImage(file, size, "", meta)
+		if err != nil {
+			return "", err
+		}
+		return meta.ID, nil
+	})
+	if err != nil {
+		var invalid *imaging.ValidationError
+		switch {
+		case errors.As(err, &invalid):
+			if err := f.uploads.Delete(id); err != nil {
+				f.log.Errorf("Unable to remove rejected upload %s: %s", id, err)
+			}
+			f.validationFailed(w, invalid)
+		case errors.Is(err, uploads.ErrLocked):
+			f.uploadFailed(w, id, err)
+		default:
+			// the upload is kept, the client finishes it by sending nothing
+			// at the end of the upload
+			f.saveFailed(w, err)
+		}
+		return
+	}
+	f.log.Infof("Finished upload %s as image %s", id, u.ImageID)
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set(imageURLHeader, f.imageURL(u.ImageID))
+	w.WriteHeader(status)
+}
+
+func (f *Files) uploadFailed(w http.ResponseWriter, id string, err error) {
+	status := uploadStatus(err)
+	if status == http.StatusInternalServerError {
+		f.log.Errorf("Unable to write upload %s: %s", id, err)
+		http.Error(w, "Unable to write upload", status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		return http.StatusConflict
+	case errors.Is(err, uploads.ErrLocked):
+		return http.StatusLocked
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseTusMetadata parses Upload-Metadata, comma separated keys each followed
+// by a space and its base64 encoded value, which may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Upload-Metadata value of %s should be base64 encoded", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func encodeTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
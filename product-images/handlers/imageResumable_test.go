@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// tusRouter routes the resumable uploads like router.GetRouter does
+func tusRouter(f *Files) *mux.Router {
+	r := mux.NewRouter()
+	uploadRouter := r.PathPrefix("/uploads").Subrouter()
+	uploadRouter.HandleFunc("", f.TusOptions).Methods(http.MethodOptions)
+	uploadRouter.HandleFunc("", f.CreateUpload).Methods(http.MethodPost)
+	uploadRouter.HandleFunc("/{id}", f.UploadStatus).Methods(http.MethodHead)
+	uploadRouter.HandleFunc("/{id}", f.AppendUpload).Methods(http.MethodPatch)
+	uploadRouter.HandleFunc("/{id}", f.TerminateUpload).Methods(http.MethodDelete)
+	uploadRouter.Use(f.TusResumable, f.RequireToken)
+	return r
+}
+
+// tusRequest is a tus request with the API token
+func tusRequest(method, url string, body io.Reader, headers ...string) *http.Request {
+	req := httptest.NewRequest(method, url, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return req
+}
+
+func do(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func createUpload(t *testing.T, h http.Handler, length int) string {
+	t.Helper()
+	rec := do(h, tusRequest(http.MethodPost, "/uploads", nil, "Upload-Length", strconv.Itoa(length)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the upload to be created got %d %s", rec.Code, rec.Body)
+	}
+	return rec.Header().Get("Location")
+}
+
+func patch(location string, offset int, body io.Reader) *http.Request {
+	return tusRequest(http.MethodPatch, location, body,
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", strconv.Itoa(offset))
+}
+
+func TestTusRequiresVersionAndToken(t *testing.T) {
+	h := tusRouter(newTestFiles(t))
+
+	req := tusRequest(http.MethodPost, "/uploads", nil, "Upload-Length", "10", "Tus-Resumable", "0.2.2")
+	if rec := do(h, req); rec.Code != http.StatusPreconditionFailed || rec.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("expected another version to be refused got %d", rec.Code)
+	}
+
+	req = tusRequest(http.MethodPost, "/uploads", nil, "Upload-Length", "10")
+	req.Header.Del("Authorization")
+	if rec := do(h, req); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected an upload without token to be refused got %d", rec.Code)
+	}
+
+	// discovering the server needs neither
+	req = httptest.NewRequest(http.MethodOptions, "/uploads", nil)
+	if rec := do(h, req); rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Extension") != tusExtensions {
+		t.Errorf("expected the tus options got %d", rec.Code)
+	}
+}
+
+func TestTusResumeAndFinish(t *testing.T) {
+	f := newTestFiles(t)
+	h := tusRouter(f)
+	image := testPNG(t, 4, 4)
+	location := createUpload(t, h, len(image))
+
+	if rec := do(h, patch(location, 5, bytes.NewReader(image))); rec.Code != http.StatusConflict {
+		t.Errorf("expected an offset mismatch got %d", rec.Code)
+	}
+
+	// a request still writing the upload locks it
+	pr, pw := io.Pipe()
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- do(h, patch(location, 0, pr)) }()
+	if _, err := pw.Write(image[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(h, patch(location, 10, bytes.NewReader(image[10:]))); rec.Code != http.StatusLocked {
+		t.Errorf("expected the upload to be locked got %d", rec.Code)
+	}
+	// the connection drops after 10 bytes
+	pw.CloseWithError(io.ErrUnexpectedEOF)
+	select {
+	case <-first:
+	case <-time.After(time.Second):
+		t.Fatal("expected the interrupted request to end")
+	}
+
+	rec := do(h, tusRequest(http.MethodHead, location, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "10" || rec.Header().Get("Upload-Length") != strconv.Itoa(len(image)) {
+		t.Fatalf("expected to resume from 10 got %d %v", rec.Code, rec.Header())
+	}
+
+	rec = do(h, patch(location, 10, bytes.NewReader(image[10:])))
+	if rec.Code != http.StatusNoContent || !strings.HasPrefix(rec.Header().Get(imageURLHeader), "/images/") {
+		t.Fatalf("expected the upload to finish got %d %s", rec.Code, rec.Body)
+	}
+	id := strings.TrimPrefix(rec.Header().Get(imageURLHeader), "/images/")
+	if meta, err := f.store.Meta(id); err != nil || meta.Width != 4 || meta.ContentType != "image/png" {
+		t.Errorf("expected the image to be saved got %+v %v", meta, err)
+	}
+	// the finished upload tells the image
+	if rec := do(h, tusRequest(http.MethodHead, location, nil)); rec.Header().Get(imageURLHeader) != "/images/"+id {
+		t.Errorf("expected the image URL of the finished upload got %v", rec.Header())
+	}
+}
+
+func TestTusRejectsInvalidImage(t *testing.T) {
+	h := tusRouter(newTestFiles(t))
+	location := createUpload(t, h, 10)
+
+	rec := do(h, patch(location, 0, strings.NewReader("not an img")))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected the upload to fail the validation got %d %s", rec.Code, rec.Body)
+	}
+	// it can't pass on retry
+	if rec := do(h, tusRequest(http.MethodHead, location, nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the rejected upload to be removed got %d", rec.Code)
+	}
+}
+
+func TestTusBoundsUploads(t *testing.T) {
+	h := tusRouter(newTestFiles(t))
+	// the test Files take 2 uploads
+	createUpload(t, h, 10)
+	location := createUpload(t, h, 10)
+
+	if rec := do(h, tusRequest(http.MethodPost, "/uploads", nil, "Upload-Length", "10")); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a third upload to be refused got %d", rec.Code)
+	}
+	if rec := do(h, tusRequest(http.MethodDelete, location, nil)); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the upload to be terminated got %d", rec.Code)
+	}
+	createUpload(t, h, 10)
+}
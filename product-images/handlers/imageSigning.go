@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"product-images/signing"
+
+	"github.com/gorilla/mux"
+)
+
+// authorized checks the bearer token of requests signing URLs or changing
+// images, which is the signing token. Without a token configured they're
+// refused.
+func (f *Files) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if f.cfg.SigningCfg.Token == "" {
+		http.Error(w, "no API token is configured", http.StatusForbidden)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(f.cfg.SigningCfg.Token)) != 1 {
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// RequireToken refuses requests without the API token, see authorized.
+// OPTIONS requests, which clients send to discover what the server supports,
+// pass.
+func (f *Files) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions && !f.authorized(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifySignature checks the signed URL of a private image and returns when
+// it expires.
+func (f *Files) verifySignature(id string, q url.Values) (time.Time, error) {
+	if f.signer == nil {
+		return time.Time{}, fmt.Errorf("private images can't be served")
+	}
+	if err := f.signer.Verify(id, q, time.Now()); err != nil {
+		return time.Time{}, err
+	}
+	exp, _ := strconv.ParseInt(q.Get(signing.ParamExpires), 10, 64)
+	return time.Unix(exp, 0), nil
+}
+
+// SignURL mints a signed URL to an image, valid for expires_in seconds.
+// The variant the URL grants is chosen with the w, h, fit and q query
+// parameters, like with ServeImage. It requires the signing token as bearer
+// token, e.g. for product-api to link to unpublished product photos.
+func (f *Files) SignURL(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if f.signer == nil {
+		http.Error(w, "URL signing is not configured", http.StatusForbidden)
+		return
+	}
+	if !f.authorized(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	if _, err := f.resizeOptions(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl := f.cfg.SigningCfg.DefaultTTL
+	if v := q.Get("expires_in"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > f.cfg.SigningCfg.MaxTTL {
+			http.Error(w, fmt.Sprintf("expires_in should be between 1 and %d seconds", int(f.cfg.SigningCfg.MaxTTL.Seconds())), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	if _, err := f.store.Meta(id); err != nil {
+		f.imageError(w, id, err)
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	signed := f.signer.Sign(id, q, expires)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{f.imageURL(id) + "?" + signed.Encode(), expires.UTC()})
+}
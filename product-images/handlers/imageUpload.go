@@ -2,33 +2,65 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"product-images/configs"
+	"product-images/imaging"
+	"product-images/signing"
+	"product-images/storage"
+	"product-images/uploads"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
 
+// Storage stores the images and their metadata, see storage.Images
 type Storage interface {
-	Save(file multipart.File, header *multipart.FileHeader) (string, error)
+	Save(file io.Reader, ext string, meta *storage.Metadata) (*storage.Metadata, error)
+	Open(id string) (io.ReadSeekCloser, *storage.Object, error)
+	Meta(id string) (*storage.Metadata, error)
+	Find(filter storage.Filter) ([]*storage.Metadata, int)
+	SetPrivate(id string, private bool) (*storage.Metadata, error)
+	// Claim and Release add and remove a product the image belongs to,
+	// Release deletes it with the last product and returns nil then
+	Claim(id string, productID int) (*storage.Metadata, error)
+	Release(id string, productID int) (*storage.Metadata, error)
+	Delete(id string) error
+	// Legacy returns the id of an image stored at a legacy timestamped path
+	Legacy(unixTime, fileName string) (string, error)
 }
 
 type Files struct {
 	log   *logrus.Logger
 	store Storage
-	cfg   *configs.Config
+	cache *imaging.Cache
+	// signer verifies the URLs of private images, nil when no signing keys
+	// are configured and private images can't be served
+	signer *signing.Signer
+	// uploads keeps the resumable uploads until they are finished
+	uploads *uploads.Store
+	cfg     *configs.Config
 }
 
-func NewFiles(s Storage, l *logrus.Logger, cfg *configs.Config) *Files {
+func NewFiles(s Storage, c *imaging.Cache, signer *signing.Signer, u *uploads.Store, l *logrus.Logger, cfg *configs.Config) *Files {
 	return &Files{
-		log:   l,
-		store: s,
-		cfg:   cfg,
+		log:     l,
+		store:   s,
+		cache:   c,
+		signer:  signer,
+		uploads: u,
+		cfg:     cfg,
 	}
 }
 
@@ -37,33 +69,461 @@ func (f *Files) invalidURI(uri string, w http.ResponseWriter) {
 	http.Error(w, "Invalid file path should be in the format: /[id]/[filepath]", http.StatusBadRequest)
 }
 
+// multipartOverhead is the room left in the request body for the multipart
+// boundaries and headers around each image
+const multipartOverhead = 64 << 10
+
+// maxFieldBytes is the longest form field accepted next to the images
+const maxFieldBytes = 1 << 10
+
+// multipartImage is an "image" part spooled to a temporary file
+type multipartImage struct {
+	name string
+	file *os.File
+	size int64
+	// tooLarge is set when the part exceeded the maximum size, only the
+	// allowed bytes were kept
+	tooLarge bool
+}
+
+// fileResult is the outcome of saving one image of a multipart upload
+type fileResult struct {
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+	// Filepath and the metadata are set when the image was saved
+	Filepath string `json:"filepath,omitempty"`
+	*storage.Metadata
+	// Error is set when the image was rejected
+	Error *imaging.ValidationError `json:"error,omitempty"`
+}
+
+// UploadMultipart saves the image in the "image" form field once it passed
+// the upload validation, see imaging.Validate, and answers with its metadata.
+// The optional "product_id" form field records the product the image belongs
+// to, with "private" set to true the image is only served through signed
+// URLs. Either field requires the API token, see authorizedMetadata.
+// The parts are streamed to temporary files rather than buffered in memory.
+// With the "multiple" query parameter set to true several images are
+// accepted, they are answered with {"files": [...]} holding a result for
+// each of them however many were sent, with 207 Multi-Status when some of
+// them were rejected.
 func (f *Files) UploadMultipart(w http.ResponseWriter, r *http.Request) {
-	file, header, err := r.FormFile("image")
+	f.extendDeadlines(w)
+	limits := f.uploadLimits()
+	multiple, err := strconv.ParseBool(r.URL.Query().Get("multiple"))
+	if err != nil && r.URL.Query().Get("multiple") != "" {
+		http.Error(w, "multiple should be true or false", http.StatusBadRequest)
+		return
+	}
+	maxFiles := 1
+	if multiple {
+		maxFiles = f.cfg.UploadCfg.MaxFiles
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxFiles)*(limits.MaxBytes+multipartOverhead))
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "request should be multipart/form-data", http.StatusBadRequest)
+		return
+	}
+	images, fields, err := readMultipart(mr, limits.MaxBytes, maxFiles)
+	defer func() {
+		for _, img := range images {
+			img.file.Close()
+			os.Remove(img.file.Name())
+		}
+	}()
+	if err != nil {
+		var invalid *imaging.ValidationError
+		if errors.As(err, &invalid) {
+			f.validationFailed(w, invalid)
+			return
+		}
+		f.log.Error("Unable to read upload ", err)
+		http.Error(w, "Unable to read upload", http.StatusBadRequest)
+		return
+	}
+	if len(images) == 0 {
+		f.validationFailed(w, &imaging.ValidationError{Rule: imaging.RuleMissingFile, Message: "image form field is required"})
+		return
+	}
+
+	meta, err := uploadMetadata(fields.Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !f.authorizedMetadata(w, r, meta) {
+		return
+	}
+
+	if !multiple {
+		saved, err := f.savePart(images[0], meta)
+		if err != nil {
+			f.saveFailed(w, err)
+			return
+		}
+		f.imageSaved(w, http.StatusOK, saved)
+		return
+	}
+
+	status := http.StatusOK
+	results := make([]fileResult, 0, len(images))
+	for _, img := range images {
+		saved, err := f.savePart(img, meta)
+		if err != nil {
+			var invalid *imaging.ValidationError
+			if !errors.As(err, &invalid) {
+				// the storage failing fails the others as well
+				f.saveFailed(w, err)
+				return
+			}
+			f.log.Info("Rejected upload ", invalid)
+			results = append(results, fileResult{Name: img.name, Status: validationStatus(invalid), Error: invalid})
+			status = http.StatusMultiStatus
+			continue
+		}
+		results = append(results, fileResult{Name: img.name, Status: http.StatusCreated, Filepath: f.imageURL(saved.ID), Metadata: saved})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Files []fileResult `json:"files"`
+	}{results})
+}
+
+// savePart saves an image of a multipart upload, named after its file name.
+func (f *Files) savePart(img *multipartImage, meta *storage.Metadata) (*storage.Metadata, error) {
+	if img.tooLarge {
+		return nil, &imaging.ValidationError{
+			Rule:    imaging.RuleMaxSize,
+			Message: fmt.Sprintf("image exceeds the maximum of %d bytes", f.cfg.UploadCfg.MaxBytes),
+		}
+	}
+	m := *meta
+	m.Name = img.name
+	return f.saveImage(img.file, img.size, "", &m)
+}
+
+// readMultipart spools every "image" part to a temporary file and returns
+// them along with the other form fields, which may come in any order. The
+// images are returned on error too, for the caller to remove.
+func readMultipart(mr *multipart.Reader, maxBytes int64, maxFiles int) ([]*multipartImage, url.Values, error) {
+	images := []*multipartImage{}
+	fields := url.Values{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return images, fields, nil
+		}
+		if err != nil {
+			return images, fields, tooLarge(err)
+		}
+
+		if part.FormName() != "image" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldBytes))
+			if err != nil {
+				return images, fields, tooLarge(err)
+			}
+			fields.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if len(images) == maxFiles {
+			message := fmt.Sprintf("upload exceeds the maximum of %d images", maxFiles)
+			if maxFiles == 1 {
+				message = "upload has several images, set multiple to true to upload them"
+			}
+			return images, fields, &imaging.ValidationError{Rule: imaging.RuleMaxFiles, Message: message}
+		}
+		tmp, err := os.CreateTemp("", "upload-*")
+		if err != nil {
+			return images, fields, err
+		}
+		img := &multipartImage{name: part.FileName(), file: tmp}
+		images = append(images, img)
+
+		img.size, err = io.Copy(tmp, io.LimitReader(part, maxBytes))
+		if err == nil {
+			// the rest of a too large image is skipped, the body limit
+			// bounds how much that is
+			var skipped int64
+			skipped, err = io.Copy(io.Discard, part)
+			img.tooLarge = skipped > 0
+		}
+		if err != nil {
+			return images, fields, tooLarge(err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return images, fields, err
+		}
+	}
+}
+
+// tooLarge turns the error of reading past the request body limit into a
+// *imaging.ValidationError.
+func tooLarge(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &imaging.ValidationError{
+			Rule:    imaging.RuleMaxSize,
+			Message: fmt.Sprintf("upload exceeds the maximum of %d bytes", tooLarge.Limit),
+		}
+	}
+	return err
+}
+
+// UploadRaw saves the request body as the image named name, streaming it to
+// a temporary file. The Content-Length and Content-Type headers are required,
+// so that too large uploads and disallowed types are refused before the body
+// is read. The content type detected from the body has to match the declared
+// one. Like with UploadMultipart the "product_id" and "private" query
+// parameters record the product and whether the image is private, and
+// require the API token.
+func (f *Files) UploadRaw(w http.ResponseWriter, r *http.Request) {
+	f.extendDeadlines(w)
+	name := mux.Vars(r)["name"]
+	limits := f.uploadLimits()
+
+	if !validName(name) {
+		http.Error(w, "name should be a file name", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength < 0 {
+		http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+		return
+	}
+	if r.ContentLength > limits.MaxBytes {
+		f.validationFailed(w, &imaging.ValidationError{
+			Rule:    imaging.RuleMaxSize,
+			Message: fmt.Sprintf("image of %d bytes exceeds the maximum of %d bytes", r.ContentLength, limits.MaxBytes),
+		})
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !limits.Allows(contentType) {
+		f.validationFailed(w, &imaging.ValidationError{
+			Rule:    imaging.RuleType,
+			Message: fmt.Sprintf("Content-Type should be one of %v", limits.AllowedTypes),
+		})
+		return
+	}
+	meta, err := uploadMetadata(r.URL.Query().Get)
 	if err != nil {
-		http.Error(w, "Error fetching file from req", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !f.authorizedMetadata(w, r, meta) {
+		return
+	}
+	meta.Name = name
 
-	// Save the file to storage
-	filePath, err := f.store.Save(file, header)
+	tmp, err := os.CreateTemp("", "upload-*")
 	if err != nil {
-		f.log.Error("Unable to save file", "error", err)
+		f.log.Error("Unable to create file ", err)
 		http.Error(w, "Unable to save file", http.StatusInternalServerError)
 		return
 	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	size, err := io.Copy(tmp, r.Body)
+	if err != nil {
+		f.log.Infof("Unable to read upload %s: %s", name, err)
+		http.Error(w, "Unable to read the request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		f.saveFailed(w, err)
+		return
+	}
+
+	saved, err := f.saveImage(tmp, size, contentType, meta)
+	if err != nil {
+		f.saveFailed(w, err)
+		return
+	}
+	w.Header().Set("Location", f.imageURL(saved.ID))
+	f.imageSaved(w, http.StatusCreated, saved)
+}
+
+// extendDeadlines gives the upload the configured timeout to be read and
+// answered instead of the server timeouts.
+func (f *Files) extendDeadlines(w http.ResponseWriter) {
+	deadline := time.Now().Add(f.cfg.UploadCfg.Timeout)
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		f.log.Debug("Unable to extend the read deadline ", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		f.log.Debug("Unable to extend the write deadline ", err)
+	}
+}
+
+// validName reports whether name is usable as the file name of an image.
+func validName(name string) bool {
+	return name != "" && len(name) <= 255 && name == filepath.Base(name) && name != "." && name != ".."
+}
 
-	// Return the file path to the client
+// imageSaved answers with the URL of the saved image and its metadata.
+func (f *Files) imageSaved(w http.ResponseWriter, status int, meta *storage.Metadata) {
 	response := struct {
 		Filepath string `json:"filepath"`
+		*storage.Metadata
 	}{
-		Filepath: filePath,
+		Filepath: f.imageURL(meta.ID),
+		Metadata: meta,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(response)
 }
 
+// authorizedMetadata requires the API token of uploads naming a product or
+// asking for a private image. Uploading an image identical to a stored one
+// adds the product and privacy to it, so anybody could otherwise claim or
+// hide the images of other products.
+func (f *Files) authorizedMetadata(w http.ResponseWriter, r *http.Request, meta *storage.Metadata) bool {
+	if !meta.Private && len(meta.ProductIDs) == 0 {
+		return true
+	}
+	return f.authorized(w, r)
+}
+
+// uploadMetadata reads the metadata of an upload from the "product_id" and
+// "private" fields, get returns a field or an empty string when it's missing.
+func uploadMetadata(get func(string) string) (*storage.Metadata, error) {
+	meta := &storage.Metadata{}
+	var err error
+	if v := get("product_id"); v != "" {
+		id, err := productID(v)
+		if err != nil {
+			return nil, err
+		}
+		meta.ProductIDs = []int{id}
+	}
+	if v := get("private"); v != "" {
+		meta.Private, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("private should be true or false")
+		}
+	}
+	return meta, nil
+}
+
+// saveImage validates the image of size bytes and saves it, named after its
+// real content type. When the client declared a content type, it has to
+// match the real one. Failed validations are returned as
+// *imaging.ValidationError.
+func (f *Files) saveImage(file io.ReadSeeker, size int64, declared string, meta *storage.Metadata) (*storage.Metadata, error) {
+	info, err := imaging.Validate(file, size, f.uploadLimits())
+	if err != nil {
+		return nil, err
+	}
+	if declared != "" && declared != info.ContentType {
+		return nil, &imaging.ValidationError{
+			Rule:    imaging.RuleType,
+			Message: fmt.Sprintf("content type %s doesn't match the declared %s", info.ContentType, declared),
+		}
+	}
+	m := *meta
+	m.ContentType, m.Width, m.Height = info.ContentType, info.Width, info.Height
+	if !f.cfg.UploadCfg.StripMetadata {
+		return f.storeImage(file, info.Ext(), &m)
+	}
+
+	// the cleaned image is streamed through a temporary file too
+	cleaned, err := os.CreateTemp("", "cleaned-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		cleaned.Close()
+		os.Remove(cleaned.Name())
+	}()
+	stripped, err := imaging.Strip(cleaned, file, info.ContentType, imaging.StripOptions{KeepICC: f.cfg.UploadCfg.KeepICC})
+	if err != nil {
+		return nil, fmt.Errorf("unable to strip the image metadata: %s", err)
+	}
+	if _, err := cleaned.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	m.Stripped, m.Orientation = stripped.Removed, stripped.Orientation
+	if stripped.Rotated() {
+		m.Width, m.Height = m.Height, m.Width
+	}
+	return f.storeImage(cleaned, info.Ext(), &m)
+}
+
+// ruleSharedPublic rejects an upload asking to make private an image other
+// products show publicly, see storage.ErrSharedPublic
+const ruleSharedPublic = "shared_public"
+
+// storeImage saves the image, refusing ErrSharedPublic like a failed
+// validation.
+func (f *Files) storeImage(file io.Reader, ext string, meta *storage.Metadata) (*storage.Metadata, error) {
+	saved, err := f.store.Save(file, ext, meta)
+	if errors.Is(err, storage.ErrSharedPublic) {
+		return nil, &imaging.ValidationError{Rule: ruleSharedPublic, Message: err.Error()}
+	}
+	return saved, err
+}
+
+// saveFailed answers with the validation error, or 500 when the image
+// couldn't be validated or saved.
+func (f *Files) saveFailed(w http.ResponseWriter, err error) {
+	var invalid *imaging.ValidationError
+	if errors.As(err, &invalid) {
+		f.validationFailed(w, invalid)
+		return
+	}
+	f.log.Error("Unable to save file ", err)
+	http.Error(w, "Unable to save file", http.StatusInternalServerError)
+}
+
+func (f *Files) imageURL(id string) string {
+	return fmt.Sprintf("%s/%s", f.cfg.MediaURL, id)
+}
+
+func (f *Files) uploadLimits() imaging.Limits {
+	return imaging.Limits{
+		MaxBytes:     f.cfg.UploadCfg.MaxBytes,
+		AllowedTypes: f.cfg.UploadCfg.AllowedTypes,
+		MaxWidth:     f.cfg.UploadCfg.MaxWidth,
+		MaxHeight:    f.cfg.UploadCfg.MaxHeight,
+	}
+}
+
+// validationFailed answers with the rule the upload failed as JSON, e.g.
+// {"error": {"rule": "type", "message": "..."}}
+func (f *Files) validationFailed(w http.ResponseWriter, e *imaging.ValidationError) {
+	f.log.Info("Rejected upload ", e)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(validationStatus(e))
+	json.NewEncoder(w).Encode(struct {
+		Error *imaging.ValidationError `json:"error"`
+	}{e})
+}
+
+func validationStatus(e *imaging.ValidationError) int {
+	switch e.Rule {
+	case imaging.RuleMissingFile:
+		return http.StatusBadRequest
+	case imaging.RuleMaxSize, imaging.RuleMaxFiles:
+		return http.StatusRequestEntityTooLarge
+	case imaging.RuleType:
+		return http.StatusUnsupportedMediaType
+	case ruleSharedPublic:
+		return http.StatusConflict
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
 func handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	buf, err := os.ReadFile("sid.png")
@@ -79,29 +539,81 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf)
 }
 
+// ServeImage serves the original image, or a resized variant when any of
+// the w, h, fit or q query parameters are given. Image URLs are content
+// addressed, so responses may be cached forever. Private images are only
+// served through signed URLs, see SignURL, and only cached until they expire.
 func (f *Files) ServeImage(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	unixTime := vars["unixTime"]
-	fileName := vars["fileName"]
-	imagePath := fmt.Sprintf("%s/%s/%s", f.cfg.ImageDIR, unixTime, fileName)
+	id := mux.Vars(r)["id"]
 
-	file, err := os.Open(imagePath)
+	opts, err := f.resizeOptions(r.URL.Query())
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// images which aren't indexed are public, any other failure to tell
+	// whether the image is private refuses it
+	cacheControl := "public, max-age=31536000, immutable"
+	meta, err := f.store.Meta(id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrInvalidID) {
+		f.log.Errorf("Unable to read the metadata of image %s: %s", id, err)
+		http.Error(w, "Unable to open image", http.StatusInternalServerError)
+		return
+	}
+	if err == nil && meta.Private {
+		expires, err := f.verifySignature(id, r.URL.Query())
+		if err != nil {
+			f.log.Infof("Refused private image %s: %s", id, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(time.Until(expires).Seconds()))
+	}
+
+	file, obj, err := f.store.Open(id)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrInvalidID) {
+			f.log.Errorf("Unable to open image %s: %s", id, err)
+			http.Error(w, "Unable to open image", http.StatusInternalServerError)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	defer func(file *os.File) {
+	defer func() {
 		err := file.Close()
 		if err != nil {
-			f.log.Errorf("Closing file failed after reading from serve image for path %s", imagePath)
+			f.log.Errorf("Closing file failed after reading from serve image for id %s", id)
 		}
-	}(file)
+	}()
+
+	w.Header().Set("Cache-Control", cacheControl)
+	if opts != nil {
+		w.Header().Set("ETag", fmt.Sprintf("%q", id+"-"+opts.String()))
+		f.serveVariant(w, r, file, obj, *opts)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%q", id))
+	http.ServeContent(w, r, obj.ID, obj.ModTime, file)
+}
 
-	fileInfo, err := file.Stat()
+// LegacyImage redirects the timestamped image URLs used before content
+// addressing to the image's stable URL, keeping the query.
+func (f *Files) LegacyImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := f.store.Legacy(vars["unixTime"], vars["fileName"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if !errors.Is(err, storage.ErrNotFound) {
+			f.log.Error("Unable to import legacy image ", err)
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+	target := f.imageURL(id)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
 }
@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"product-images/configs"
+	"product-images/imaging"
+	"product-images/signing"
+	"product-images/storage"
+	"product-images/uploads"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// testToken is the API token of the test Files
+const testToken = "token"
+
+// newTestFiles serves images stored in a temporary directory, private images
+// are signed with a test key.
+func newTestFiles(t *testing.T) *Files {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	cfg := configs.NewConfig(nil, filepath.Join(dir, "images"), "/images",
+		configs.NewServerConf(":0", nil, time.Minute, time.Minute, time.Minute),
+		configs.NewResizeConf([]configs.ImageSize{{Width: 100, Height: 100}}, filepath.Join(dir, "cache"), 1<<20),
+		configs.NewUploadConf(1<<20, []string{"image/png", "image/jpeg"}, 1000, 1000, 3, time.Minute, true, false),
+		configs.NewStorageConf("file", "", "", "", "", "", ""),
+		configs.NewSigningConf("k1:secret", testToken, time.Hour, 24*time.Hour),
+		configs.NewResumableConf(filepath.Join(dir, "uploads"), time.Hour, time.Hour, 2, 1<<20),
+	)
+
+	idx, err := storage.OpenIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := storage.NewImages(l, storage.NewFileStorage(l, cfg), idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := signing.ParseKeys(cfg.SigningCfg.Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signing.NewSigner(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := imaging.NewCache(l, cfg.ResizeCfg.CacheDIR, cfg.ResizeCfg.CacheMaxBytes)
+	return NewFiles(images, c, signer, uploads.NewStore(l, cfg.ResumableCfg.DIR, cfg.ResumableCfg.MaxUploads, cfg.ResumableCfg.MaxBytes), l, cfg)
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serve calls the handler with the route variables set, the token is sent as
+// bearer token unless empty.
+func serve(h http.HandlerFunc, req *http.Request, vars map[string]string, token string) *httptest.ResponseRecorder {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, mux.SetURLVars(req, vars))
+	return rec
+}
+
+func TestServePrivateImage(t *testing.T) {
+	f := newTestFiles(t)
+	meta, err := f.store.Save(bytes.NewReader(testPNG(t, 2, 2)), ".png", &storage.Metadata{ContentType: "image/png", Private: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": meta.ID}
+
+	rec := serve(f.ServeImage, httptest.NewRequest(http.MethodGet, "/images/"+meta.ID, nil), vars, "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected a private image without signature to be refused got %d", rec.Code)
+	}
+
+	q := f.signer.Sign(meta.ID, nil, time.Now().Add(time.Hour))
+	rec = serve(f.ServeImage, httptest.NewRequest(http.MethodGet, "/images/"+meta.ID+"?"+q.Encode(), nil), vars, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the signed image got %d %s", rec.Code, rec.Body)
+	}
+	cc := rec.Header().Get("Cache-Control")
+	maxAge, err := strconv.Atoi(strings.TrimPrefix(cc, "private, max-age="))
+	if err != nil || maxAge <= 0 || maxAge > 3600 {
+		t.Errorf("expected a private max-age up to the expiry got %q", cc)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), testPNG(t, 2, 2)) {
+		t.Error("expected the image content")
+	}
+
+	public, err := f.store.Save(bytes.NewReader(testPNG(t, 1, 1)), ".png", &storage.Metadata{ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = serve(f.ServeImage, httptest.NewRequest(http.MethodGet, "/images/"+public.ID, nil), map[string]string{"id": public.ID}, "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("expected the public image to be cached publicly got %d %q", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+}
+
+// failingMeta fails to read the metadata of any image
+type failingMeta struct {
+	Storage
+}
+
+func (failingMeta) Meta(string) (*storage.Metadata, error) {
+	return nil, fmt.Errorf("index unavailable")
+}
+
+func TestServeImageRefusesWithoutMetadata(t *testing.T) {
+	f := newTestFiles(t)
+	meta, err := f.store.Save(bytes.NewReader(testPNG(t, 2, 2)), ".png", &storage.Metadata{ContentType: "image/png", Private: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.store = failingMeta{f.store}
+
+	rec := serve(f.ServeImage, httptest.NewRequest(http.MethodGet, "/images/"+meta.ID, nil), map[string]string{"id": meta.ID}, "")
+	if rec.Code != http.StatusInternalServerError || bytes.Contains(rec.Body.Bytes(), testPNG(t, 2, 2)) {
+		t.Errorf("expected the image to be refused got %d", rec.Code)
+	}
+}
+
+func TestUpdateImageMetaRequiresToken(t *testing.T) {
+	f := newTestFiles(t)
+	meta, err := f.store.Save(bytes.NewReader(testPNG(t, 2, 2)), ".png", &storage.Metadata{ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{"id": meta.ID}
+	update := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/images/"+meta.ID+"/meta", strings.NewReader(`{"private": true}`))
+		return serve(f.UpdateImageMeta, req, vars, token)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := update(token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected token %q to be refused got %d", token, rec.Code)
+		}
+	}
+	if m, _ := f.store.Meta(meta.ID); m.Private {
+		t.Fatal("expected the refused update to leave the image public")
+	}
+
+	rec := update(testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the update got %d", rec.Code)
+	}
+	got := imageJSON{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Private {
+		t.Error("expected the image to be private")
+	}
+
+	// without a token configured nobody changes images
+	f.cfg.SigningCfg.Token = ""
+	if rec := update(testToken); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the update to be forbidden got %d", rec.Code)
+	}
+}
+
+func TestUploadRawRefusesMakingSharedImagePrivate(t *testing.T) {
+	f := newTestFiles(t)
+	body := testPNG(t, 3, 3)
+	upload := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/images/a.png?"+query, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "image/png")
+		return serve(f.UploadRaw, req, map[string]string{"name": "a.png"}, testToken)
+	}
+
+	if rec := upload("product_id=1"); rec.Code != http.StatusCreated {
+		t.Fatalf("expected the upload got %d %s", rec.Code, rec.Body)
+	}
+	// product 1 shows the image publicly
+	if rec := upload("product_id=2&private=true"); rec.Code != http.StatusConflict {
+		t.Fatalf("expected a conflict got %d %s", rec.Code, rec.Body)
+	}
+	rec := upload("product_id=1&private=true")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the owner to make the image private got %d %s", rec.Code, rec.Body)
+	}
+	saved := imageJSON{}
+	if err := json.NewDecoder(rec.Body).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Private {
+		t.Error("expected the image to be private")
+	}
+	// uploading it again doesn't publish it
+	if rec := upload("product_id=2"); rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"private":true`) {
+		t.Errorf("expected the image to stay private got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUploadRawRequiresTokenForMetadata(t *testing.T) {
+	f := newTestFiles(t)
+	body := testPNG(t, 3, 3)
+	upload := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/images/a.png?"+query, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "image/png")
+		return serve(f.UploadRaw, req, map[string]string{"name": "a.png"}, token)
+	}
+
+	rec := upload("", "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected an upload without metadata to need no token got %d %s", rec.Code, rec.Body)
+	}
+	saved := imageJSON{}
+	if err := json.NewDecoder(rec.Body).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	// uploading the same image again would change the stored one
+	for _, query := range []string{"private=true", "product_id=1", "product_id=1&private=true"} {
+		if rec := upload(query, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected %q without token to be refused got %d", query, rec.Code)
+		}
+		if rec := upload(query, "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected %q with a wrong token to be refused got %d", query, rec.Code)
+		}
+	}
+	if m, err := f.store.Meta(saved.ID); err != nil || m.Private || len(m.ProductIDs) != 0 {
+		t.Errorf("expected the image to stay public without products got %+v %v", m, err)
+	}
+}
+
+// multipartUpload is a request uploading the files as "image" parts along
+// with the form fields
+func multipartUpload(t *testing.T, query string, files map[string][]byte, fields map[string]string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		part, err := mw.CreateFormFile("image", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(files[name])
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/upload?"+query, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploadMultipartSingleImage(t *testing.T) {
+	f := newTestFiles(t)
+
+	req := multipartUpload(t, "", map[string][]byte{"a.png": testPNG(t, 2, 3)}, map[string]string{"product_id": "7"})
+	rec := serve(f.UploadMultipart, req, nil, testToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the image to be saved got %d %s", rec.Code, rec.Body)
+	}
+	saved := fileResult{}
+	if err := json.NewDecoder(rec.Body).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != "a.png" || saved.Width != 2 || saved.Height != 3 || !saved.HasProduct(7) || saved.Filepath != "/images/"+saved.ID {
+		t.Errorf("expected the metadata of the image got %+v", saved)
+	}
+
+	// several images are only accepted when asked for
+	req = multipartUpload(t, "", map[string][]byte{"a.png": testPNG(t, 2, 3), "b.png": testPNG(t, 1, 1)}, nil)
+	if rec := serve(f.UploadMultipart, req, nil, ""); rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "multiple") {
+		t.Errorf("expected several images to be refused got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUploadMultipartSeveralImages(t *testing.T) {
+	f := newTestFiles(t)
+	results := func(rec *httptest.ResponseRecorder) []fileResult {
+		t.Helper()
+		got := struct {
+			Files []fileResult `json:"files"`
+		}{}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got.Files
+	}
+
+	// the response has the same shape for a single image
+	req := multipartUpload(t, "multiple=true", map[string][]byte{"a.png": testPNG(t, 2, 2)}, nil)
+	rec := serve(f.UploadMultipart, req, nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the image to be saved got %d %s", rec.Code, rec.Body)
+	}
+	if files := results(rec); len(files) != 1 || files[0].Status != http.StatusCreated || files[0].Name != "a.png" {
+		t.Errorf("expected the result of the image got %+v", files)
+	}
+
+	req = multipartUpload(t, "multiple=true", map[string][]byte{
+		"a.png": testPNG(t, 2, 2),
+		"b.txt": []byte("not an image"),
+		"c.png": testPNG(t, 1, 1),
+	}, map[string]string{"private": "true"})
+	rec = serve(f.UploadMultipart, req, nil, testToken)
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("expected a result for each image got %d %s", rec.Code, rec.Body)
+	}
+	files := results(rec)
+	if len(files) != 3 {
+		t.Fatalf("expected 3 results got %+v", files)
+	}
+	for _, i := range []int{0, 2} {
+		if files[i].Status != http.StatusCreated || files[i].Metadata == nil || !files[i].Private || files[i].Filepath != "/images/"+files[i].ID {
+			t.Errorf("expected %s to be saved got %+v", files[i].Name, files[i])
+		}
+	}
+	if files[1].Name != "b.txt" || files[1].Status != http.StatusUnsupportedMediaType || files[1].Error == nil || files[1].Metadata != nil {
+		t.Errorf("expected b.txt to be rejected got %+v", files[1])
+	}
+
+	// the test Files take 3 images
+	four := map[string][]byte{}
+	for _, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		four[name] = testPNG(t, 1, 1)
+	}
+	if rec := serve(f.UploadMultipart, multipartUpload(t, "multiple=true", four, nil), nil, ""); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected too many images to be refused got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUploadMultipartRequiresTokenForMetadata(t *testing.T) {
+	f := newTestFiles(t)
+	image := testPNG(t, 2, 2)
+	meta, err := f.store.Save(bytes.NewReader(image), ".png", &storage.Metadata{ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same image uploaded again would be made private
+	for _, query := range []string{"", "multiple=true"} {
+		req := multipartUpload(t, query, map[string][]byte{"a.png": image}, map[string]string{"private": "true"})
+		if rec := serve(f.UploadMultipart, req, nil, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected making the image private without token to be refused with %q got %d %s", query, rec.Code, rec.Body)
+		}
+		req = multipartUpload(t, query, map[string][]byte{"a.png": image}, map[string]string{"product_id": "1"})
+		if rec := serve(f.UploadMultipart, req, nil, "wrong"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected claiming the image with a wrong token to be refused with %q got %d %s", query, rec.Code, rec.Body)
+		}
+	}
+	if m, err := f.store.Meta(meta.ID); err != nil || m.Private || len(m.ProductIDs) != 0 {
+		t.Errorf("expected the image to stay public without products got %+v %v", m, err)
+	}
+}
+
+func TestUploadRaw(t *testing.T) {
+	f := newTestFiles(t)
+	image := testPNG(t, 3, 2)
+	upload := func(contentType string, body []byte, length int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/images/a.png", bytes.NewReader(body))
+		req.ContentLength = length
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return serve(f.UploadRaw, req, map[string]string{"name": "a.png"}, "")
+	}
+
+	// refused before the body is read
+	if rec := upload("image/png", image, -1); rec.Code != http.StatusLengthRequired {
+		t.Errorf("expected Content-Length to be required got %d", rec.Code)
+	}
+	if rec := upload("image/png", image, 2<<20); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a too large image to be refused got %d", rec.Code)
+	}
+	for _, contentType := range []string{"", "image/gif", "image"} {
+		if rec := upload(contentType, image, int64(len(image))); rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("expected Content-Type %q to be refused got %d", contentType, rec.Code)
+		}
+	}
+
+	rec := upload("image/jpeg", image, int64(len(image)))
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "doesn't match the declared image/jpeg") {
+		t.Errorf("expected the declared type to be checked got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = upload("image/png", image, int64(len(image)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected the image to be saved got %d %s", rec.Code, rec.Body)
+	}
+	saved := imageJSON{}
+	if err := json.NewDecoder(rec.Body).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Header().Get("Location") != "/images/"+saved.ID || saved.Name != "a.png" || saved.Width != 3 || saved.ContentType != "image/png" {
+		t.Errorf("expected the image metadata got %s %+v", rec.Header().Get("Location"), saved)
+	}
+}
+
+func TestUploadOutlastsServerTimeouts(t *testing.T) {
+	f := newTestFiles(t)
+	r := mux.NewRouter()
+	r.HandleFunc("/images/{name}", f.UploadRaw).Methods(http.MethodPut)
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.ReadTimeout = 50 * time.Millisecond
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	// a slow client sends the image in two halves
+	image := testPNG(t, 2, 2)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(image[:len(image)/2])
+		time.Sleep(150 * time.Millisecond)
+		pw.Write(image[len(image)/2:])
+		pw.Close()
+	}()
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/images/a.png", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = int64(len(image))
+	req.Header.Set("Content-Type", "image/png")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected the slow upload to be saved got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,161 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Cache keeps resized variants on disk. When the variants take more than
+// maxBytes the least recently used ones are removed.
+type Cache struct {
+	log      *logrus.Logger
+	dir      string
+	maxBytes int64
+	mutex    *sync.Mutex
+	entries  map[string]*cacheEntry
+	size     int64
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// NewCache creates a Cache in dir and picks up the variants cached by a
+// previous run.
+func NewCache(l *logrus.Logger, dir string, maxBytes int64) *Cache {
+	c := &Cache{
+		log:      l,
+		dir:      dir,
+		maxBytes: maxBytes,
+		mutex:    &sync.Mutex{},
+		entries:  map[string]*cacheEntry{},
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		l.Errorf("unable to read image cache %s: %s", dir, err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".tmp-") {
+			// left behind by a crash while writing a variant
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		c.entries[f.Name()] = &cacheEntry{size: info.Size(), used: info.ModTime()}
+		c.size += info.Size()
+	}
+	c.evict()
+	return c
+}
+
+// Key returns the cache key of a variant of the source. The modification
+// time is part of the key so that a replaced source isn't served stale.
+func Key(source string, modTime time.Time, o Options) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s", source, modTime.UnixNano(), o)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Open returns the cached variant, os.ErrNotExist when it isn't cached.
+func (c *Cache) Open(key string) (*os.File, error) {
+	c.mutex.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		e.used = time.Now()
+	}
+	c.mutex.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(c.dir, key))
+}
+
+// Put caches the variant written by write and opens it. A failed write
+// leaves nothing behind.
+func (c *Cache) Put(key string, write func(w io.Writer) error) (*os.File, error) {
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create image cache: %s", err)
+	}
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cached image: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("unable to write cached image: %s", err)
+	}
+
+	path := filepath.Join(c.dir, key)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("unable to write cached image: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.size
+	}
+	c.entries[key] = &cacheEntry{size: info.Size(), used: time.Now()}
+	c.size += info.Size()
+	c.mutex.Unlock()
+
+	c.evict()
+	return f, nil
+}
+
+// evict removes the least recently used variants until the cache fits.
+// Variants being served stay readable until they are closed.
+func (c *Cache) evict() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.size <= c.maxBytes {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].used.Before(c.entries[keys[j]].used)
+	})
+
+	for _, k := range keys {
+		if c.size <= c.maxBytes {
+			return
+		}
+		if err := os.Remove(filepath.Join(c.dir, k)); err != nil && !os.IsNotExist(err) {
+			c.log.Errorf("unable to evict cached image %s: %s", k, err)
+			continue
+		}
+		c.size -= c.entries[k].size
+		delete(c.entries, k)
+	}
+}
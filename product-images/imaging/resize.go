@@ -0,0 +1,175 @@
+// Package imaging resizes images and caches the resized variants on disk.
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder, GIFs are resized to PNG
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Fit is how an image is fitted into the requested width and height.
+type Fit string
+
+const (
+	// FitContain scales the whole image to fit inside the box, keeping the aspect ratio
+	FitContain Fit = "contain"
+	// FitCover scales the image to fill the box and crops what overflows, centred
+	FitCover Fit = "cover"
+)
+
+// DefaultQuality is the JPEG quality used when none is requested.
+const DefaultQuality = 85
+
+// MaxPixels is the largest source image which is decoded for resizing, it
+// guards against images which are small on disk but huge in memory.
+const MaxPixels = 50 * 1000 * 1000
+
+// Options describe a resized variant of an image. A zero Width or Height
+// follows the aspect ratio of the source.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     Fit
+	Quality int
+}
+
+// String identifies the options in cache keys and logs.
+func (o Options) String() string {
+	return fmt.Sprintf("w%d-h%d-%s-q%d", o.Width, o.Height, o.Fit, o.Quality)
+}
+
+// Decode reads a JPEG, PNG or GIF image and returns it with its format name.
+// Images with more than MaxPixels pixels are rejected before decoding.
+func Decode(r io.ReadSeeker) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read image: %s", err)
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, "", fmt.Errorf("image of %dx%d is too large to resize", cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decode image: %s", err)
+	}
+	return img, format, nil
+}
+
+// Encode writes the image as JPEG when the source format is jpeg, and as PNG
+// otherwise.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	if format == "jpeg" {
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	return png.Encode(w, img)
+}
+
+// Resize returns the image scaled to the options. Images are never enlarged,
+// a box larger than the source keeps the source size.
+func Resize(src image.Image, o Options) image.Image {
+	b := src.Bounds()
+	sw, sh := float64(b.Dx()), float64(b.Dy())
+	if sw == 0 || sh == 0 {
+		return src
+	}
+
+	w, h := float64(o.Width), float64(o.Height)
+	switch {
+	case w == 0 && h == 0:
+		w, h = sw, sh
+	case w == 0:
+		w = sw * h / sh
+	case h == 0:
+		h = sh * w / sw
+	}
+
+	var scale float64
+	crop := image.Rectangle{Min: b.Min, Max: b.Max}
+	if o.Fit == FitCover {
+		scale = math.Min(math.Max(w/sw, h/sh), 1)
+		// the part of the source which ends up in the box, centred
+		cw, ch := int(math.Round(math.Min(w/scale, sw))), int(math.Round(math.Min(h/scale, sh)))
+		crop.Min = b.Min.Add(image.Pt((b.Dx()-cw)/2, (b.Dy()-ch)/2))
+		crop.Max = crop.Min.Add(image.Pt(cw, ch))
+	} else {
+		scale = math.Min(math.Min(w/sw, h/sh), 1)
+	}
+
+	dw := maxInt(int(math.Round(float64(crop.Dx())*scale)), 1)
+	dh := maxInt(int(math.Round(float64(crop.Dy())*scale)), 1)
+	if dw == b.Dx() && dh == b.Dy() {
+		return src
+	}
+	return scaleBox(src, crop, dw, dh)
+}
+
+// scaleBox scales the crop of src to dw x dh, every destination pixel is the
+// average of the source pixels it covers.
+func scaleBox(src image.Image, crop image.Rectangle, dw, dh int) *image.RGBA {
+	// premultiplied RGBA averages without colour fringes at transparent edges
+	s := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(s, s.Bounds(), src, crop.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	fx := float64(crop.Dx()) / float64(dw)
+	fy := float64(crop.Dy()) / float64(dh)
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := span(y, fy, crop.Dy())
+		for x := 0; x < dw; x++ {
+			x0, x1 := span(x, fx, crop.Dx())
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				i := s.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(s.Pix[i])
+					g += uint64(s.Pix[i+1])
+					b += uint64(s.Pix[i+2])
+					a += uint64(s.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels covered by destination pixel i, at least one.
+func span(i int, f float64, limit int) (int, int) {
+	lo := int(float64(i) * f)
+	hi := int(float64(i+1) * f)
+	if hi > limit {
+		hi = limit
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+package imaging
+
+import (
+	"image"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2000, 1000))
+
+	tests := []struct {
+		opts Options
+		want image.Point
+	}{
+		{Options{Width: 300, Height: 300, Fit: FitContain}, image.Pt(300, 150)},
+		{Options{Width: 300, Height: 300, Fit: FitCover}, image.Pt(300, 300)},
+		{Options{Width: 600}, image.Pt(600, 300)},
+		{Options{Height: 100}, image.Pt(200, 100)},
+		// images are never enlarged
+		{Options{Width: 4000, Height: 4000, Fit: FitContain}, image.Pt(2000, 1000)},
+		{Options{Width: 1500, Height: 1500, Fit: FitCover}, image.Pt(1500, 1000)},
+	}
+	for _, tt := range tests {
+		if got := Resize(src, tt.opts).Bounds().Size(); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.opts, tt.want, got)
+		}
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(logrus.New(), t.TempDir(), 10)
+	put := func(key string) {
+		f, err := c.Put(key, func(w io.Writer) error {
+			_, err := w.Write([]byte("12345"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	put("a")
+	put("b")
+	// a was used more recently than b
+	time.Sleep(time.Millisecond)
+	f, err := c.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	put("c")
+
+	if _, err := c.Open("b"); !os.IsNotExist(err) {
+		t.Errorf("expected b to be evicted, got %v", err)
+	}
+	for _, k := range []string{"a", "c"} {
+		f, err := c.Open(k)
+		if err != nil {
+			t.Errorf("expected %s to be cached, got %v", k, err)
+			continue
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,433 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// Kinds of metadata Strip removes
+const (
+	MetadataEXIF = "exif"
+	MetadataXMP  = "xmp"
+	MetadataICC  = "icc"
+)
+
+// orientedQuality is the JPEG quality images are encoded in after their
+// orientation was applied, they are the originals all variants derive from
+const orientedQuality = 95
+
+// StripOptions configures Strip.
+type StripOptions struct {
+	// KeepICC keeps the ICC color profile
+	KeepICC bool
+}
+
+// Stripped tells what Strip changed.
+type Stripped struct {
+	// Removed are the kinds of metadata removed, see MetadataEXIF
+	Removed []string
+	// Orientation is the EXIF orientation applied to the pixels, zero when
+	// the image didn't need to be rotated or flipped
+	Orientation int
+}
+
+// Rotated reports whether the width and height of the image were swapped.
+func (s *Stripped) Rotated() bool {
+	return s.Orientation >= 5 && s.Orientation <= 8
+}
+
+// Strip writes the image of the content type to w without its EXIF, XMP and
+// ICC metadata. Phone cameras store photos as they were taken along with an
+// EXIF orientation, which isn't applied everywhere, so the orientation is
+// applied to the pixels before the EXIF data is dropped. That decodes and
+// encodes the image, otherwise the metadata is cut out and the image data is
+// copied as is. Images of other types than JPEG and PNG are copied as is.
+// The image is read from the start of r and streamed, a first pass finds the
+// orientation.
+func Strip(w io.Writer, r io.ReadSeeker, contentType string, opts StripOptions) (*Stripped, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var strip func(w io.Writer, r io.Reader, opts StripOptions) (*stripped, error)
+	switch contentType {
+	case "image/jpeg":
+		strip = stripJPEG
+	case "image/png":
+		strip = stripPNG
+	default:
+		_, err := io.Copy(w, r)
+		return &Stripped{}, err
+	}
+
+	s, err := strip(io.Discard, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if s.Orientation <= 1 || s.Orientation > 8 {
+		s.Orientation = 0
+		if _, err := strip(w, r, opts); err != nil {
+			return nil, err
+		}
+		return &s.Stripped, nil
+	}
+
+	img, format, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	// the encoders write no metadata, the kept profile is put back
+	iw := &insertWriter{w: w, at: s.head, valid: s.validHead, insert: s.kept}
+	if err := Encode(iw, orient(img, s.Orientation), format, orientedQuality); err != nil {
+		return nil, err
+	}
+	if len(iw.head) < iw.at {
+		return nil, errUnexpectedEncoding
+	}
+	return &s.Stripped, nil
+}
+
+// stripped tells what was stripped from an image along with the segments or
+// chunks which were kept and have to survive encoding the image again
+type stripped struct {
+	Stripped
+	kept [][]byte
+	// the kept segments or chunks are put after the first head bytes of the
+	// encoded image, validHead checks they are what the format expects
+	head      int
+	validHead func(head []byte) bool
+}
+
+func (s *stripped) removed(kind string) {
+	for _, k := range s.Removed {
+		if k == kind {
+			return
+		}
+	}
+	s.Removed = append(s.Removed, kind)
+}
+
+var errUnexpectedEncoding = fmt.Errorf("unexpected image encoding")
+
+// insertWriter writes the insert segments or chunks after the first at bytes.
+type insertWriter struct {
+	w      io.Writer
+	at     int
+	valid  func(head []byte) bool
+	insert [][]byte
+	head   []byte
+}
+
+func (iw *insertWriter) Write(p []byte) (int, error) {
+	n := 0
+	if len(iw.head) < iw.at {
+		n = iw.at - len(iw.head)
+		if n > len(p) {
+			n = len(p)
+		}
+		iw.head = append(iw.head, p[:n]...)
+		p = p[n:]
+		if len(iw.head) < iw.at {
+			return n, nil
+		}
+		if !iw.valid(iw.head) {
+			return n, errUnexpectedEncoding
+		}
+		for _, b := range append([][]byte{iw.head}, iw.insert...) {
+			if _, err := iw.w.Write(b); err != nil {
+				return n, err
+			}
+		}
+	}
+	m, err := iw.w.Write(p)
+	return n + m, err
+}
+
+var (
+	jpegEXIF        = []byte("Exif\x00\x00")
+	jpegXMP         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegXMPExtended = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	jpegICC         = []byte("ICC_PROFILE\x00")
+)
+
+// JPEG markers
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+	markerAPP2 = 0xe2
+)
+
+// stripJPEG drops the APP1 segments holding EXIF and XMP data and the APP2
+// segments holding the ICC profile. The entropy coded data after the start
+// of scan is copied as is.
+func stripJPEG(w io.Writer, r io.Reader, opts StripOptions) (*stripped, error) {
+	br, bw := bufio.NewReader(r), bufio.NewWriter(w)
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(br, soi); err != nil || soi[0] != 0xff || soi[1] != markerSOI {
+		return nil, fmt.Errorf("not a JPEG image")
+	}
+	s := &stripped{head: 2, validHead: func(head []byte) bool { return head[0] == 0xff && head[1] == markerSOI }}
+	bw.Write(soi)
+
+	for i := int64(2); ; {
+		m, err := br.Peek(2)
+		if len(m) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(m) < 2 || m[0] != 0xff {
+			return nil, fmt.Errorf("invalid JPEG marker at %d", i)
+		}
+		marker := m[1]
+		switch {
+		case marker == 0xff:
+			// fill byte
+			br.Discard(1)
+			i++
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			if _, err := io.Copy(bw, br); err != nil {
+				return nil, err
+			}
+			return s, bw.Flush()
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// markers without a segment
+			bw.Write(m)
+			br.Discard(2)
+			i += 2
+			continue
+		}
+		header, err := br.Peek(4)
+		if len(header) < 4 {
+			if err != io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("truncated JPEG segment at %d", i)
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 2 {
+			return nil, fmt.Errorf("invalid JPEG segment at %d", i)
+		}
+		segment := make([]byte, 2+length)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			if err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("truncated JPEG segment at %d", i)
+		}
+		payload := segment[4:]
+		i += int64(len(segment))
+
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(payload, jpegEXIF):
+			s.removed(MetadataEXIF)
+			if s.Orientation == 0 {
+				s.Orientation = exifOrientation(payload[len(jpegEXIF):])
+			}
+		case marker == markerAPP1 && (bytes.HasPrefix(payload, jpegXMP) || bytes.HasPrefix(payload, jpegXMPExtended)):
+			s.removed(MetadataXMP)
+		case marker == markerAPP2 && bytes.HasPrefix(payload, jpegICC) && !opts.KeepICC:
+			s.removed(MetadataICC)
+		case marker == markerAPP2 && bytes.HasPrefix(payload, jpegICC):
+			s.kept = append(s.kept, segment)
+			bw.Write(segment)
+		default:
+			bw.Write(segment)
+		}
+	}
+	return s, bw.Flush()
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// textMetadata are the keywords of PNG text chunks holding metadata, the
+// "Raw profile type" ones are written by ImageMagick
+var textMetadata = map[string]string{
+	"XML:com.adobe.xmp":     MetadataXMP,
+	"Raw profile type xmp":  MetadataXMP,
+	"Raw profile type exif": MetadataEXIF,
+	"Raw profile type APP1": MetadataEXIF,
+	"Raw profile type icc":  MetadataICC,
+	"Raw profile type icm":  MetadataICC,
+}
+
+// colorChunks are the chunks describing the colors of a PNG, they are put
+// back when the image is encoded again
+var colorChunks = map[string]bool{"gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true}
+
+// pngHead is the signature and the IHDR chunk, which comes first
+var pngHead = len(pngSignature) + 12 + 13
+
+// stripPNG drops the eXIf chunk, the iCCP chunk and text chunks holding
+// metadata. Only the chunks which may hold metadata are read into memory, the
+// image data is copied as is.
+func stripPNG(w io.Writer, r io.Reader, opts StripOptions) (*stripped, error) {
+	br, bw := bufio.NewReader(r), bufio.NewWriter(w)
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, fmt.Errorf("not a PNG image")
+	}
+	s := &stripped{head: pngHead, validHead: func(head []byte) bool {
+		return string(head[len(pngSignature)+4:len(pngSignature)+8]) == "IHDR"
+	}}
+	bw.Write(signature)
+
+	header := make([]byte, 8)
+	for i := int64(len(pngSignature)); ; {
+		if n, err := io.ReadFull(br, header); err != nil {
+			if n == 0 && err == io.EOF {
+				break
+			}
+			if err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("truncated PNG chunk at %d", i)
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:])
+
+		switch typ {
+		case "eXIf", "iCCP", "tEXt", "zTXt", "iTXt", "gAMA", "cHRM", "sRGB":
+		default:
+			bw.Write(header)
+			if _, err := io.CopyN(bw, br, length+4); err != nil {
+				if err != io.EOF {
+					return nil, err
+				}
+				return nil, fmt.Errorf("truncated PNG chunk at %d", i)
+			}
+			i += 12 + length
+			if typ == "IEND" {
+				return s, bw.Flush()
+			}
+			continue
+		}
+
+		// the payload and CRC, bounded by the size of the image
+		rest, err := io.ReadAll(io.LimitReader(br, length+4))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(rest)) < length+4 {
+			return nil, fmt.Errorf("truncated PNG chunk at %d", i)
+		}
+		chunk, payload := append(append([]byte{}, header...), rest...), rest[:length]
+		i += 12 + length
+
+		kind := ""
+		switch typ {
+		case "eXIf":
+			kind = MetadataEXIF
+			if s.Orientation == 0 {
+				s.Orientation = exifOrientation(payload)
+			}
+		case "iCCP":
+			if !opts.KeepICC {
+				kind = MetadataICC
+			}
+		case "tEXt", "zTXt", "iTXt":
+			keyword, _, _ := bytes.Cut(payload, []byte{0})
+			kind = textMetadata[string(keyword)]
+			if kind == MetadataICC && opts.KeepICC {
+				kind = ""
+			}
+		}
+		if kind != "" {
+			s.removed(kind)
+			continue
+		}
+		if colorChunks[typ] {
+			s.kept = append(s.kept, chunk)
+		}
+		bw.Write(chunk)
+	}
+	return s, bw.Flush()
+}
+
+// exifOrientation returns the orientation tag of IFD0 of the TIFF encoded
+// EXIF data, zero when there is none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd+2 > int64(len(tiff)) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		e := ifd + 2 + int64(n)*12
+		if e+12 > int64(len(tiff)) {
+			return 0
+		}
+		// the orientation is a SHORT, stored in the value field
+		if order.Uint16(tiff[e:]) == 0x0112 && order.Uint16(tiff[e+2:]) == 3 {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
+
+// orient rotates and flips the image as the EXIF orientation tells, 1 is
+// upright, 2 to 4 are mirrored or upside down and 5 to 8 are on the side.
+// The source is converted a row at a time straight into its place in the
+// oriented image.
+func orient(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	row := image.NewRGBA(image.Rect(0, 0, w, 1))
+
+	for y := 0; y < h; y++ {
+		draw.Draw(row, row.Rect, src, image.Pt(b.Min.X, b.Min.Y+y), draw.Src)
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], row.Pix[4*x:4*x+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,175 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// exifTIFF is EXIF data holding only the orientation
+func exifTIFF(orientation int) []byte {
+	b := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	b = binary.BigEndian.AppendUint16(b, 0x0112)
+	b = binary.BigEndian.AppendUint16(b, 3)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(orientation))
+	return append(b, 0, 0, 0, 0, 0, 0)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	b := []byte{0xff, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(append(b, typ...), data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+}
+
+func TestStripJPEG(t *testing.T) {
+	// the left half is red, the right half blue
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(src, src.Bounds(), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 20, 20), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	icc := jpegSegment(markerAPP2, append(jpegICC, "profile"...))
+	var data []byte
+	data = append(data, buf.Bytes()[:2]...)
+	data = append(data, jpegSegment(markerAPP1, append(jpegEXIF, exifTIFF(6)...))...)
+	data = append(data, jpegSegment(markerAPP1, append(jpegXMP, "<x:xmpmeta/>"...))...)
+	data = append(data, icc...)
+	data = append(data, buf.Bytes()[2:]...)
+
+	out := &bytes.Buffer{}
+	s, err := Strip(out, bytes.NewReader(data), "image/jpeg", StripOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Removed, []string{MetadataEXIF, MetadataXMP, MetadataICC}) || s.Orientation != 6 || !s.Rotated() {
+		t.Errorf("unexpected stripped %+v", s)
+	}
+	if bytes.Contains(out.Bytes(), jpegEXIF) || bytes.Contains(out.Bytes(), jpegICC) {
+		t.Error("expected the metadata to be removed")
+	}
+
+	// rotated clockwise the red half is on top
+	img, err := jpeg.Decode(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(20, 40) {
+		t.Fatalf("expected the image to be rotated to 20x40, got %v", size)
+	}
+	if r, _, b, _ := img.At(10, 5).RGBA(); r < b {
+		t.Errorf("expected the top to be red, got %v", img.At(10, 5))
+	}
+
+	out.Reset()
+	s, err = Strip(out, bytes.NewReader(data), "image/jpeg", StripOptions{KeepICC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Removed, []string{MetadataEXIF, MetadataXMP}) || !bytes.Contains(out.Bytes(), icc) {
+		t.Errorf("expected the color profile to be kept, got %+v", s)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+	// IHDR follows the signature and is 25 bytes long
+	ihdr := len(pngSignature) + 25
+	var data []byte
+	data = append(data, clean[:ihdr]...)
+	data = append(data, pngChunk("eXIf", exifTIFF(1))...)
+	data = append(data, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))...)
+	data = append(data, pngChunk("tEXt", []byte("Title\x00Shoe"))...)
+	data = append(data, clean[ihdr:]...)
+
+	out := &bytes.Buffer{}
+	s, err := Strip(out, bytes.NewReader(data), "image/png", StripOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// upright images are copied without the metadata, not encoded again
+	want := append(append(append([]byte{}, clean[:ihdr]...), pngChunk("tEXt", []byte("Title\x00Shoe"))...), clean[ihdr:]...)
+	if !reflect.DeepEqual(s.Removed, []string{MetadataEXIF, MetadataXMP}) || s.Orientation != 0 || !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("unexpected stripped %+v", s)
+	}
+}
+
+func TestStripRotatesPNG(t *testing.T) {
+	// the top half is red, the bottom half blue
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(src, src.Bounds(), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 40, 10), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, src); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+	gama := pngChunk("gAMA", []byte{0, 0, 0xb1, 0x8f})
+	var data []byte
+	data = append(data, clean[:pngHead]...)
+	data = append(data, gama...)
+	data = append(data, pngChunk("eXIf", exifTIFF(8))...)
+	data = append(data, clean[pngHead:]...)
+
+	out := &bytes.Buffer{}
+	s, err := Strip(out, bytes.NewReader(data), "image/png", StripOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Removed, []string{MetadataEXIF}) || s.Orientation != 8 {
+		t.Errorf("unexpected stripped %+v", s)
+	}
+	if !bytes.Equal(out.Bytes()[pngHead:pngHead+len(gama)], gama) {
+		t.Error("expected the gamma to be put back after IHDR")
+	}
+
+	// rotated counterclockwise the red half is on the left
+	img, err := png.Decode(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(20, 40) {
+		t.Fatalf("expected the image to be rotated to 20x40, got %v", size)
+	}
+	if r, _, b, _ := img.At(5, 20).RGBA(); r < b {
+		t.Errorf("expected the left to be red, got %v", img.At(5, 20))
+	}
+}
+
+func TestStripRefusesTruncatedImages(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if _, err := Strip(io.Discard, bytes.NewReader(data[:len(data)-6]), "image/png", StripOptions{}); err == nil {
+		t.Error("expected a truncated PNG to be refused")
+	}
+	jpg := append([]byte{0xff, markerSOI}, jpegSegment(markerAPP1, jpegEXIF)[:6]...)
+	if _, err := Strip(io.Discard, bytes.NewReader(jpg), "image/jpeg", StripOptions{}); err == nil {
+		t.Error("expected a truncated JPEG to be refused")
+	}
+	if _, err := Strip(io.Discard, bytes.NewReader([]byte{0xff, markerSOI, 0xff, markerAPP1, 0, 1}), "image/jpeg", StripOptions{}); err == nil {
+		t.Error("expected an invalid segment length to be refused")
+	}
+}
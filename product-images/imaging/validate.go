@@ -0,0 +1,112 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+)
+
+// Rules of the upload validation, reported with every ValidationError
+const (
+	RuleMissingFile = "missing_file"
+	RuleMaxSize     = "max_size"
+	RuleType        = "type"
+	RuleCorrupt     = "corrupt"
+	RuleDimensions  = "dimensions"
+	RuleMaxFiles    = "max_files"
+)
+
+// ValidationError tells which rule an upload failed.
+type ValidationError struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Rule, e.Message)
+}
+
+// Limits are the rules uploaded images have to pass.
+type Limits struct {
+	MaxBytes     int64
+	AllowedTypes []string
+	MaxWidth     int
+	MaxHeight    int
+}
+
+// Info describes a validated image.
+type Info struct {
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// extensions of the content types images are stored with
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// Ext returns the file extension for the content type of the image.
+func (i *Info) Ext() string {
+	return extensions[i.ContentType]
+}
+
+// Validate checks the image of size bytes against the limits. The content
+// type is sniffed from the magic bytes, the name of the upload isn't trusted.
+// The image is fully decoded to catch truncated or corrupt data, after its
+// header was checked against the maximum dimensions.
+func Validate(r io.ReadSeeker, size int64, limits Limits) (*Info, error) {
+	if limits.MaxBytes > 0 && size > limits.MaxBytes {
+		return nil, &ValidationError{RuleMaxSize, fmt.Sprintf("image of %d bytes exceeds the maximum of %d bytes", size, limits.MaxBytes)}
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, &ValidationError{RuleCorrupt, "unable to read image"}
+	}
+	info := &Info{ContentType: http.DetectContentType(head[:n])}
+	if !limits.Allows(info.ContentType) {
+		return nil, &ValidationError{RuleType, fmt.Sprintf("content type %s is not allowed, expected one of %v", info.ContentType, limits.AllowedTypes)}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, &ValidationError{RuleCorrupt, fmt.Sprintf("unable to read the image header: %s", err)}
+	}
+	info.Width, info.Height = cfg.Width, cfg.Height
+	if (limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight) {
+		return nil, &ValidationError{RuleDimensions, fmt.Sprintf("image of %dx%d exceeds the maximum of %dx%d", cfg.Width, cfg.Height, limits.MaxWidth, limits.MaxHeight)}
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return nil, &ValidationError{RuleDimensions, "image is empty"}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, _, err := image.Decode(r); err != nil {
+		return nil, &ValidationError{RuleCorrupt, fmt.Sprintf("unable to decode the image: %s", err)}
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	return info, err
+}
+
+// Allows reports whether images of the content type are accepted.
+func (l Limits) Allows(contentType string) bool {
+	for _, t := range l.AllowedTypes {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
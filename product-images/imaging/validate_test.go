@@ -0,0 +1,45 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatal(err)
+	}
+	img := buf.Bytes()
+	limits := Limits{MaxBytes: 1 << 20, AllowedTypes: []string{"image/png"}, MaxWidth: 400, MaxHeight: 400}
+
+	info, err := Validate(bytes.NewReader(img), int64(len(img)), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContentType != "image/png" || info.Ext() != ".png" || info.Width != 200 || info.Height != 100 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	small := limits
+	small.MaxWidth = 100
+	tests := map[string]struct {
+		data   []byte
+		limits Limits
+	}{
+		RuleMaxSize:    {img, Limits{MaxBytes: 10, AllowedTypes: limits.AllowedTypes}},
+		RuleType:       {[]byte("GIF89a not really"), limits},
+		RuleCorrupt:    {img[:len(img)-20], limits},
+		RuleDimensions: {img, small},
+	}
+	for rule, tt := range tests {
+		_, err := Validate(bytes.NewReader(tt.data), int64(len(tt.data)), tt.limits)
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || invalid.Rule != rule {
+			t.Errorf("expected rule %s to fail, got %v", rule, err)
+		}
+	}
+}
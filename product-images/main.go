@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"strconv"
 	"time"
 
 	"product-images/configs"
@@ -17,12 +19,67 @@ var imagedDIR = "./tmp/images"
 var mediaURL = "/images"
 var allowedHosts = []string{"http://localhost:8000"}
 
+// sizes the storefront requests thumbnails and product photos in
+var imageSizes = []configs.ImageSize{{Width: 100, Height: 100}, {Width: 300, Height: 300}, {Width: 600}, {Width: 1200}}
+var imageCacheDIR = "./tmp/cache"
+var imageCacheMaxBytes int64 = 512 << 20
+
+// limits of uploaded images
+var uploadMaxBytes int64 = 10 << 20
+var uploadAllowedTypes = []string{"image/jpeg", "image/png", "image/gif"}
+var uploadMaxWidth = 6000
+var uploadMaxHeight = 6000
+var uploadMaxFiles = 20
+
+// uploads may take longer than the server timeouts allow other requests
+var uploadTimeout = 10 * time.Minute
+
+// uploaded photos are stored without their location and camera metadata,
+// rotated as their EXIF orientation tells, unless UPLOAD_STRIP_METADATA is
+// false. UPLOAD_KEEP_ICC keeps their color profile.
+var uploadStripMetadata = envBool("UPLOAD_STRIP_METADATA", true)
+var uploadKeepICC = envBool("UPLOAD_KEEP_ICC", false)
+
+// resumable uploads, abandoned ones are removed after a day. At most 100
+// uploads of 1 GiB in total are in progress at once.
+var resumableDIR = "./tmp/uploads"
+var resumableExpireAfter = 24 * time.Hour
+var resumableGCInterval = time.Hour
+var resumableMaxUploads = 100
+var resumableMaxBytes int64 = 1 << 30
+
+// the storage backend is chosen by the environment, "file" or "s3", so that
+// the S3 credentials aren't kept in code
+var storageBackend = envOr("STORAGE_BACKEND", "file")
+var s3Endpoint = envOr("S3_ENDPOINT", "http://localhost:9000")
+var s3Bucket = envOr("S3_BUCKET", "product-images")
+var s3Region = envOr("S3_REGION", "us-east-1")
+var s3AccessKey = os.Getenv("S3_ACCESS_KEY")
+var s3SecretKey = os.Getenv("S3_SECRET_KEY")
+
+// metadata of the images, for listing them without walking the storage
+var imageIndexFile = "./tmp/index.json"
+
+// private images are served through signed URLs only, the keys are
+// comma separated id:secret pairs and the first one signs new URLs, so that
+// keys can be rotated by prepending a new one. The token is the bearer token
+// of the clients signing URLs and changing or deleting images.
+var urlSigningKeys = os.Getenv("URL_SIGNING_KEYS")
+var urlSigningToken = os.Getenv("URL_SIGNING_TOKEN")
+var signedURLTTL = time.Hour
+var signedURLMaxTTL = 7 * 24 * time.Hour
+
 func main() {
 
 	l := logger.NewLogger(logLevel)
 
 	sCfg := configs.NewServerConf(bindAddress, allowedHosts, 120*time.Second, 15*time.Second, 15*time.Second)
-	cfg := configs.NewConfig(allowedHosts, imagedDIR, mediaURL, sCfg)
+	rCfg := configs.NewResizeConf(imageSizes, imageCacheDIR, imageCacheMaxBytes)
+	uCfg := configs.NewUploadConf(uploadMaxBytes, uploadAllowedTypes, uploadMaxWidth, uploadMaxHeight, uploadMaxFiles, uploadTimeout, uploadStripMetadata, uploadKeepICC)
+	stCfg := configs.NewStorageConf(storageBackend, s3Endpoint, s3Bucket, s3Region, s3AccessKey, s3SecretKey, imageIndexFile)
+	sgCfg := configs.NewSigningConf(urlSigningKeys, urlSigningToken, signedURLTTL, signedURLMaxTTL)
+	rsCfg := configs.NewResumableConf(resumableDIR, resumableExpireAfter, resumableGCInterval, resumableMaxUploads, resumableMaxBytes)
+	cfg := configs.NewConfig(allowedHosts, imagedDIR, mediaURL, sCfg, rCfg, uCfg, stCfg, sgCfg, rsCfg)
 
 	r := router.NewLocalRouter(l, cfg)
 	routerHandler := r.GetRouter()
@@ -40,3 +97,20 @@ func main() {
 	s.GraceFulShutDown(10 * time.Second)
 
 }
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool reads a boolean from the environment, def is used when it's unset
+// or invalid.
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(envOr(key, strconv.FormatBool(def)))
+	if err != nil {
+		return def
+	}
+	return v
+}
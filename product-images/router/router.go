@@ -1,12 +1,16 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
 	"product-images/configs"
 	"product-images/handlers"
+	"product-images/imaging"
 	localMiddleware "product-images/middlewares"
+	"product-images/signing"
 	"product-images/storage"
+	"product-images/uploads"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/gorilla/mux"
@@ -36,9 +40,25 @@ func (lr *LocalRouter) GetRouter() *mux.Router {
 
 	r := mux.NewRouter()
 
-	s := storage.NewFileStorage(lr.l, lr.cfg)
+	s, err := storage.New(lr.l, lr.cfg)
+	if err != nil {
+		lr.l.Fatalf("Unable to initialize the %s storage: %s", lr.cfg.StorageCfg.Backend, err)
+	}
+	idx, err := storage.OpenIndex(lr.cfg.StorageCfg.IndexFile)
+	if err != nil {
+		lr.l.Fatalf("Unable to open the image index: %s", err)
+	}
+	images, err := storage.NewImages(lr.l, s, idx)
+	if err != nil {
+		lr.l.Fatalf("Unable to index the images: %s", err)
+	}
+	// resized variants are cached on disk, see Files.ServeImage
+	c := imaging.NewCache(lr.l, lr.cfg.ResizeCfg.CacheDIR, lr.cfg.ResizeCfg.CacheMaxBytes)
 	// Initialize the files handler
-	files := handlers.NewFiles(s, lr.l, lr.cfg)
+	// partial resumable uploads, abandoned ones are removed in the background
+	u := uploads.NewStore(lr.l, lr.cfg.ResumableCfg.DIR, lr.cfg.ResumableCfg.MaxUploads, lr.cfg.ResumableCfg.MaxBytes)
+	go u.CollectEvery(context.Background(), lr.cfg.ResumableCfg.GCInterval, lr.cfg.ResumableCfg.ExpireAfter)
+	files := handlers.NewFiles(images, c, lr.signer(), u, lr.l, lr.cfg)
 
 	ops := middleware.RedocOpts{SpecURL: "/swagger.yaml"}
 	sh := middleware.Redoc(ops, nil)
@@ -51,6 +71,24 @@ func (lr *LocalRouter) GetRouter() *mux.Router {
 	// fileAPIs
 	fileUploadRouter := r.Methods(http.MethodPost).Subrouter()
 	fileUploadRouter.HandleFunc("/upload", files.UploadMultipart)
+	fileUploadRouter.HandleFunc("/images/{id}/signed-url", files.SignURL)
+
+	// tus resumable uploads
+	uploadRouter := r.PathPrefix("/uploads").Subrouter()
+	uploadRouter.HandleFunc("", files.TusOptions).Methods(http.MethodOptions)
+	uploadRouter.HandleFunc("", files.CreateUpload).Methods(http.MethodPost)
+	uploadRouter.HandleFunc("/{id}", files.TusOptions).Methods(http.MethodOptions)
+	uploadRouter.HandleFunc("/{id}", files.UploadStatus).Methods(http.MethodHead)
+	uploadRouter.HandleFunc("/{id}", files.AppendUpload).Methods(http.MethodPatch)
+	uploadRouter.HandleFunc("/{id}", files.TerminateUpload).Methods(http.MethodDelete)
+	uploadRouter.Use(files.TusResumable, files.RequireToken)
+
+	imagePutRouter := r.Methods(http.MethodPut).Subrouter()
+	imagePutRouter.HandleFunc("/images/{name}", files.UploadRaw)
+	imagePutRouter.HandleFunc("/images/{id}/products/{product_id}", files.ClaimImage)
+
+	imageUpdateRouter := r.Methods(http.MethodPatch).Subrouter()
+	imageUpdateRouter.HandleFunc("/images/{id}/meta", files.UpdateImageMeta)
 
 	// Serve static files from the "static" directory
 	fs := http.FileServer(http.Dir("static"))
@@ -62,7 +100,32 @@ func (lr *LocalRouter) GetRouter() *mux.Router {
 	})
 
 	imageAccessRouter := r.Methods(http.MethodGet).Subrouter()
-	imageAccessRouter.HandleFunc("/images/{unixTime}/{fileName}", files.ServeImage)
+	imageAccessRouter.HandleFunc("/images", files.ListImages)
+	imageAccessRouter.HandleFunc("/images/{id}/meta", files.ImageMeta)
+	imageAccessRouter.HandleFunc("/images/{id}", files.ServeImage)
+	imageAccessRouter.HandleFunc("/images/{unixTime}/{fileName}", files.LegacyImage)
 	imageAccessRouter.Use(mw.GzipMiddleware)
+
+	imageDeleteRouter := r.Methods(http.MethodDelete).Subrouter()
+	imageDeleteRouter.HandleFunc("/images/{id}", files.DeleteImage)
+	imageDeleteRouter.HandleFunc("/images/{id}/products/{product_id}", files.ReleaseImage)
 	return r
 }
+
+// signer returns the signer of private image URLs, nil when no keys are
+// configured.
+func (lr *LocalRouter) signer() *signing.Signer {
+	keys, err := signing.ParseKeys(lr.cfg.SigningCfg.Keys)
+	if err != nil {
+		lr.l.Fatalf("Unable to parse the URL signing keys: %s", err)
+	}
+	if len(keys) == 0 {
+		lr.l.Warn("No URL signing keys configured, private images can't be served")
+		return nil
+	}
+	s, err := signing.NewSigner(keys)
+	if err != nil {
+		lr.l.Fatalf("Unable to initialize the URL signer: %s", err)
+	}
+	return s
+}
@@ -27,14 +27,19 @@ type Server struct {
 
 // NewServer creates and returns a new instance of Server
 func NewServer(handler http.Handler, cfg *configs.Config, l *logrus.Logger) *Server {
-	ch := gorillaHandlers.CORS(gorillaHandlers.AllowedOrigins(cfg.AllowedHosts))
+	ch := gorillaHandlers.CORS(
+		gorillaHandlers.AllowedOrigins(cfg.AllowedHosts),
+		gorillaHandlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}),
+		gorillaHandlers.AllowedHeaders(tusHeaders),
+		gorillaHandlers.ExposedHeaders(tusHeaders),
+	)
 
 	return &Server{
 		Router: handler,
 		log:    l,
 		Srv: &http.Server{
 			Addr:         cfg.ServerCfg.Addr,
-			Handler:      ch(handler),
+			Handler:      tusDiscovery(ch(handler), handler),
 			IdleTimeout:  cfg.ServerCfg.IdleTimeOut,
 			ReadTimeout:  cfg.ServerCfg.ReadTimeOut,
 			WriteTimeout: cfg.ServerCfg.WriteTimeOut,
@@ -42,6 +47,25 @@ func NewServer(handler http.Handler, cfg *configs.Config, l *logrus.Logger) *Ser
 	}
 }
 
+// tusHeaders are the headers of the tus protocol browsers have to be allowed
+// to send and read, see handlers.CreateUpload
+var tusHeaders = []string{
+	"Content-Type", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+	"Upload-Length", "Upload-Offset", "Upload-Metadata", "Image-URL",
+}
+
+// tusDiscovery passes the OPTIONS requests tus clients discover the server
+// with to the handler, only CORS preflight requests are answered by cors.
+func tusDiscovery(cors, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		cors.ServeHTTP(w, r)
+	})
+}
+
 // GraceFulShutDown waits for an interrupt signal and gracefully shuts down the handlers
 func (s *Server) GraceFulShutDown(killTime time.Duration) {
 	stopCh := make(chan os.Signal, 1)
@@ -0,0 +1,137 @@
+// Package signing signs image URLs with HMAC-SHA256 so that private images
+// can only be fetched through URLs minted by a holder of the keys, until the
+// URLs expire.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingSignature = fmt.Errorf("missing url signature")
+	ErrExpired          = fmt.Errorf("url signature expired")
+	ErrUnknownKey       = fmt.Errorf("url signed with an unknown key")
+	ErrInvalidSignature = fmt.Errorf("invalid url signature")
+)
+
+// Query parameters of a signed URL
+const (
+	ParamExpires   = "expires"
+	ParamKeyID     = "kid"
+	ParamSignature = "sig"
+)
+
+// VariantParams are the query parameters selecting a resized variant of an
+// image, they are signed so that a URL only grants the variant it was minted for.
+var VariantParams = []string{"w", "h", "fit", "q"}
+
+// Key is a signing key, its id is sent with the URL so that the key used to
+// sign it can be looked up.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer signs URLs with its first key and verifies them with any of its
+// keys. Keys are rotated by adding a new first key and removing old keys
+// once the URLs they signed expired.
+type Signer struct {
+	current Key
+	keys    map[string][]byte
+}
+
+func NewSigner(keys []Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one signing key is required")
+	}
+	s := &Signer{
+		current: keys[0],
+		keys:    map[string][]byte{},
+	}
+	for _, k := range keys {
+		if k.ID == "" || len(k.Secret) == 0 {
+			return nil, fmt.Errorf("signing keys need an id and a secret")
+		}
+		if _, ok := s.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key %q", k.ID)
+		}
+		s.keys[k.ID] = k.Secret
+	}
+	return s, nil
+}
+
+// ParseKeys parses keys given as comma separated id:secret pairs, the first
+// key is the one URLs are signed with.
+func ParseKeys(spec string) ([]Key, error) {
+	keys := []Key{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid signing key %q, expected id:secret", pair)
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// Sign returns the query of a URL to the image, or to the variant selected
+// by the VariantParams of variant, which is valid until expires.
+func (s *Signer) Sign(id string, variant url.Values, expires time.Time) url.Values {
+	q := url.Values{}
+	for _, p := range VariantParams {
+		if v := variant.Get(p); v != "" {
+			q.Set(p, v)
+		}
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q.Set(ParamExpires, exp)
+	q.Set(ParamKeyID, s.current.ID)
+	q.Set(ParamSignature, sign(s.current.Secret, id, exp, q))
+	return q
+}
+
+// Verify checks the signature in the query of a request for the image.
+func (s *Signer) Verify(id string, q url.Values, now time.Time) error {
+	sig, exp := q.Get(ParamSignature), q.Get(ParamExpires)
+	if sig == "" || exp == "" {
+		return ErrMissingSignature
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if now.Unix() >= expires {
+		return ErrExpired
+	}
+	secret, ok := s.keys[q.Get(ParamKeyID)]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(secret, id, exp, q))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// sign returns the signature of the image id, expiry and variant, e.g. of
+// "v1\n<id>\n<expires>\nw=300&h=&fit=cover&q=".
+func sign(secret []byte, id, expires string, q url.Values) string {
+	variant := make([]string, 0, len(VariantParams))
+	for _, p := range VariantParams {
+		variant = append(variant, p+"="+url.QueryEscape(q.Get(p)))
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("v1\n" + id + "\n" + expires + "\n" + strings.Join(variant, "&")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
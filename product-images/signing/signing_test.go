@@ -0,0 +1,73 @@
+package signing
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	old, err := NewSigner([]Key{{ID: "k1", Secret: []byte("first")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := old.Sign("a.png", url.Values{"w": {"300"}, "fit": {"cover"}, "other": {"x"}}, now.Add(time.Hour))
+	if q.Get("other") != "" || q.Get(ParamKeyID) != "k1" {
+		t.Fatalf("unexpected signed query %s", q.Encode())
+	}
+
+	// after a rotation URLs signed with the former key are still valid
+	rotated, err := NewSigner([]Key{{ID: "k2", Secret: []byte("second")}, {ID: "k1", Secret: []byte("first")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rotated.Verify("a.png", q, now); err != nil {
+		t.Errorf("expected the URL to verify after the rotation, got %v", err)
+	}
+	if q := rotated.Sign("a.png", nil, now.Add(time.Hour)); q.Get(ParamKeyID) != "k2" {
+		t.Errorf("expected new URLs to be signed with k2, got %s", q.Get(ParamKeyID))
+	}
+
+	tests := map[string]struct {
+		modify func(url.Values)
+		id     string
+		now    time.Time
+		err    error
+	}{
+		"other image":   {id: "b.png", now: now, err: ErrInvalidSignature},
+		"expired":       {id: "a.png", now: now.Add(time.Hour), err: ErrExpired},
+		"other variant": {id: "a.png", now: now, modify: func(q url.Values) { q.Set("w", "1200") }, err: ErrInvalidSignature},
+		"added variant": {id: "a.png", now: now, modify: func(q url.Values) { q.Set("q", "100") }, err: ErrInvalidSignature},
+		"extended":      {id: "a.png", now: now, modify: func(q url.Values) { q.Set(ParamExpires, "9999999999") }, err: ErrInvalidSignature},
+		"removed key":   {id: "a.png", now: now, modify: func(q url.Values) { q.Set(ParamKeyID, "k0") }, err: ErrUnknownKey},
+		"unsigned":      {id: "a.png", now: now, modify: func(q url.Values) { q.Del(ParamSignature) }, err: ErrMissingSignature},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := url.Values{}
+			for k, v := range q {
+				c[k] = v
+			}
+			if tt.modify != nil {
+				tt.modify(c)
+			}
+			if err := rotated.Verify(tt.id, c, tt.now); err != tt.err {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("k2:second, k1:first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "k2" || string(keys[1].Secret) != "first" {
+		t.Errorf("unexpected keys %+v", keys)
+	}
+	if _, err := ParseKeys("k1"); err == nil {
+		t.Error("expected a key without secret to be rejected")
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"product-images/configs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileStorage stores the images on the local file system at
+// {ImageDIR}/{id[0:2]}/{id[2:4]}/{id}.
+type FileStorage struct {
+	log    *logrus.Logger
+	cfg    *configs.Config
+	dir    string
+	legacy *legacyImages
+}
+
+func NewFileStorage(l *logrus.Logger, cfg *configs.Config) *FileStorage {
+	return &FileStorage{
+		log:    l,
+		cfg:    cfg,
+		dir:    cfg.ImageDIR,
+		legacy: newLegacyImages(l, cfg.ImageDIR),
+	}
+}
+
+// Save saves a file to the file system.
+// The content is hashed while it's written to a temporary file, which is then
+// moved to its content-addressed path unless an identical image is stored already.
+// The function returns the saved file, or an error if it fails to save the file.
+func (s *FileStorage) Save(file io.Reader, ext string) (*Object, error) {
+	id, err := s.store(file, ext)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stat(id)
+}
+
+func (s *FileStorage) Open(id string) (io.ReadSeekCloser, *Object, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, &Object{ID: id, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FileStorage) Stat(id string) (*Object, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Object{ID: id, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FileStorage) Delete(id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// List walks the shard directories, the temporary and legacy files are skipped.
+func (s *FileStorage) List() ([]*Object, error) {
+	objects := []*Object{}
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == s.dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if p != s.dir && !isShard(p, s.dir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !idPattern.MatchString(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, &Object{ID: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list images: %s", err)
+	}
+	return objects, nil
+}
+
+// isShard reports whether p is a shard directory, {dir}/ab or {dir}/ab/cd.
+func isShard(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	parts := []string{}
+	for rel != "." {
+		parts = append(parts, filepath.Base(rel))
+		rel = filepath.Dir(rel)
+	}
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if len(part) != 2 || !isHex(part) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *FileStorage) Legacy(unixTime, fileName string) (string, error) {
+	return s.legacy.id(unixTime, fileName, s.store)
+}
+
+func (s *FileStorage) path(id string) (string, error) {
+	key, err := objectKey(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// store writes the content to its content-addressed path and returns its id.
+func (s *FileStorage) store(file io.Reader, ext string) (string, error) {
+	tmp, id, err := spool(filepath.Join(s.dir, ".tmp"), file, ext)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("unable to save file: %s", err)
+	}
+
+	p, err := s.path(id)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); err == nil {
+		s.log.Debugf("image %s is stored already", id)
+		return id, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	if err != nil {
+		return "", fmt.Errorf("unable to create directory: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", fmt.Errorf("unable to save file: %s", err)
+	}
+	return id, nil
+}
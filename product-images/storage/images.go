@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Images stores images in a backend and maintains their metadata in an
+// index, so that they can be listed and described without reading the backend.
+type Images struct {
+	log     *logrus.Logger
+	backend Storage
+	index   *Index
+	// mutex serializes the changes of the metadata, so that an image shared
+	// by products isn't deleted while another product claims it
+	mutex *sync.Mutex
+	now   func() time.Time
+}
+
+// NewImages creates Images on top of the backend. When the index is empty
+// but the backend isn't, e.g. on the first start with an index, the index is
+// rebuilt from the stored images.
+func NewImages(l *logrus.Logger, backend Storage, index *Index) (*Images, error) {
+	i := &Images{
+		log:     l,
+		backend: backend,
+		index:   index,
+		mutex:   &sync.Mutex{},
+		now:     time.Now,
+	}
+	if index.Len() > 0 {
+		return i, nil
+	}
+	if err := i.Rebuild(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Rebuild indexes every stored image which isn't indexed yet.
+func (i *Images) Rebuild() error {
+	objects, err := i.backend.List()
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if _, ok := i.index.Get(obj.ID); ok {
+			continue
+		}
+		if _, err := i.indexObject(obj.ID, obj.ModTime); err != nil {
+			i.log.Errorf("unable to index image %s: %s", obj.ID, err)
+		}
+	}
+	i.log.Infof("indexed %d images", i.index.Len())
+	return nil
+}
+
+// Save stores the image described by meta and indexes it. The content type,
+// dimensions, name, products and privacy are taken from meta, the rest is
+// filled in. An identical image keeps its upload time, and is shared with the
+// products given. Uploading it again never publishes a private image, nor
+// makes private an image other products show publicly, which fails with
+// ErrSharedPublic.
+func (i *Images) Save(file io.Reader, ext string, meta *Metadata) (*Metadata, error) {
+	obj, err := i.backend.Save(file, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	// an identical image released by its last product in the meantime has
+	// been removed along with the content just stored
+	if _, err := i.backend.Stat(obj.ID); err != nil {
+		return nil, fmt.Errorf("unable to save image %s: %s", obj.ID, err)
+	}
+
+	m := *meta
+	if old, ok := i.index.Get(obj.ID); ok {
+		if m.Private && !old.Private && ownedByOthers(old, m.ProductIDs) {
+			return nil, ErrSharedPublic
+		}
+		m.UploadedAt = old.UploadedAt
+		products := m.ProductIDs
+		m.ProductIDs = old.ProductIDs
+		for _, id := range products {
+			m.AddProduct(id)
+		}
+		if m.Name == "" {
+			m.Name = old.Name
+		}
+		if len(m.Stripped) == 0 {
+			// an image uploaded already stripped stays described by the
+			// metadata the first upload had
+			m.Stripped, m.Orientation = old.Stripped, old.Orientation
+		}
+		m.Private = m.Private || old.Private
+	} else {
+		m.UploadedAt = i.now().UTC()
+	}
+	m.ID = obj.ID
+	m.Hash = obj.ID[:64]
+	m.Size = obj.Size
+
+	if err := i.index.Put(&m); err != nil {
+		return nil, fmt.Errorf("unable to index image: %s", err)
+	}
+	return &m, nil
+}
+
+// ownedByOthers reports whether the image belongs to a product other than
+// the given ones.
+func ownedByOthers(m *Metadata, productIDs []int) bool {
+	for _, id := range m.ProductIDs {
+		others := true
+		for _, p := range productIDs {
+			if p == id {
+				others = false
+			}
+		}
+		if others {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Images) Open(id string) (io.ReadSeekCloser, *Object, error) {
+	return i.backend.Open(id)
+}
+
+// Meta returns the metadata of the image, ErrNotFound when it isn't indexed.
+func (i *Images) Meta(id string) (*Metadata, error) {
+	if _, err := objectKey(id); err != nil {
+		return nil, err
+	}
+	m, ok := i.index.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m, nil
+}
+
+// SetPrivate marks the image private or public.
+func (i *Images) SetPrivate(id string, private bool) (*Metadata, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	m, err := i.Meta(id)
+	if err != nil {
+		return nil, err
+	}
+	m.Private = private
+	if err := i.index.Put(m); err != nil {
+		return nil, fmt.Errorf("unable to index image: %s", err)
+	}
+	return m, nil
+}
+
+// Find returns a page of the images matching the filter and their total.
+func (i *Images) Find(f Filter) ([]*Metadata, int) {
+	return i.index.Find(f)
+}
+
+// Claim makes the image belong to the product too.
+func (i *Images) Claim(id string, productID int) (*Metadata, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	m, err := i.Meta(id)
+	if err != nil {
+		return nil, err
+	}
+	if m.HasProduct(productID) {
+		return m, nil
+	}
+	m.AddProduct(productID)
+	if err := i.index.Put(m); err != nil {
+		return nil, fmt.Errorf("unable to index image: %s", err)
+	}
+	return m, nil
+}
+
+// Release makes the image no longer belong to the product and deletes it
+// once no product is left. It returns the metadata of the image, nil when it
+// was deleted. Images the product doesn't own are left as they are.
+func (i *Images) Release(id string, productID int) (*Metadata, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	m, err := i.Meta(id)
+	if err != nil {
+		return nil, err
+	}
+	if !m.HasProduct(productID) {
+		return m, nil
+	}
+	m.RemoveProduct(productID)
+	if len(m.ProductIDs) > 0 {
+		if err := i.index.Put(m); err != nil {
+			return nil, fmt.Errorf("unable to index image: %s", err)
+		}
+		return m, nil
+	}
+	return nil, i.delete(id)
+}
+
+// Delete removes the image from the backend and the index, for every product
+// it belongs to.
+func (i *Images) Delete(id string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.delete(id)
+}
+
+// delete removes the image, the mutex must be held.
+func (i *Images) delete(id string) error {
+	err := i.backend.Delete(id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if _, ok := i.index.Get(id); !ok {
+		return err
+	}
+	return i.index.Delete(id)
+}
+
+// Legacy imports the image at the legacy path and indexes it as uploaded at
+// the time of the path.
+func (i *Images) Legacy(unixTime, fileName string) (string, error) {
+	id, err := i.backend.Legacy(unixTime, fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := i.index.Get(id); ok {
+		return id, nil
+	}
+	sec, _ := strconv.ParseInt(unixTime, 10, 64)
+	if _, err := i.indexObject(id, time.Unix(sec, 0)); err != nil {
+		i.log.Errorf("unable to index legacy image %s: %s", id, err)
+	}
+	return id, nil
+}
+
+// indexObject reads the content type and dimensions of a stored image and
+// indexes it.
+func (i *Images) indexObject(id string, uploadedAt time.Time) (*Metadata, error) {
+	f, obj, err := i.backend.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	m := &Metadata{
+		ID:          id,
+		Hash:        id[:64],
+		Size:        obj.Size,
+		ContentType: strings.TrimSuffix(http.DetectContentType(head[:n]), "; charset=utf-8"),
+		UploadedAt:  uploadedAt.UTC(),
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// dimensions stay zero for formats without a registered decoder
+	if cfg, _, err := image.DecodeConfig(f); err == nil {
+		m.Width, m.Height = cfg.Width, cfg.Height
+	}
+
+	return m, i.index.Put(m)
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImagesIndex(t *testing.T) {
+	backend := newTestStorage(t)
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	idx, err := OpenIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := NewImages(logrus.New(), backend, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	images.now = func() time.Time { return now }
+
+	first, err := images.Save(bytes.NewReader(testPNG(t, 2, 1)), ".png", &Metadata{ContentType: "image/png", Width: 2, Height: 1, ProductIDs: []int{7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	second, err := images.Save(bytes.NewReader(testPNG(t, 1, 1)), ".png", &Metadata{ContentType: "image/png", Width: 1, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an identical upload keeps the upload time and product
+	again, err := images.Save(bytes.NewReader(testPNG(t, 2, 1)), ".png", &Metadata{ContentType: "image/png", Width: 2, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || !again.UploadedAt.Equal(first.UploadedAt) || !reflect.DeepEqual(again.ProductIDs, []int{7}) {
+		t.Errorf("expected the duplicate to keep %+v, got %+v", first, again)
+	}
+	// uploaded for another product it's shared rather than moved
+	again, err = images.Save(bytes.NewReader(testPNG(t, 2, 1)), ".png", &Metadata{ContentType: "image/png", Width: 2, Height: 1, ProductIDs: []int{8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.ProductIDs, []int{7, 8}) {
+		t.Errorf("expected the image to be shared by products 7 and 8, got %v", again.ProductIDs)
+	}
+
+	found, total := images.Find(Filter{})
+	if total != 2 || found[0].ID != second.ID || found[1].ID != first.ID {
+		t.Errorf("expected the latest upload first, got %d images", total)
+	}
+	found, total = images.Find(Filter{ProductID: 7})
+	if total != 1 || found[0].ID != first.ID {
+		t.Errorf("expected the image of product 7, got %d images", total)
+	}
+	_, total = images.Find(Filter{From: now})
+	if total != 1 {
+		t.Errorf("expected 1 image uploaded from %s, got %d", now, total)
+	}
+
+	// the image is deleted with the last product releasing it
+	if meta, err := images.Release(first.ID, 7); err != nil || !reflect.DeepEqual(meta.ProductIDs, []int{8}) {
+		t.Fatalf("expected product 8 to keep the image, got %+v %v", meta, err)
+	}
+	if meta, err := images.Release(first.ID, 7); err != nil || meta == nil {
+		t.Fatalf("expected releasing twice to leave the image, got %+v %v", meta, err)
+	}
+	if meta, err := images.Release(first.ID, 8); err != nil || meta != nil {
+		t.Fatalf("expected the image to be deleted, got %+v %v", meta, err)
+	}
+	if _, err := backend.Stat(first.ID); err != ErrNotFound {
+		t.Errorf("expected the released image to be deleted, got %v", err)
+	}
+
+	// an image without products is only deleted as a whole
+	if meta, err := images.Release(second.ID, 7); err != nil || meta == nil {
+		t.Fatalf("expected an image of no product to be left, got %+v %v", meta, err)
+	}
+	if meta, err := images.Claim(second.ID, 9); err != nil || !reflect.DeepEqual(meta.ProductIDs, []int{9}) {
+		t.Fatalf("expected product 9 to claim the image, got %+v %v", meta, err)
+	}
+
+	// the index is saved by its journal
+	reopened, err := OpenIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := reopened.Get(second.ID); !ok || !reflect.DeepEqual(m.ProductIDs, []int{9}) {
+		t.Errorf("expected the claim to be saved, got %+v", m)
+	}
+	if _, ok := reopened.Get(first.ID); ok {
+		t.Error("expected the released image to be removed from the index")
+	}
+
+	first, err = images.Save(bytes.NewReader(testPNG(t, 2, 1)), ".png", &Metadata{ContentType: "image/png", Width: 2, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := images.Delete(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := images.Meta(first.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := images.Delete(first.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	// a lost index is rebuilt from the storage
+	idx, err = OpenIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err = NewImages(logrus.New(), backend, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := images.Meta(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentType != "image/png" || meta.Width != 1 || meta.Height != 1 || meta.Size != second.Size {
+		t.Errorf("unexpected rebuilt metadata %+v", meta)
+	}
+}
+
+func TestIndexJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+
+	// an index written when images belonged to a single product
+	if err := os.WriteFile(path, []byte(`[{"id": "a", "product_id": 3}, {"id": "b"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := idx.Get("a"); !reflect.DeepEqual(m.ProductIDs, []int{3}) {
+		t.Errorf("expected the product of the old index, got %+v", m)
+	}
+
+	if err := idx.Put(&Metadata{ID: "c", ProductIDs: []int{4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if idx.changes != 2 {
+		t.Errorf("expected 2 journaled changes, got %d", idx.changes)
+	}
+
+	// a torn last change is skipped
+	f, err := os.OpenFile(idx.journalPath(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"put": {"id": "d"`)
+	f.Close()
+
+	idx, err = OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, total := idx.Find(Filter{})
+	if total != 2 || found[0].ID != "a" || found[1].ID != "c" {
+		t.Errorf("expected a and c, got %d images", total)
+	}
+	// the journal is folded into the index on open
+	if _, err := os.Stat(idx.journalPath()); !os.IsNotExist(err) {
+		t.Errorf("expected the journal to be removed, got %v", err)
+	}
+
+	for i := 0; i <= compactAfter; i++ {
+		if err := idx.Put(&Metadata{ID: "e"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if idx.changes != 0 {
+		t.Errorf("expected the index to be saved after %d changes, %d journaled", compactAfter, idx.changes)
+	}
+}
@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metadata describes an uploaded image.
+type Metadata struct {
+	ID string `json:"id"`
+	// Name is the file name the image was uploaded with, if any
+	Name string `json:"name,omitempty"`
+	// Hash is the hex SHA-256 of the content
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+	// ProductIDs are the products the image belongs to, sorted. Identical
+	// images uploaded for several products are stored once and shared.
+	ProductIDs []int `json:"product_ids,omitempty"`
+	// Private images are only served through signed URLs
+	Private bool `json:"private,omitempty"`
+	// Stripped are the kinds of metadata removed on upload, "exif", "xmp"
+	// and "icc"
+	Stripped []string `json:"stripped,omitempty"`
+	// Orientation is the EXIF orientation applied to the pixels on upload
+	Orientation int `json:"orientation,omitempty"`
+}
+
+// HasProduct reports whether the image belongs to the product.
+func (m *Metadata) HasProduct(id int) bool {
+	i := sort.SearchInts(m.ProductIDs, id)
+	return i < len(m.ProductIDs) && m.ProductIDs[i] == id
+}
+
+// AddProduct makes the image belong to the product too.
+func (m *Metadata) AddProduct(id int) {
+	if m.HasProduct(id) {
+		return
+	}
+	ids := append([]int{}, m.ProductIDs...)
+	m.ProductIDs = append(ids, id)
+	sort.Ints(m.ProductIDs)
+}
+
+// RemoveProduct makes the image no longer belong to the product.
+func (m *Metadata) RemoveProduct(id int) {
+	ids := make([]int, 0, len(m.ProductIDs))
+	for _, p := range m.ProductIDs {
+		if p != id {
+			ids = append(ids, p)
+		}
+	}
+	if len(ids) == 0 {
+		ids = nil
+	}
+	m.ProductIDs = ids
+}
+
+// indexEntry reads the entries of indexes written when an image belonged to
+// a single product
+type indexEntry struct {
+	*Metadata
+	ProductID int `json:"product_id,omitempty"`
+}
+
+func (e *indexEntry) metadata() *Metadata {
+	if e.ProductID != 0 && len(e.Metadata.ProductIDs) == 0 {
+		e.Metadata.ProductIDs = []int{e.ProductID}
+	}
+	return e.Metadata
+}
+
+// change is a line of the journal of an index
+type change struct {
+	Put    *indexEntry `json:"put,omitempty"`
+	Delete string      `json:"delete,omitempty"`
+}
+
+// compactAfter is the number of journaled changes after which the index is
+// saved as a whole and the journal started over
+const compactAfter = 1000
+
+// Filter selects images from the index, zero fields match every image.
+type Filter struct {
+	ProductID int
+	// From and To bound the upload time, To is exclusive
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+func (f *Filter) match(m *Metadata) bool {
+	if f.ProductID != 0 && !m.HasProduct(f.ProductID) {
+		return false
+	}
+	if !f.From.IsZero() && m.UploadedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !m.UploadedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// Index keeps the metadata of the images in memory and in a JSON file, so
+// that images can be listed without walking the storage. Changes are
+// appended to a journal next to the file, which is folded into the file
+// every compactAfter changes rather than the file being rewritten on each.
+type Index struct {
+	path    string
+	mutex   *sync.RWMutex
+	entries map[string]*Metadata
+	// changes is the number of changes in the journal
+	changes int
+}
+
+// OpenIndex loads the index saved at path and replays its journal. With an
+// empty path the index is kept in memory only.
+func OpenIndex(path string) (*Index, error) {
+	idx := &Index{
+		path:    path,
+		mutex:   &sync.RWMutex{},
+		entries: map[string]*Metadata{},
+	}
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read image index %s: %s", path, err)
+	}
+	if err == nil {
+		entries := []*indexEntry{}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("unable to parse image index %s: %s", path, err)
+		}
+		for _, e := range entries {
+			idx.entries[e.ID] = e.metadata()
+		}
+	}
+
+	if err := idx.replay(); err != nil {
+		return nil, err
+	}
+	if idx.changes > 0 {
+		if err := idx.save(); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+func (idx *Index) journalPath() string {
+	return idx.path + ".journal"
+}
+
+// replay applies the changes in the journal. A torn last line, left by a
+// crash while it was written, is skipped.
+func (idx *Index) replay() error {
+	data, err := os.ReadFile(idx.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read image index journal %s: %s", idx.journalPath(), err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	// the last line is empty unless a crash tore it while it was written
+	for _, line := range lines[:len(lines)-1] {
+		c := &change{}
+		if err := json.Unmarshal(line, c); err != nil {
+			return fmt.Errorf("unable to parse image index journal %s: %s", idx.journalPath(), err)
+		}
+		switch {
+		case c.Put != nil:
+			m := c.Put.metadata()
+			idx.entries[m.ID] = m
+		case c.Delete != "":
+			delete(idx.entries, c.Delete)
+		}
+		idx.changes++
+	}
+	return nil
+}
+
+// Len returns the number of indexed images.
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return len(idx.entries)
+}
+
+func (idx *Index) Get(id string) (*Metadata, bool) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	m, ok := idx.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c := *m
+	return &c, true
+}
+
+// Put adds or replaces the metadata of an image and journals the change.
+func (idx *Index) Put(m *Metadata) error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	c := *m
+	idx.entries[m.ID] = &c
+	return idx.journal(&change{Put: &indexEntry{Metadata: &c}})
+}
+
+// Delete removes the metadata of an image and journals the change.
+func (idx *Index) Delete(id string) error {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	delete(idx.entries, id)
+	return idx.journal(&change{Delete: id})
+}
+
+// Find returns the page of images matching the filter, latest uploads first,
+// and the number of images matching in total.
+func (idx *Index) Find(f Filter) ([]*Metadata, int) {
+	idx.mutex.RLock()
+	matches := []*Metadata{}
+	for _, m := range idx.entries {
+		if f.match(m) {
+			c := *m
+			matches = append(matches, &c)
+		}
+	}
+	idx.mutex.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].UploadedAt.Equal(matches[j].UploadedAt) {
+			return matches[i].UploadedAt.After(matches[j].UploadedAt)
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	total := len(matches)
+	if f.Offset >= total {
+		return []*Metadata{}, total
+	}
+	matches = matches[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(matches) {
+		matches = matches[:f.Limit]
+	}
+	return matches, total
+}
+
+// journal appends the change to the journal, or saves the whole index once
+// the journal is long enough. The mutex must be held.
+func (idx *Index) journal(c *change) error {
+	if idx.path == "" {
+		return nil
+	}
+	if idx.changes >= compactAfter {
+		return idx.save()
+	}
+
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(idx.journalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s", idx.journalPath(), err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write %s: %s", idx.journalPath(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", idx.journalPath(), err)
+	}
+	idx.changes++
+	return nil
+}
+
+// save writes the whole index and removes the journal it contains, the mutex
+// must be held.
+func (idx *Index) save() error {
+	if idx.path == "" {
+		return nil
+	}
+	entries := make([]*Metadata, 0, len(idx.entries))
+	for _, m := range idx.entries {
+		entries = append(entries, m)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(idx.path, data); err != nil {
+		return err
+	}
+	// replaying a journal left by a crash here repeats changes the index has
+	if err := os.Remove(idx.journalPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove %s: %s", idx.journalPath(), err)
+	}
+	idx.changes = 0
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so that a crash never leaves a half written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("unable to write %s: %s", path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write %s: %s", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"product-images/configs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// emptyHash is the SHA-256 of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Storage stores the images in a bucket of an S3-compatible object store,
+// at the key {id[0:2]}/{id[2:4]}/{id}. Requests are signed with AWS
+// signature version 4 and address the bucket by path, which works with AWS
+// as well as MinIO and similar stores.
+type S3Storage struct {
+	log       *logrus.Logger
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	legacy    *legacyImages
+	now       func() time.Time
+}
+
+func NewS3Storage(l *logrus.Logger, cfg *configs.Config) (*S3Storage, error) {
+	sc := cfg.StorageCfg
+	endpoint, err := url.Parse(sc.S3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", sc.S3Endpoint)
+	}
+	if sc.S3Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	return &S3Storage{
+		log:       l,
+		endpoint:  endpoint,
+		bucket:    sc.S3Bucket,
+		region:    sc.S3Region,
+		accessKey: sc.S3AccessKey,
+		secretKey: sc.S3SecretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		legacy:    newLegacyImages(l, cfg.ImageDIR),
+		now:       time.Now,
+	}, nil
+}
+
+// Save uploads the content unless an identical image is stored already.
+func (s *S3Storage) Save(file io.Reader, ext string) (*Object, error) {
+	id, err := s.store(file, ext)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stat(id)
+}
+
+// Open returns a reader which fetches the object with range requests, so
+// that seeking doesn't download the whole image.
+func (s *S3Storage) Open(id string) (io.ReadSeekCloser, *Object, error) {
+	obj, err := s.Stat(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, _ := objectKey(id)
+	return &s3Reader{s: s, key: key, size: obj.Size}, obj, nil
+}
+
+func (s *S3Storage) Stat(id string) (*Object, error) {
+	key, err := objectKey(id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil, emptyHash)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &Object{ID: id, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3Storage) Delete(id string) error {
+	// S3 answers a delete of a missing key with success
+	if _, err := s.Stat(id); err != nil {
+		return err
+	}
+	key, _ := objectKey(id)
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, emptyHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through the bucket, keys which aren't images are skipped.
+func (s *S3Storage) List() ([]*Object, error) {
+	objects := []*Object{}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", q, nil, nil, emptyHash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list images: %s", err)
+		}
+		page := &listResult{}
+		err = xml.NewDecoder(resp.Body).Decode(page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list images: %s", err)
+		}
+
+		for _, c := range page.Contents {
+			id := c.Key[strings.LastIndex(c.Key, "/")+1:]
+			if key, err := objectKey(id); err != nil || key != c.Key {
+				continue
+			}
+			objects = append(objects, &Object{ID: id, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Legacy(unixTime, fileName string) (string, error) {
+	return s.legacy.id(unixTime, fileName, s.store)
+}
+
+// store spools the content to a temporary file to learn its id and uploads it.
+func (s *S3Storage) store(file io.Reader, ext string) (string, error) {
+	tmp, id, err := spool(os.TempDir(), file, ext)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := s.Stat(id); err == nil {
+		s.log.Debugf("image %s is stored already", id)
+		return id, nil
+	} else if err != ErrNotFound {
+		return "", err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return "", err
+	}
+	key, _ := objectKey(id)
+	header := http.Header{"Content-Length": {strconv.FormatInt(info.Size(), 10)}}
+	// the id starts with the SHA-256 of the content, which is the payload hash
+	resp, err := s.do(http.MethodPut, key, nil, header, tmp, id[:64])
+	if err != nil {
+		return "", fmt.Errorf("unable to save file: %s", err)
+	}
+	resp.Body.Close()
+	return id, nil
+}
+
+// do sends a signed request for the key of the bucket, non 2xx responses are
+// returned as errors, 404 as ErrNotFound.
+func (s *S3Storage) do(method, key string, query url.Values, header http.Header, body io.Reader, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if cl := req.Header.Get("Content-Length"); cl != "" {
+		req.ContentLength, _ = strconv.ParseInt(cl, 10, 64)
+		req.Header.Del("Content-Length")
+	}
+	s.sign(req, payloadHash)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusPartialContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, key, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// sign adds the AWS signature version 4 headers to the request.
+func (s *S3Storage) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Range") != "" {
+		names = append(names, "range")
+		sort.Strings(names)
+	}
+	canonicalHeaders := ""
+	for _, n := range names {
+		v := req.Header.Get(n)
+		if n == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders += n + ":" + strings.TrimSpace(v) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by key with spaces as %20, as the
+// signature requires.
+func canonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// s3Reader reads an object from the current offset with a range request,
+// which is sent on the first read after a seek.
+type s3Reader struct {
+	s    *S3Storage
+	key  string
+	size int64
+	off  int64
+	body io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", r.off)}}
+		resp, err := r.s.do(http.MethodGet, r.key, nil, header, nil, emptyHash)
+		if err != nil {
+			return 0, err
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek before the start of %s", r.key)
+	}
+	if offset != r.off {
+		r.Close()
+		r.off = offset
+	}
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
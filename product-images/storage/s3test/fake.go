@@ -0,0 +1,199 @@
+// Package s3test provides an in-process fake of the parts of the S3 API the
+// S3 storage uses, for testing without an object store.
+package s3test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a fake S3 endpoint holding a single bucket in memory.
+type Server struct {
+	URL    string
+	Bucket string
+	// AccessKey must be the credential of every request
+	AccessKey string
+	// PageSize is the number of keys listed per page
+	PageSize int
+
+	mutex   *sync.Mutex
+	objects map[string]*object
+}
+
+type object struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewServer starts a fake S3 endpoint which is closed when the test ends.
+func NewServer(t testing.TB, bucket, accessKey string) *Server {
+	t.Helper()
+
+	s := &Server{
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		PageSize:  1000,
+		mutex:     &sync.Mutex{},
+		objects:   map[string]*object{},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL
+	return s
+}
+
+// Keys returns the keys of the stored objects, sorted.
+func (s *Server) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/") || r.Header.Get("X-Amz-Date") == "" {
+		s.error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.Bucket {
+		s.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case r.Method == http.MethodPut:
+		s.put(w, r, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		s.mutex.Lock()
+		delete(s.objects, key)
+		s.mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	if r.ContentLength < 0 {
+		s.error(w, http.StatusLengthRequired, "MissingContentLength")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	sum := sha256.Sum256(data)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		s.error(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+		return
+	}
+
+	s.mutex.Lock()
+	s.objects[key] = &object{data: data, modTime: time.Now().UTC().Truncate(time.Second)}
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	s.mutex.Lock()
+	obj, ok := s.objects[key]
+	s.mutex.Unlock()
+	if !ok {
+		s.error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+		if err != nil || start >= len(data) {
+			s.error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(data)-1, len(data)))
+		data = data[start:]
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+type listResult struct {
+	XMLName               xml.Name  `xml:"ListBucketResult"`
+	Contents              []content `xml:"Contents"`
+	IsTruncated           bool      `xml:"IsTruncated"`
+	NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+}
+
+type content struct {
+	Key          string    `xml:"Key"`
+	Size         int       `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list pages through the keys, the continuation token is the last key listed
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("list-type") != "2" {
+		s.error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	after := r.URL.Query().Get("continuation-token")
+
+	res := &listResult{}
+	for _, k := range s.Keys() {
+		if k <= after {
+			continue
+		}
+		if len(res.Contents) == s.PageSize {
+			res.IsTruncated = true
+			res.NextContinuationToken = res.Contents[len(res.Contents)-1].Key
+			break
+		}
+		s.mutex.Lock()
+		obj := s.objects[k]
+		s.mutex.Unlock()
+		if obj == nil {
+			continue
+		}
+		res.Contents = append(res.Contents, content{Key: k, Size: len(obj.data), LastModified: obj.modTime})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
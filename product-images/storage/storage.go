@@ -1,11 +1,16 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"product-images/configs"
@@ -13,65 +18,146 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	ErrNotFound  = fmt.Errorf("image not found")
+	ErrInvalidID = fmt.Errorf("invalid image id")
+	// ErrSharedPublic refuses to make an image other products show publicly
+	// private by uploading it again
+	ErrSharedPublic = fmt.Errorf("image is public and shared with other products")
+)
+
+// Backends of the Storage, chosen by configs.StorageConf
+const (
+	BackendFile = "file"
+	BackendS3   = "s3"
+)
+
+// Storage stores images content-addressed: an image's id is the hex SHA-256
+// of its content plus its extension. Identical images are stored once.
 type Storage interface {
-	Save(file multipart.File, header *multipart.FileHeader) (string, error)
+	// Save stores the content under its id
+	Save(file io.Reader, ext string) (*Object, error)
+	// Open returns the content of the image, ErrNotFound when it isn't stored
+	Open(id string) (io.ReadSeekCloser, *Object, error)
+	Stat(id string) (*Object, error)
+	Delete(id string) error
+	// List returns every stored image, it walks the whole storage
+	List() ([]*Object, error)
+	// Legacy returns the id of an image stored at the timestamped path used
+	// before content addressing, importing it on first access
+	Legacy(unixTime, fileName string) (string, error)
 }
 
-type FileStorage struct {
-	log      *logrus.Logger
-	cfg      *configs.Config
-	dir      string
-	mediaURI string
+// Object describes a stored image.
+type Object struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
 }
 
-func NewFileStorage(l *logrus.Logger, cfg *configs.Config) *FileStorage {
-	return &FileStorage{
-		log:      l,
-		cfg:      cfg,
-		dir:      cfg.ImageDIR,
-		mediaURI: cfg.MediaURL,
+// New creates the storage backend chosen by the config.
+func New(l *logrus.Logger, cfg *configs.Config) (Storage, error) {
+	switch cfg.StorageCfg.Backend {
+	case "", BackendFile:
+		return NewFileStorage(l, cfg), nil
+	case BackendS3:
+		return NewS3Storage(l, cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageCfg.Backend)
 	}
 }
 
-// Save saves a file to the file system using the provided multipart file and header.
-// It generates a unique filename based on the current timestamp and file extension,
-// creates a directory with the timestamp as its name, and saves the file to that directory.
-// The function returns the complete filepath of the saved file, or an error if it fails to save the file.
-func (s *FileStorage) Save(file multipart.File, header *multipart.FileHeader) (string, error) {
-	defer file.Close()
-
-	// Generate a unique filename
-	filename := header.Filename
-	ext := filepath.Ext(filename)
-	now := time.Now().Unix()
-	filename = fmt.Sprintf("%d%s", now, ext)
-	fileDir := filepath.Join(s.cfg.ImageDIR, fmt.Sprintf("%d", now))
-	fileAbsPath := fmt.Sprintf("%s/%d/%s", s.mediaURI, now, filename)
-
-	// Create the directory if it doesn't exist
-	err := os.MkdirAll(fileDir, os.ModePerm)
+// idPattern matches image ids, the hex SHA-256 of the content and the extension
+var idPattern = regexp.MustCompile(`^([0-9a-f]{64})(\.[a-z0-9]{1,5})?$`)
+
+// legacyPattern matches the timestamped paths images were stored under before
+var legacyPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// objectKey returns the sharded path of the image, {id[0:2]}/{id[2:4]}/{id}.
+func objectKey(id string) (string, error) {
+	if !idPattern.MatchString(id) {
+		return "", ErrInvalidID
+	}
+	return path.Join(id[0:2], id[2:4], id), nil
+}
+
+// spool writes the content to a temporary file in dir, hashing it on the
+// way, and returns the file and the id of the content. The caller removes
+// the file.
+func spool(dir string, file io.Reader, ext string) (*os.File, string, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create directory: %s", err)
+	}
+	tmp, err := os.CreateTemp(dir, "upload-*")
 	if err != nil {
-		return "", fmt.Errorf("unable to create directory: %s", err)
+		return nil, "", fmt.Errorf("unable to create file: %s", err)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", fmt.Errorf("unable to save file: %s", err)
 	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	return tmp, hex.EncodeToString(h.Sum(nil)) + ext, nil
+}
+
+// legacyImages imports the images stored at {dir}/{unixTime}/{fileName}
+// before content addressing.
+type legacyImages struct {
+	log *logrus.Logger
+	dir string
+	// ids maps the legacy paths already imported to their ids
+	ids   map[string]string
+	mutex *sync.Mutex
+}
+
+func newLegacyImages(l *logrus.Logger, dir string) *legacyImages {
+	return &legacyImages{
+		log:   l,
+		dir:   dir,
+		ids:   map[string]string{},
+		mutex: &sync.Mutex{},
+	}
+}
 
-	// Create the file
-	filePathStr := filepath.Join(fileDir, filename)
-	f, err := os.Create(filePathStr)
+// id returns the id of the legacy image, store is called to import it.
+func (li *legacyImages) id(unixTime, fileName string, store func(io.Reader, string) (string, error)) (string, error) {
+	if !legacyPattern.MatchString(unixTime) || fileName != filepath.Base(fileName) {
+		return "", ErrNotFound
+	}
+	key := unixTime + "/" + fileName
+
+	li.mutex.Lock()
+	id, ok := li.ids[key]
+	li.mutex.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	f, err := os.Open(filepath.Join(li.dir, unixTime, fileName))
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
 	if err != nil {
-		return "", fmt.Errorf("unable to create file: %s", err)
+		return "", err
 	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			s.log.Errorln("Log error ")
-		}
-	}(f)
-
-	// Copy the contents of the request body to the file
-	_, err = io.Copy(f, file)
+	defer f.Close()
+
+	id, err = store(f, strings.ToLower(filepath.Ext(fileName)))
 	if err != nil {
-		return "", fmt.Errorf("unable to save file: %s", err)
+		return "", err
 	}
+	li.log.Infof("imported legacy image %s as %s", key, id)
 
-	return fileAbsPath, nil
+	li.mutex.Lock()
+	li.ids[key] = id
+	li.mutex.Unlock()
+	return id, nil
 }
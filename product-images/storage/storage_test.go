@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"product-images/configs"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestStorage(t *testing.T) *FileStorage {
+	return NewFileStorage(logrus.New(), &configs.Config{ImageDIR: t.TempDir(), MediaURL: "/images"})
+}
+
+func TestSaveDedupesContent(t *testing.T) {
+	s := newTestStorage(t)
+
+	first, err := s.Save(strings.NewReader("image"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Save(strings.NewReader("image"), ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256("image")
+	id := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d.png"
+	if first.ID != id || second.ID != id {
+		t.Errorf("expected both uploads as %s, got %s and %s", id, first.ID, second.ID)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, "61", "05", id)); err != nil {
+		t.Errorf("expected the image in a sharded directory: %s", err)
+	}
+
+	if _, err := s.Stat("../../etc/passwd"); err != ErrInvalidID {
+		t.Errorf("expected ErrInvalidID, got %v", err)
+	}
+}
+
+func TestLegacyImport(t *testing.T) {
+	s := newTestStorage(t)
+	if err := os.MkdirAll(filepath.Join(s.dir, "1680000000"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "1680000000", "1680000000.PNG"), []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := s.Legacy("1680000000", "1680000000.PNG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d.png" {
+		t.Errorf("unexpected id %s", id)
+	}
+	if _, err := s.Stat(id); err != nil {
+		t.Errorf("expected the legacy image to be imported, got %v", err)
+	}
+	if _, err := s.Legacy("1680000000", "missing.png"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
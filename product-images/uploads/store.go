@@ -0,0 +1,385 @@
+// Package uploads keeps resumable uploads on disk until all their bytes
+// arrived and they are stored as images.
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotFound       = fmt.Errorf("upload not found")
+	ErrLocked         = fmt.Errorf("upload is being written by another request")
+	ErrOffsetMismatch = fmt.Errorf("offset doesn't match the upload")
+	ErrCapacity       = fmt.Errorf("too many uploads in progress")
+)
+
+// Upload is a resumable upload of Length bytes of which Offset arrived.
+type Upload struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"`
+	Offset int64  `json:"-"`
+	// Metadata is the metadata the client sent with the upload
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	// ImageID is the id of the image stored once the upload finished
+	ImageID string `json:"image_id,omitempty"`
+}
+
+// Complete reports whether all bytes of the upload arrived.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Store keeps the uploads in dir, {id}.info holds the upload as JSON and
+// {id}.bin the bytes received so far.
+type Store struct {
+	log *logrus.Logger
+	dir string
+	// maxUploads and maxBytes bound the number of unfinished uploads and the
+	// sum of their lengths, zero is unbounded
+	maxUploads int
+	maxBytes   int64
+	mutex      *sync.Mutex
+	// creating serializes the creation of uploads, so that the bounds hold
+	creating *sync.Mutex
+	// busy are the uploads being written or finished by a request
+	busy map[string]bool
+}
+
+func NewStore(l *logrus.Logger, dir string, maxUploads int, maxBytes int64) *Store {
+	return &Store{
+		log:        l,
+		dir:        dir,
+		maxUploads: maxUploads,
+		maxBytes:   maxBytes,
+		mutex:      &sync.Mutex{},
+		creating:   &sync.Mutex{},
+		busy:       map[string]bool{},
+	}
+}
+
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Create starts an upload of length bytes. It fails with ErrCapacity when
+// the upload would exceed the number of unfinished uploads or their bytes
+// the store is bounded to.
+func (s *Store) Create(length int64, metadata map[string]string) (*Upload, error) {
+	s.creating.Lock()
+	defer s.creating.Unlock()
+
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("unable to create directory: %s", err)
+	}
+	if s.maxUploads > 0 || s.maxBytes > 0 {
+		n, total, err := s.usage()
+		if err != nil {
+			return nil, err
+		}
+		if (s.maxUploads > 0 && n >= s.maxUploads) || (s.maxBytes > 0 && total+length > s.maxBytes) {
+			return nil, ErrCapacity
+		}
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	u := &Upload{
+		ID:        hex.EncodeToString(b),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	f, err := os.OpenFile(s.path(u.ID, ".bin"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create upload: %s", err)
+	}
+	f.Close()
+	if err := s.writeInfo(u); err != nil {
+		os.Remove(s.path(u.ID, ".bin"))
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get returns the upload along with the number of bytes received.
+func (s *Store) Get(id string) (*Upload, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(id, ".info"))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("unable to parse upload %s: %s", id, err)
+	}
+
+	if u.ImageID != "" {
+		u.Offset = u.Length
+		return u, nil
+	}
+	info, err := os.Stat(s.path(id, ".bin"))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Offset = info.Size()
+	return u, nil
+}
+
+// Append writes the bytes of r to the upload at offset, which has to be the
+// number of bytes received so far. Bytes past the length of the upload are
+// ignored. The bytes written before r fails are kept, so that the client can
+// resume from there. Appending nothing to the end of a finished upload
+// succeeds, so that a client can retry the last request.
+func (s *Store) Append(id string, offset int64, r io.Reader) (*Upload, error) {
+	if err := s.lock(id); err != nil {
+		return nil, err
+	}
+	defer s.unlock(id)
+
+	u, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	if u.ImageID != "" {
+		return u, nil
+	}
+
+	f, err := os.OpenFile(s.path(id, ".bin"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, u.Length-u.Offset))
+	u.Offset += n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return u, err
+}
+
+// Finish passes the bytes of a complete upload to store, which returns the id
+// of the stored image. Only the metadata of the upload is kept afterwards.
+func (s *Store) Finish(id string, store func(f *os.File, size int64) (string, error)) (*Upload, error) {
+	if err := s.lock(id); err != nil {
+		return nil, err
+	}
+	defer s.unlock(id)
+
+	u, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.ImageID != "" {
+		return u, nil
+	}
+	if !u.Complete() {
+		return nil, ErrOffsetMismatch
+	}
+
+	f, err := os.Open(s.path(id, ".bin"))
+	if err != nil {
+		return nil, err
+	}
+	u.ImageID, err = store(f, u.Length)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeInfo(u); err != nil {
+		return nil, err
+	}
+	os.Remove(s.path(id, ".bin"))
+	return u, nil
+}
+
+// Delete removes the upload.
+func (s *Store) Delete(id string) error {
+	if err := s.lock(id); err != nil {
+		return err
+	}
+	defer s.unlock(id)
+
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	return s.remove(id)
+}
+
+// Collect removes the uploads which didn't receive any bytes for maxAge,
+// finished uploads are removed maxAge after they finished.
+func (s *Store) Collect(maxAge time.Duration) (int, error) {
+	files, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to read uploads: %s", err)
+	}
+
+	removed := 0
+	deadline := time.Now().Add(-maxAge)
+	for _, f := range files {
+		id, ext := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())), filepath.Ext(f.Name())
+		if ext == ".tmp" || (ext == ".bin" && !s.exists(id, ".info")) {
+			// left behind by a crash while creating an upload or writing its info
+			if info, err := f.Info(); err == nil && info.ModTime().Before(deadline) {
+				os.Remove(filepath.Join(s.dir, f.Name()))
+			}
+			continue
+		}
+		if ext != ".info" || !idPattern.MatchString(id) {
+			continue
+		}
+		if s.lastModified(id).After(deadline) {
+			continue
+		}
+		if err := s.lock(id); err != nil {
+			continue
+		}
+		err := s.remove(id)
+		s.unlock(id)
+		if err != nil {
+			s.log.Errorf("unable to remove abandoned upload %s: %s", id, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// CollectEvery collects the uploads abandoned for maxAge every interval until
+// the context is done.
+func (s *Store) CollectEvery(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.Collect(maxAge)
+			if err != nil {
+				s.log.Error(err)
+				continue
+			}
+			if n > 0 {
+				s.log.Infof("removed %d abandoned uploads", n)
+			}
+		}
+	}
+}
+
+// usage returns the number of unfinished uploads and the sum of their
+// lengths.
+func (s *Store) usage() (int, int64, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read uploads: %s", err)
+	}
+	n, total := 0, int64(0)
+	for _, f := range files {
+		id, ext := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())), filepath.Ext(f.Name())
+		if ext != ".info" || !idPattern.MatchString(id) {
+			continue
+		}
+		u, err := s.Get(id)
+		if err != nil {
+			// removed or finished meanwhile
+			continue
+		}
+		if u.ImageID == "" {
+			n++
+			total += u.Length
+		}
+	}
+	return n, total, nil
+}
+
+// lastModified returns when the upload last received bytes or finished.
+func (s *Store) lastModified(id string) time.Time {
+	last := time.Time{}
+	for _, ext := range []string{".info", ".bin"} {
+		if info, err := os.Stat(s.path(id, ext)); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+func (s *Store) exists(id, ext string) bool {
+	_, err := os.Stat(s.path(id, ext))
+	return err == nil
+}
+
+func (s *Store) lock(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.busy[id] {
+		return ErrLocked
+	}
+	s.busy[id] = true
+	return nil
+}
+
+func (s *Store) unlock(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.busy, id)
+}
+
+func (s *Store) remove(id string) error {
+	err := os.Remove(s.path(id, ".bin"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(s.path(id, ".info"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeInfo writes the info file next to it and renames it over the former
+// one, so that a crash never leaves a half written file behind.
+func (s *Store) writeInfo(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(u.ID, ".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("unable to write upload %s: %s", u.ID, err)
+	}
+	return os.Rename(tmp, s.path(u.ID, ".info"))
+}
+
+func (s *Store) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
@@ -0,0 +1,133 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// failingReader returns its content and then fails, like a dropped connection
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestResumeAndFinish(t *testing.T) {
+	s := NewStore(logrus.New(), t.TempDir(), 0, 0)
+	u, err := s.Create(10, map[string]string{"product_id": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bytes received before the connection dropped are kept
+	u, err = s.Append(u.ID, 0, &failingReader{strings.NewReader("0123")})
+	if err == nil || u.Offset != 4 {
+		t.Fatalf("expected the interrupted append to keep 4 bytes, got %v and %v", u, err)
+	}
+	if _, err := s.Append(u.ID, 0, strings.NewReader("0123")); err != ErrOffsetMismatch {
+		t.Errorf("expected ErrOffsetMismatch, got %v", err)
+	}
+	u, err = s.Append(u.ID, 4, strings.NewReader("456789-ignored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Complete() {
+		t.Fatalf("expected the upload to be complete at %d", u.Offset)
+	}
+
+	var stored string
+	u, err = s.Finish(u.ID, func(f *os.File, size int64) (string, error) {
+		data, err := io.ReadAll(f)
+		stored = string(data)
+		return "image.png", err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != "0123456789" || u.ImageID != "image.png" {
+		t.Errorf("unexpected upload %q stored as %s", stored, u.ImageID)
+	}
+
+	u, err = s.Get(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ImageID != "image.png" || u.Offset != 10 || u.Metadata["product_id"] != "7" {
+		t.Errorf("unexpected finished upload %+v", u)
+	}
+	if _, err := s.Append(u.ID, 10, strings.NewReader("")); err != nil {
+		t.Errorf("expected retrying the last append to succeed, got %v", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	s := NewStore(logrus.New(), t.TempDir(), 0, 0)
+	abandoned, err := s.Create(10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := s.Create(10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	for _, ext := range []string{".info", ".bin"} {
+		if err := os.Chtimes(s.path(abandoned.ID, ext), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := s.Collect(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 abandoned upload removed, got %d", n)
+	}
+	if _, err := s.Get(abandoned.ID); err != ErrNotFound {
+		t.Errorf("expected the abandoned upload to be removed, got %v", err)
+	}
+	if _, err := s.Get(active.ID); err != nil {
+		t.Errorf("expected the active upload to be kept, got %v", err)
+	}
+}
+
+func TestCreateBounded(t *testing.T) {
+	s := NewStore(logrus.New(), t.TempDir(), 2, 25)
+	first, err := s.Create(10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(20, nil); err != ErrCapacity {
+		t.Errorf("expected the bytes to be bounded, got %v", err)
+	}
+	if _, err := s.Create(10, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(1, nil); err != ErrCapacity {
+		t.Errorf("expected the uploads to be bounded, got %v", err)
+	}
+
+	// finished uploads don't count
+	if _, err := s.Append(first.ID, 0, strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Finish(first.ID, func(*os.File, int64) (string, error) { return "image.png", nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(10, nil); err != nil {
+		t.Errorf("expected a finished upload to free its place, got %v", err)
+	}
+}